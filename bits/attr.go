@@ -0,0 +1,181 @@
+package bits
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+var (
+	//PolicyAttributes are the git attributes that determine how the
+	//content of a path is chunked and stored
+	PolicyAttributes = []string{"bits-chunk-size", "bits-compress", "bits-encrypt", "bits-remote"}
+
+	//remote names are used in scan output and shouldnt look like a number
+	remoteNameExp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+)
+
+//Policy describes how the chunks of a certain path are created and
+//stored, it is configured through the git attributes of the path
+type Policy struct {
+
+	//average chunk size, zero means the configured sizes are used
+	ChunkSize uint64
+
+	//compress chunk content before it is encrypted
+	Compress bool
+
+	//encrypt chunk content with its key
+	Encrypt bool
+
+	//name of the remote that stores the chunks, empty for the default
+	Remote string
+
+	//the path that the policy applies to, empty for the default policy
+	Path string
+
+	//why the chunk size attribute of the path can't be used, if it can't
+	invalid error
+}
+
+//DefaultPolicy returns the policy for paths without any attributes
+func DefaultPolicy() *Policy {
+	return &Policy{Encrypt: true}
+}
+
+//Conf returns the chunking configuration that applies to the policy
+func (pol *Policy) Conf(conf *Conf) *Conf {
+	if pol.ChunkSize == 0 {
+		return conf
+	}
+
+	pconf := *conf
+	pconf.ChunkAvgSize = pol.ChunkSize
+	pconf.ChunkMinSize = pol.ChunkSize / 2
+	pconf.ChunkMaxSize = pol.ChunkSize * 8
+	return &pconf
+}
+
+//Validate returns an error if content of the path can't be chunked with the
+//policy, e.g. because its chunk size attribute is not a power of two. It is
+//checked when content is split such that only that path fails
+func (pol *Policy) Validate(conf *Conf) error {
+	if pol.invalid != nil {
+		return pol.invalid
+	}
+
+	err := pol.Conf(conf).ValidateChunking()
+	if err != nil {
+		return fmt.Errorf("invalid chunk size attribute for '%s': %v", pol.Path, err)
+	}
+
+	return nil
+}
+
+//Policy reads the git attributes of 'path' and returns how its content
+//should be chunked and stored
+func (repo *Repository) Policy(path string) (pol *Policy, err error) {
+	if path == "" {
+		return DefaultPolicy(), nil
+	}
+
+	pols, err := repo.Policies([]string{path})
+	if err != nil {
+		return nil, err
+	}
+
+	return pols[path], nil
+}
+
+//Policies reads the git attributes of every path in 'paths' at once and
+//returns how the content of each should be chunked and stored
+func (repo *Repository) Policies(paths []string) (pols map[string]*Policy, err error) {
+	return repo.checkPolicies(nil, false, paths)
+}
+
+//checkPolicies runs a single check-attr for 'paths' with 'env' added to its
+//environment. With 'cached' the attributes are read from the index only,
+//e.g. one that holds the tree of another commit
+func (repo *Repository) checkPolicies(env []string, cached bool, paths []string) (pols map[string]*Policy, err error) {
+	pols = map[string]*Policy{}
+	in, order := bytes.NewBuffer(nil), []string{}
+	for _, p := range paths {
+		if p == "" {
+			pols[p] = DefaultPolicy()
+			continue
+		}
+
+		if _, ok := pols[p]; !ok {
+			pols[p] = DefaultPolicy()
+			pols[p].Path = p
+			order = append(order, p)
+			fmt.Fprintf(in, "%s\x00", p)
+		}
+	}
+
+	if in.Len() == 0 {
+		return pols, nil
+	}
+
+	args := []string{"check-attr", "-z", "--stdin"}
+	if cached {
+		args = append(args, "--cached")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = repo.GitEnv(context.Background(), env, in, buf, append(args, PolicyAttributes...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check attributes: %v", err)
+	}
+
+	//output is: <path> NUL <attribute> NUL <info> NUL, for every attribute
+	//of every path in order. Git normalizes the paths so they are not used
+	fields := strings.Split(buf.String(), "\x00")
+	for i := 0; i+2 < len(fields) && i/3/len(PolicyAttributes) < len(order); i += 3 {
+		path, attr, info := order[i/3/len(PolicyAttributes)], fields[i+1], fields[i+2]
+		pol := pols[path]
+		if info == "unspecified" {
+			continue
+		}
+
+		switch attr {
+		case "bits-chunk-size":
+			pol.ChunkSize, err = humanize.ParseBytes(info)
+			if err != nil {
+				pol.invalid, err = fmt.Errorf("unexpected format for attribute '%s=%s' of '%s', expected a size in bytes that is a power of two", attr, info, path), nil
+			}
+		case "bits-compress":
+			pol.Compress, err = attrBool(attr, info)
+		case "bits-encrypt":
+			pol.Encrypt, err = attrBool(attr, info)
+		case "bits-remote":
+			if !remoteNameExp.MatchString(info) {
+				return nil, fmt.Errorf("invalid remote name '%s' for '%s', it should start with a letter", info, path)
+			}
+
+			pol.Remote = info
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute for '%s': %v", path, err)
+		}
+	}
+
+	return pols, nil
+}
+
+//attrBool interprets a set, unset or boolean valued attribute
+func attrBool(attr, info string) (bool, error) {
+	switch info {
+	case "set", "true":
+		return true, nil
+	case "unset", "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected value '%s' for attribute '%s', expected a boolean", info, attr)
+	}
+}
@@ -0,0 +1,203 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeAttributes(t *testing.T, repo *Repository, lines ...string) {
+	err := os.WriteFile(filepath.Join(repo.rootDir, ".gitattributes"), []byte(strings.Join(lines, "\n")+"\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicyFromAttributes(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	writeAttributes(t, repo,
+		"*.psd filter=bits bits-chunk-size=64KiB bits-compress",
+		"*.mp4 filter=bits -bits-encrypt",
+		"public/** bits-remote=public",
+	)
+
+	pol, err := repo.Policy("art/cover.psd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pol.ChunkSize != 64*1024 || !pol.Compress || !pol.Encrypt || pol.Remote != "" {
+		t.Errorf("unexpected policy for psd file: %+v", pol)
+	}
+
+	pol, err = repo.Policy("public/video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pol.ChunkSize != 0 || pol.Compress || pol.Encrypt || pol.Remote != "public" {
+		t.Errorf("unexpected policy for public mp4 file: %+v", pol)
+	}
+
+	writeAttributes(t, repo, "*.bin bits-remote=123")
+	_, err = repo.Policy("file.bin")
+	if err == nil {
+		t.Error("expected a numeric remote name to be rejected")
+	}
+}
+
+func TestInvalidChunkSizeAttribute(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	writeAttributes(t, repo, "*.psd bits-chunk-size=100k", "*.raw bits-chunk-size=big")
+
+	pols, err := repo.Policies([]string{"a.psd", "b.raw", "c.bin"})
+	if err != nil {
+		t.Fatalf("expected other paths to keep working with an invalid chunk size: %v", err)
+	}
+
+	for _, p := range []string{"a.psd", "b.raw"} {
+		err = repo.SplitWith(pols[p], strings.NewReader("content"), bytes.NewBuffer(nil))
+		if err == nil || !strings.Contains(err.Error(), p) {
+			t.Errorf("expected splitting '%s' to fail for that path, got: %v", p, err)
+		}
+	}
+
+	if err = repo.SplitWith(pols["c.bin"], strings.NewReader("content"), bytes.NewBuffer(nil)); err != nil {
+		t.Errorf("expected a path without the attribute to be split: %v", err)
+	}
+}
+
+func TestSplitCombinePolicies(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	data := bytes.Repeat([]byte("compressible content "), 64*1024)
+	for _, pol := range []*Policy{
+		{Compress: true, Encrypt: true},
+		{Compress: true, Encrypt: false},
+		{Compress: false, Encrypt: false, ChunkSize: 64 * 1024},
+	} {
+		ptr := bytes.NewBuffer(nil)
+		err := repo.SplitWith(pol, bytes.NewReader(data), ptr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := bytes.NewBuffer(nil)
+		err = repo.Combine(bytes.NewReader(ptr.Bytes()), out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.Bytes(), data) {
+			t.Errorf("combined content differs for policy %+v", pol)
+		}
+
+		//clear the local chunks so the next policy writes them again
+		os.RemoveAll(repo.chunkDir)
+	}
+}
+
+func TestScanPushNamedRemote(t *testing.T) {
+	remote, public := newMemRemote(), newMemRemote()
+	repo := newTestRepository(t, remote)
+	repo.remotes = map[string]Remote{"public": public}
+	writeAttributes(t, repo, "public/** bits-remote=public")
+
+	for _, p := range []string{"private.bin", "public/open.bin"} {
+		data := make([]byte, 1024)
+		rand.Read(data)
+		ptr := bytes.NewBuffer(nil)
+		err := repo.Split(bytes.NewReader(data), ptr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		os.MkdirAll(filepath.Dir(filepath.Join(repo.rootDir, p)), 0777)
+		err = os.WriteFile(filepath.Join(repo.rootDir, p), ptr.Bytes(), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := runCommand(repo.rootDir, "git", "add", "-A"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runCommand(repo.rootDir, "git", "commit", "-m", "c0"); err != nil {
+		t.Fatal(err)
+	}
+
+	scanned := bytes.NewBuffer(nil)
+	err := repo.Scan("", "HEAD", scanned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(scanned.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two keys to be scanned, got: %v", lines)
+	}
+
+	store, err := repo.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+	err = repo.Push(store, scanned, "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if remote.count("pack/", ".idx") != 1 || public.count("pack/", ".idx") != 1 {
+		t.Errorf("expected each remote to receive a single pack, got %d and %d", remote.count("pack/", ".idx"), public.count("pack/", ".idx"))
+	}
+}
+
+func TestPushOnlyNamedRemote(t *testing.T) {
+	public := newMemRemote()
+	repo := newTestRepository(t, nil)
+	repo.remotes = map[string]Remote{"public": public}
+
+	data := make([]byte, 1024)
+	rand.Read(data)
+	ptr := bytes.NewBuffer(nil)
+	err := repo.Split(bytes.NewReader(data), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := bytes.NewBuffer(nil)
+	err = repo.ForEach(bytes.NewReader(ptr.Bytes()), func(k K) error {
+		_, err := fmt.Fprintf(keys, "%x public\n", k)
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := repo.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+	plan, err := repo.PushPlan(store, bytes.NewReader(keys.Bytes()))
+	if err != nil || len(plan.Chunks) != 1 {
+		t.Errorf("expected a plan without a default remote, got %v", err)
+	}
+
+	err = repo.Push(store, bytes.NewReader(keys.Bytes()), "origin")
+	if err != nil || public.count("pack/", ".idx") != 1 {
+		t.Errorf("expected a push to the named remote without a default remote: %v", err)
+	}
+
+	//keys for the default remote still need it
+	if err = repo.Push(store, bytes.NewReader(ptr.Bytes()), "origin"); err == nil {
+		t.Errorf("expected a push to the missing default remote to fail")
+	}
+}
@@ -19,6 +19,7 @@ type KeyOp struct {
 	K       K
 	Skipped bool
 	CopyN   int64 //if any bytes were copied in the operation, its recorded here
+	Path    string //the file that the chunk belongs to, if known
 }
 
 var (
@@ -33,6 +34,9 @@ var (
 
 	//IndexOp tells a remote chunk is indexed
 	IndexOp = Op("index")
+
+	//CombineOp tells a chunk is combined into a file
+	CombineOp = Op("combine")
 )
 
 //K are 32-byte chunk keys, de-duplicated lookups and
@@ -40,9 +44,22 @@ var (
 //a (cryptographic) hash of plain-text chunk content
 type K [KeySize]byte
 
-//Remote describes a method for streaming chunk information
+//Remote describes a method for streaming chunk information, chunks are
+//either stored as a single object per key or bundled together in packs.
+//Probes are objects outside of the chunks that are written to check access
 type Remote interface {
 	ChunkReader(k K) (rc io.ReadCloser, err error)
 	ChunkWriter(k K) (wc io.WriteCloser, err error)
 	ListChunks(w io.Writer) (err error)
+
+	PackReader(p K, off, n int64) (rc io.ReadCloser, err error)
+	PackWriter(p K) (wc io.WriteCloser, err error)
+	PackIndexReader(p K) (rc io.ReadCloser, err error)
+	PackIndexWriter(p K) (wc io.WriteCloser, err error)
+	ListPacks(w io.Writer) (err error)
+
+	ProbeReader(name string) (rc io.ReadCloser, err error)
+	ProbeWriter(name string) (wc io.WriteCloser, err error)
+	ListProbes(w io.Writer) (err error)
+	DeleteProbe(name string) (err error)
 }
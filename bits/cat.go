@@ -0,0 +1,54 @@
+package bits
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//Cat writes the content of the blob named by 'spec', e.g. <rev>:<path>, to
+//'w'. A pointer is combined into its content and missing chunks are fetched,
+//unless 'raw' is set. Neither the working tree nor the index is touched
+func (repo *Repository) Cat(spec string, raw bool, w io.Writer) (err error) {
+	ctx := context.Background()
+	obj := repo.gitOutput(ctx, "rev-parse", "-q", "--verify", spec)
+	if obj == "" || repo.gitOutput(ctx, "cat-file", "-t", obj) != "blob" {
+		return fmt.Errorf("'%s' doesn't name a file, expected <rev>:<path>", spec)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(repo.Git(ctx, nil, pw, "cat-file", "blob", obj))
+	}()
+
+	defer pr.Close()
+	bufr := bufio.NewReader(pr)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); raw || !pointer.IsPointer(hdr) {
+		_, err = io.Copy(w, bufr)
+		return err
+	}
+
+	//the attributes of the path determine the remote of its chunks
+	_, p, _ := strings.Cut(spec, ":")
+	pol, err := repo.Policy(p)
+	if err != nil {
+		return err
+	}
+
+	fr, fw := io.Pipe()
+	go func() {
+		fw.CloseWithError(repo.fetchFrom(pol.Remote, pol.Path, bufr, fw))
+	}()
+
+	defer fr.Close()
+	err = repo.Combine(fr, w)
+	if err != nil {
+		return fmt.Errorf("failed to combine '%s': %v", spec, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCat(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	writeAttributes(t, repo, "*.bin filter=bits")
+
+	versions := [][]byte{}
+	for i := 0; i < 2; i++ {
+		data := make([]byte, 16*1024)
+		rand.Read(data)
+		versions = append(versions, data)
+		os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), data, 0666)
+		testGit(t, repo, "add", "-A")
+		testGit(t, repo, "commit", "-m", "c")
+	}
+
+	//the chunks of the first version are only stored on the remote
+	keys := bytes.NewBuffer(nil)
+	err := repo.Scan("", "HEAD", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := repo.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Push(store, bytes.NewReader(keys.Bytes()), "origin")
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.ForEach(keys, func(k K) error {
+		p, _ := repo.Path(k, false)
+		return os.Remove(p)
+	})
+
+	out := bytes.NewBuffer(nil)
+	err = repo.Cat("HEAD~1:a.bin", false, out)
+	if err != nil || !bytes.Equal(out.Bytes(), versions[0]) {
+		t.Errorf("expected the content of the first version, got %d bytes: %v", out.Len(), err)
+	}
+
+	out.Reset()
+	err = repo.Cat("HEAD~1:a.bin", true, out)
+	if err != nil || out.String() != testGit(t, repo, "show", "HEAD~1:a.bin") {
+		t.Errorf("expected the pointer of the first version, got '%s': %v", out.String(), err)
+	}
+
+	out.Reset()
+	err = repo.Cat("HEAD:.gitattributes", false, out)
+	if err != nil || out.String() != "*.bin filter=bits\n" {
+		t.Errorf("expected content that isn't a pointer as is, got '%s': %v", out.String(), err)
+	}
+
+	if err = repo.Cat("HEAD", false, out); err == nil {
+		t.Error("expected a commit to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+package bits
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+)
+
+var (
+	//chunkMagic starts chunks that describe their own encoding. Chunks
+	//without it are encrypted and uncompressed, as written by earlier
+	//versions
+	chunkMagic = []byte("gitbits\x01")
+)
+
+const (
+	//chunkCompressed flags chunks with deflate compressed content
+	chunkCompressed byte = 1 << iota
+
+	//chunkEncrypted flags chunks with encrypted content
+	chunkEncrypted
+)
+
+//chunkStream sets up the aes stream for the chunk with key 'k'
+func chunkStream(k K) (cipher.Stream, error) {
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher for key '%x': %v", k, err)
+	}
+
+	//@TODO use GCM cipher mode
+	//@TODO	If the key is unique for each ciphertext, then it's ok to use a zero IV.
+	var iv [aes.BlockSize]byte
+	return cipher.NewOFB(block, iv[:]), nil
+}
+
+//writeChunk encodes the plain chunk 'data' with key 'k' to writer 'w' as
+//described by the policy. The default policy writes the format of earlier
+//versions such that they can still read the chunk
+func writeChunk(k K, data []byte, pol *Policy, w io.Writer) (err error) {
+	flags := byte(0)
+	if pol.Compress {
+		flags |= chunkCompressed
+	}
+
+	if pol.Encrypt {
+		flags |= chunkEncrypted
+	}
+
+	if flags != chunkEncrypted {
+		_, err = w.Write(append(append([]byte{}, chunkMagic...), flags))
+		if err != nil {
+			return fmt.Errorf("failed to write chunk header: %v", err)
+		}
+	}
+
+	if pol.Encrypt {
+		stream, err := chunkStream(k)
+		if err != nil {
+			return err
+		}
+
+		w = &cipher.StreamWriter{S: stream, W: w}
+	}
+
+	if !pol.Compress {
+		_, err = w.Write(data)
+		return err
+	}
+
+	compw, err := flate.NewWriter(w, flate.DefaultCompression)
+	if err != nil {
+		return fmt.Errorf("failed to setup compression: %v", err)
+	}
+
+	_, err = compw.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return compw.Close()
+}
+
+//readChunk decodes the chunk with key 'k' from reader 'r' and writes the
+//plain content to writer 'w'
+func readChunk(k K, r io.Reader, w io.Writer) (n int64, err error) {
+	bufr := bufio.NewReader(r)
+	flags := chunkEncrypted
+	hdr, _ := bufr.Peek(len(chunkMagic) + 1)
+	if len(hdr) == len(chunkMagic)+1 && bytes.Equal(hdr[:len(chunkMagic)], chunkMagic) {
+		flags = hdr[len(chunkMagic)]
+		bufr.Discard(len(hdr))
+	}
+
+	r = bufr
+	if flags&chunkEncrypted != 0 {
+		stream, err := chunkStream(k)
+		if err != nil {
+			return 0, err
+		}
+
+		r = &cipher.StreamReader{S: stream, R: r}
+	}
+
+	if flags&chunkCompressed != 0 {
+		decompr := flate.NewReader(r)
+		defer decompr.Close()
+		r = decompr
+	}
+
+	return io.Copy(w, r)
+}
@@ -0,0 +1,191 @@
+package bits
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/restic/chunker"
+)
+
+var (
+	//RabinChunking splits content using a rabin fingerprint over a 64 byte
+	//window, as implemented by restic. This is the default
+	RabinChunking = "rabin"
+
+	//FastCDCChunking splits content with a gear based rolling hash and
+	//normalized chunk sizes, it is considerably faster than rabin chunking
+	FastCDCChunking = "fastcdc"
+)
+
+//Chunker splits a stream of bytes into content defined chunks
+type Chunker interface {
+
+	//Next returns the next chunk, using 'buf' as storage if it is large
+	//enough. It returns io.EOF when no more chunks can be read
+	Next(buf []byte) (Chunk, error)
+}
+
+//NewChunker returns the chunker that was configured for reading from 'r'
+func NewChunker(r io.Reader, conf *Conf) (c Chunker, err error) {
+	err = conf.ValidateChunking()
+	if err != nil {
+		return nil, err
+	}
+
+	switch conf.ChunkingAlgorithm {
+	case RabinChunking, "":
+		rc := chunker.NewWithBoundaries(r, chunker.Pol(conf.DeduplicationScope), uint(conf.ChunkMinSize), uint(conf.ChunkMaxSize))
+		rc.SetAverageBits(bits.Len64(conf.ChunkAvgSize) - 1)
+		return &rabinChunker{rc}, nil
+	case FastCDCChunking:
+		return newFastCDC(r, conf.DeduplicationScope, conf.ChunkMinSize, conf.ChunkAvgSize, conf.ChunkMaxSize), nil
+	default:
+		return nil, fmt.Errorf("unknown chunking algorithm '%s'", conf.ChunkingAlgorithm)
+	}
+}
+
+//rabinChunker adapts the restic chunker
+type rabinChunker struct {
+	c *chunker.Chunker
+}
+
+func (rc *rabinChunker) Next(buf []byte) (Chunk, error) {
+	chunk, err := rc.c.Next(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return chunk.Data, nil
+}
+
+//fastCDC implements the FastCDC algorithm with normalized chunking, as
+//described by Xia et al. (2016). The gear table is derived from the
+//deduplication scope such that different scopes produce different boundaries
+type fastCDC struct {
+	r   io.Reader
+	buf []byte
+	pos int
+	end int
+	eof bool
+
+	min, avg, max int
+	maskS, maskL  uint64
+	gear          [256]uint64
+}
+
+func newFastCDC(r io.Reader, scope, min, avg, max uint64) *fastCDC {
+	c := &fastCDC{
+		r:   r,
+		buf: make([]byte, max),
+		min: int(min),
+		avg: int(avg),
+		max: int(max),
+	}
+
+	//a harder to match mask before the average size and an easier one
+	//after it, this narrows the distribution of chunk sizes. Masks use
+	//the high bits as these depend on the most bytes in the window
+	avgBits := bits.Len64(avg) - 1
+	c.maskS = highBits(avgBits + 2)
+	c.maskL = highBits(avgBits - 2)
+
+	//splitmix64 seeded with the scope fills the gear table
+	seed := scope
+	for i := range c.gear {
+		seed += 0x9E3779B97F4A7C15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		c.gear[i] = z ^ (z >> 31)
+	}
+
+	return c
+}
+
+//highBits returns a mask with the 'n' most significant bits set
+func highBits(n int) uint64 {
+	if n < 1 {
+		n = 1
+	}
+
+	return ^uint64(0) << uint(64-n)
+}
+
+//fill moves unread bytes to the front of the buffer and reads until it is
+//full or the reader is exhausted
+func (c *fastCDC) fill() error {
+	if c.eof {
+		return nil
+	}
+
+	n := copy(c.buf, c.buf[c.pos:c.end])
+	c.pos, c.end = 0, n
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//cut returns the length of the first chunk in 'data'
+func (c *fastCDC) cut(data []byte) int {
+	n := len(data)
+	if n <= c.min {
+		return n
+	}
+
+	if n > c.max {
+		n = c.max
+	}
+
+	normal := c.avg
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + c.gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < n; i++ {
+		fp = (fp << 1) + c.gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
+
+func (c *fastCDC) Next(buf []byte) (Chunk, error) {
+	if c.end-c.pos < c.max {
+		err := c.fill()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.pos == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.pos:c.end])
+	chunk := append(buf[:0], c.buf[c.pos:c.pos+n]...)
+	c.pos += n
+	return chunk, nil
+}
@@ -0,0 +1,95 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func chunkAll(t *testing.T, conf *Conf, data []byte) (sizes []int) {
+	c, err := NewChunker(bytes.NewReader(data), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, conf.ChunkMaxSize)
+	total := []byte{}
+	for {
+		chunk, err := c.Next(buf)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sizes = append(sizes, len(chunk))
+		total = append(total, chunk...)
+	}
+
+	if !bytes.Equal(total, data) {
+		t.Errorf("%s: concatenated chunks differ from the input", conf.ChunkingAlgorithm)
+	}
+
+	return sizes
+}
+
+func TestChunkerBoundaries(t *testing.T) {
+	data := make([]byte, 4*1024*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alg := range []string{RabinChunking, FastCDCChunking} {
+		conf := DefaultConf()
+		conf.ChunkingAlgorithm = alg
+		conf.ChunkMinSize = 16 * 1024
+		conf.ChunkAvgSize = 64 * 1024
+		conf.ChunkMaxSize = 256 * 1024
+
+		sizes := chunkAll(t, conf, data)
+		if len(sizes) < 8 {
+			t.Errorf("%s: expected many chunks for configured sizes, got %d", alg, len(sizes))
+		}
+
+		for i, size := range sizes {
+			if size > int(conf.ChunkMaxSize) || (size < int(conf.ChunkMinSize) && i != len(sizes)-1) {
+				t.Errorf("%s: chunk %d has size %d outside of configured bounds", alg, i, size)
+			}
+		}
+	}
+}
+
+func TestFastCDCDeterministic(t *testing.T) {
+	data := make([]byte, 1024*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := DefaultConf()
+	conf.ChunkingAlgorithm = FastCDCChunking
+	conf.ChunkMinSize = 8 * 1024
+	conf.ChunkAvgSize = 32 * 1024
+	conf.ChunkMaxSize = 128 * 1024
+
+	a := chunkAll(t, conf, data)
+	b := chunkAll(t, conf, data)
+	if len(a) != len(b) {
+		t.Fatalf("chunking the same data twice should give the same chunks")
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("chunk %d differs in size: %d != %d", i, a[i], b[i])
+		}
+	}
+
+	//an insertion at the start should only affect the first chunks
+	shifted := append([]byte{0x01, 0x02, 0x03}, data...)
+	c := chunkAll(t, conf, shifted)
+	if a[len(a)-1] != c[len(c)-1] {
+		t.Errorf("expected boundaries to resynchronize after an insertion")
+	}
+}
@@ -0,0 +1,175 @@
+package bits
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//filterConf is the git configuration of the bits filter and the diff and
+//merge drivers that paths select with the 'diff=bits' and 'merge=bits'
+//attributes
+var filterConf = map[string]string{
+	"filter.bits.clean":    "git bits split -- %f",
+	"filter.bits.smudge":   "git bits smudge -- %f",
+	"filter.bits.required": "true",
+	"diff.bits.textconv":   "git bits textconv",
+	"merge.bits.name":      "git-bits pointer merge",
+	"merge.bits.driver":    "git bits merge-driver %O %A %B %P",
+}
+
+//remoteKey is a chunk key and the name of the remote that stores it
+type remoteKey struct {
+	name string
+	k    K
+}
+
+//pathKey is a chunk key to fetch for the file at 'path'
+type pathKey struct {
+	remoteKey
+	path string
+}
+
+//FetchTree fetches the chunks of the pointers in 'ref' at the paths that
+//match 'pathspecs', if any, and are selected by 'filter' with 'jobs' fetches
+//running concurrently. Chunks that are stored locally are skipped such that an
+//interrupted fetch resumes where it left off, a chunk that can't be fetched
+//doesn't stop the others
+func (repo *Repository) FetchTree(ref string, pathspecs []string, filter *PathFilter, jobs int) (err error) {
+	errs := []string{}
+	var errsMu sync.Mutex
+	var wg sync.WaitGroup
+	keys := make(chan pathKey)
+	for i := 0; i < max(jobs, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pk := range keys {
+				err := repo.fetchChunk(pk.name, pk.path, pk.k)
+				if err != nil {
+					errsMu.Lock()
+					errs = append(errs, err.Error())
+					errsMu.Unlock()
+				}
+			}
+		}()
+	}
+
+	seen := map[remoteKey]bool{}
+	err = repo.refPointers(ref, pathspecs, filter, func(pol *Policy, data []byte) error {
+		ptr, err := pointer.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decode pointer of '%s': %v", pol.Path, err)
+		}
+
+		for _, c := range ptr.Chunks {
+			rk := remoteKey{pol.Remote, K(c.Key)}
+			if !seen[rk] {
+				seen[rk] = true
+				repo.addTotal(FetchOp, 1, c.Size)
+				keys <- pathKey{rk, pol.Path}
+			}
+		}
+
+		return nil
+	})
+
+	close(keys)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to fetch %d chunks: \n\t%s", len(errs), strings.Join(errs, "\n\t"))
+	}
+
+	return nil
+}
+
+//Clone clones the repository at 'url' into directory 'dir' with the smudge
+//filter skipped such that files are checked out as pointers, 'args' are
+//passed on to git clone. The pre-push hook is installed such that new commits
+//push their chunks. The chunks are then fetched by 'jobs' concurrent fetches
+//before the files are combined, rerunning `git bits pull` resumes an
+//interrupted clone
+func Clone(url, dir string, args []string, jobs int, output, w io.Writer) (repo *Repository, err error) {
+	exe, err := exec.LookPath("git")
+	if err != nil {
+		return nil, fmt.Errorf("git executable couldn't be found in your PATH: %v, make sure git it installed", err)
+	}
+
+	if dir == "" {
+		dir = cloneDir(url)
+	}
+
+	cargs := []string{"clone"}
+	for k, v := range filterConf {
+		cargs = append(cargs, "-c", k+"="+v)
+	}
+
+	cmd := exec.Command(exe, append(append(cargs, args...), "--", url, dir)...)
+	cmd.Env = append(os.Environ(), SkipSmudgeEnv+"=1")
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone '%s': %v", url, err)
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err = NewRepository(dir, output)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.installHook("pre-push", w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to install pre-push hook: %v", err)
+	}
+
+	if repo.remote == nil && len(repo.conf.Remotes) == 0 {
+		fmt.Fprintf(w, "no chunk remote is configured, run 'git bits install' in '%s' to combine the files\n", dir)
+		return repo, nil
+	}
+
+	return repo, repo.pullClone(jobs, w)
+}
+
+//pullClone fetches the chunks of the files that a clone checked out as
+//pointers with 'jobs' concurrent fetches and combines the files
+func (repo *Repository) pullClone(jobs int, w io.Writer) (err error) {
+	filter, err := repo.FetchFilter()
+	if err != nil {
+		return err
+	}
+
+	ferr := repo.FetchTree("HEAD", nil, filter, jobs)
+	err = repo.PullWith("HEAD", nil, filter, w)
+	if ferr != nil {
+		return fmt.Errorf("%v, run 'git bits pull' in '%s' to retry", ferr, repo.rootDir)
+	}
+
+	return err
+}
+
+//cloneDir returns the directory that git clone uses for 'url'
+func cloneDir(url string) string {
+	url = strings.TrimSuffix(strings.TrimRight(url, "/"), "/.git")
+	if i := strings.LastIndexAny(url, "/:"); i >= 0 {
+		url = url[i+1:]
+	}
+
+	return strings.TrimSuffix(url, ".git")
+}
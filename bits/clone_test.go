@@ -0,0 +1,202 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+func TestSkipSmudge(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	ptr := bytes.NewBuffer(nil)
+	err := repo.Split(strings.NewReader(strings.Repeat("x", 1024)), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, env := range []string{"1", "true"} {
+		t.Setenv(SkipSmudgeEnv, env)
+		out := bytes.NewBuffer(nil)
+		err = repo.Smudge("a.bin", bytes.NewReader(ptr.Bytes()), out)
+		if err != nil || !bytes.Equal(out.Bytes(), ptr.Bytes()) {
+			t.Errorf("expected the pointer as is with %s=%s, got '%s': %v", SkipSmudgeEnv, env, out.String(), err)
+		}
+	}
+
+	t.Setenv(SkipSmudgeEnv, "0")
+	repo.conf.SkipSmudge = true
+	if skip, err := repo.SkipSmudge(); skip || err != nil {
+		t.Errorf("expected the environment to take precedence over the configuration: %v", err)
+	}
+
+	t.Setenv(SkipSmudgeEnv, "maybe")
+	err = repo.Smudge("a.bin", bytes.NewReader(ptr.Bytes()), bytes.NewBuffer(nil))
+	if err == nil || !strings.Contains(err.Error(), SkipSmudgeEnv) {
+		t.Errorf("expected a value that isn't a boolean to fail, got: %v", err)
+	}
+}
+
+func TestFetchTree(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	writeAttributes(t, repo, "*.bin filter=bits")
+	for _, name := range []string{"a.bin", "b.bin", "c.txt"} {
+		data := make([]byte, 16*1024)
+		rand.Read(data)
+		os.WriteFile(filepath.Join(repo.rootDir, name), data, 0666)
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+
+	keys := bytes.NewBuffer(nil)
+	err := repo.Scan("", "HEAD", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := repo.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Push(store, bytes.NewReader(keys.Bytes()), "origin")
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//one chunk is stored already as if an earlier fetch was interrupted
+	n := 0
+	err = repo.ForEach(bytes.NewReader(keys.Bytes()), func(k K) error {
+		if n++; n == 1 {
+			return nil
+		}
+
+		p, _ := repo.Path(k, false)
+		return os.Remove(p)
+	})
+
+	if err != nil || n != 2 {
+		t.Fatalf("expected two chunks, got %d: %v", n, err)
+	}
+
+	err = repo.FetchTree("HEAD", nil, nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.ForEach(bytes.NewReader(keys.Bytes()), func(k K) error {
+		p, _ := repo.Path(k, false)
+		_, err := os.Stat(p)
+		return err
+	})
+
+	if err != nil {
+		t.Errorf("expected all chunks to be fetched: %v", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	src := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, src)
+	writeAttributes(t, src, "*.bin filter=bits")
+	os.WriteFile(filepath.Join(src.rootDir, "a.bin"), []byte(strings.Repeat("x", 1024)), 0666)
+	testGit(t, src, "add", "-A")
+	testGit(t, src, "commit", "-m", "c0")
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	out := bytes.NewBuffer(nil)
+	repo, err := Clone(src.rootDir, dir, []string{"-q"}, 2, io.Discard, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(repo.rootDir, "a.bin")); !pointer.IsPointer(data) {
+		t.Errorf("expected the file to be checked out as a pointer, got '%s'", data)
+	}
+
+	if !strings.Contains(out.String(), "git bits install") {
+		t.Errorf("expected a hint to install without a chunk remote, got: %s", out.String())
+	}
+
+	if smudge := testGit(t, repo, "config", "filter.bits.smudge"); strings.TrimSpace(smudge) != filterConf["filter.bits.smudge"] {
+		t.Errorf("expected the clone to configure the filter, got: %s", smudge)
+	}
+
+	if hook, _ := os.ReadFile(repo.hookPath("pre-push")); !strings.Contains(string(hook), hookBegin) {
+		t.Errorf("expected the clone to install the pre-push hook, got: %s", hook)
+	}
+}
+
+func TestCloneWithRemote(t *testing.T) {
+	remote := newMemRemote()
+	src := newTestRepository(t, remote)
+	installCleanFilter(t, src)
+	writeAttributes(t, src, "*.bin filter=bits")
+	contents := map[string][]byte{}
+	for _, name := range []string{"a.bin", "b.bin"} {
+		contents[name] = make([]byte, 16*1024)
+		rand.Read(contents[name])
+		os.WriteFile(filepath.Join(src.rootDir, name), contents[name], 0666)
+	}
+
+	testGit(t, src, "add", "-A")
+	testGit(t, src, "commit", "-m", "c0")
+
+	keys := bytes.NewBuffer(nil)
+	err := src.Scan("", "HEAD", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := src.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = src.Push(store, bytes.NewReader(keys.Bytes()), "origin")
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the clone uses the remote of the source to fetch the chunks
+	dir := filepath.Join(t.TempDir(), "clone")
+	repo, err := Clone(src.rootDir, dir, []string{"-q"}, 2, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.remote = remote
+	err = repo.pullClone(2, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, exp := range contents {
+		if data, _ := os.ReadFile(filepath.Join(repo.rootDir, name)); !bytes.Equal(data, exp) {
+			t.Errorf("expected '%s' to be combined in the clone", name)
+		}
+	}
+}
+
+func TestCloneDir(t *testing.T) {
+	for url, exp := range map[string]string{
+		"https://github.com/org/repo.git": "repo",
+		"git@github.com:org/repo.git":     "repo",
+		"host:repo":                       "repo",
+		"/srv/git/repo/.git":              "repo",
+		"../repo/":                        "repo",
+	} {
+		if act := cloneDir(url); act != exp {
+			t.Errorf("expected directory '%s' for '%s', got '%s'", exp, url, act)
+		}
+	}
+}
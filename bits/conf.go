@@ -5,10 +5,68 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/dustin/go-humanize"
 )
 
+//ConfFile is the name of the file in the root of the working tree that
+//records configuration that should be equal for every clone, such as the
+//chunking parameters. It is read before the git configuration
+var ConfFile = ".bitsconfig"
+
+//isSharedConfKey returns whether 'key' may be read from ConfFile. Anyone that
+//can commit to the repository writes ConfFile, so only the chunking
+//parameters and buckets are read from it: a committed endpoint, role,
+//profile or credential would send content elsewhere with the credentials
+//of whoever clones it
+func isSharedConfKey(key string) bool {
+	if isChunkingConfKey(key) {
+		return true
+	}
+
+	return strings.HasPrefix(key, "bits.") && strings.HasSuffix(key, ".aws-s3-bucket-name")
+}
+
+//isChunkingConfKey returns whether 'key' determines how files are chunked,
+//the value recorded in ConfFile can't be overwritten for those
+func isChunkingConfKey(key string) bool {
+	_, ok := DefaultConf().chunkingValues()[key]
+	return ok
+}
+
+//confLines returns the lines of `git config --get-regexp` output 'r' whose
+//key is selected by 'keep'
+func confLines(r io.Reader, keep func(key string) bool) io.Reader {
+	buf := bytes.NewBuffer(nil)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if key, _, _ := strings.Cut(s.Text(), " "); keep(key) {
+			fmt.Fprintln(buf, s.Text())
+		}
+	}
+
+	return buf
+}
+
+//recordedChunkingKeys returns the chunking parameters that ConfFile records
+func (repo *Repository) recordedChunkingKeys() (keys map[string]bool) {
+	keys = map[string]bool{}
+	out := repo.gitOutput(context.Background(), "config", "--file", filepath.Join(repo.rootDir, ConfFile), "--name-only", "--get-regexp", "^bits")
+	for _, key := range strings.Fields(out) {
+		if isChunkingConfKey(key) {
+			keys[key] = true
+		}
+	}
+
+	return keys
+}
+
 //Conf for the bits repository we're using
 type Conf struct {
 
@@ -21,27 +79,107 @@ type Conf struct {
 	//the aws secret that authorizes access to the s3 bucket
 	AWSSecretAccessKey string `json:"aws_secret_access_key"`
 
+	//profile in the shared aws configuration files to read credentials and
+	//the region from
+	AWSProfile string `json:"aws_profile"`
+
+	//region of the s3 bucket
+	AWSRegion string `json:"aws_region"`
+
+	//role that is assumed with the credentials to access the s3 bucket
+	AWSRoleARN string `json:"aws_role_arn"`
+
+	//url of an s3 compatible endpoint to use instead of aws
+	S3Endpoint string `json:"s3_endpoint"`
+
 	//holds the chunking polynomial
 	DeduplicationScope uint64 `json:"deduplication_scope"`
+
+	//the algorithm used for finding chunk boundaries
+	ChunkingAlgorithm string `json:"chunking_algorithm"`
+
+	//smallest chunk size, except for the last chunk of a file
+	ChunkMinSize uint64 `json:"chunk_min_size"`
+
+	//size the chunker aims for, must be a power of two
+	ChunkAvgSize uint64 `json:"chunk_avg_size"`
+
+	//largest chunk size
+	ChunkMaxSize uint64 `json:"chunk_max_size"`
+
+	//buckets of named remotes, paths select one with the 'bits-remote' attribute
+	Remotes map[string]string `json:"remotes"`
+
+	//patterns of the paths whose content is fetched by pull and the smudge
+	//filter, other paths keep their pointer
+	FetchInclude []string `json:"fetch_include"`
+	FetchExclude []string `json:"fetch_exclude"`
+
+	//whether the smudge filter writes pointers as is, e.g. to clone fast
+	SkipSmudge bool `json:"skip_smudge"`
 }
 
 //DefaultConf will setup a default configuration
 func DefaultConf() *Conf {
 	return &Conf{
 		DeduplicationScope: 0x3DA3358B4DC173,
+		ChunkingAlgorithm:  RabinChunking,
+		ChunkMinSize:       512 * 1024,
+		ChunkAvgSize:       1024 * 1024,
+		ChunkMaxSize:       uint64(ChunkBufferSize),
 	}
 }
 
+//ValidateChunking checks whether the chunking parameters are usable
+func (conf *Conf) ValidateChunking() error {
+	if conf.ChunkingAlgorithm != RabinChunking && conf.ChunkingAlgorithm != FastCDCChunking {
+		return fmt.Errorf("unknown chunking algorithm '%s', expected '%s' or '%s'", conf.ChunkingAlgorithm, RabinChunking, FastCDCChunking)
+	}
+
+	if conf.ChunkAvgSize == 0 || conf.ChunkAvgSize&(conf.ChunkAvgSize-1) != 0 {
+		return fmt.Errorf("average chunk size %d is not a power of two", conf.ChunkAvgSize)
+	}
+
+	if conf.ChunkMinSize < 64 || conf.ChunkMinSize >= conf.ChunkAvgSize || conf.ChunkAvgSize >= conf.ChunkMaxSize {
+		return fmt.Errorf("chunk sizes should satisfy 64 <= min < avg < max, got min: %d, avg: %d, max: %d", conf.ChunkMinSize, conf.ChunkAvgSize, conf.ChunkMaxSize)
+	}
+
+	return nil
+}
+
 //LoadGitValues will overwrite values based on configuration
 //set through git
 func (conf *Conf) OverwriteFromGit(repo *Repository) (err error) {
+
+	//values recorded in the repository come first. Buckets can be
+	//overwritten by git configuration but the chunking parameters it records
+	//can't, such that every clone chunks identically
+	recorded := map[string]bool{}
+	if _, err = os.Stat(filepath.Join(repo.rootDir, ConfFile)); err == nil {
+		buf := bytes.NewBuffer(nil)
+		err = repo.Git(context.Background(), nil, buf, "config", "--file", ConfFile, "--get-regexp", "^bits")
+		if err == nil {
+			err = conf.overwrite(confLines(buf, isSharedConfKey))
+			if err != nil {
+				return fmt.Errorf("invalid configuration in '%s': %v", ConfFile, err)
+			}
+
+			recorded = repo.recordedChunkingKeys()
+		}
+	}
+
 	buf := bytes.NewBuffer(nil)
 	err = repo.Git(context.Background(), nil, buf, "config", "--get-regexp", "^bits")
 	if err != nil {
 		return nil //no bits conf, nothing to do
 	}
 
-	s := bufio.NewScanner(buf)
+	return conf.overwrite(confLines(buf, func(key string) bool { return !recorded[key] }))
+}
+
+//overwrite sets values from the output of `git config --get-regexp`
+func (conf *Conf) overwrite(r io.Reader) (err error) {
+	s := bufio.NewScanner(r)
 	for s.Scan() {
 		fields := strings.Fields(s.Text())
 		if len(fields) < 2 {
@@ -62,6 +200,250 @@ func (conf *Conf) OverwriteFromGit(repo *Repository) (err error) {
 			conf.AWSAccessKeyID = fields[1]
 		case "bits.aws-secret-access-key":
 			conf.AWSSecretAccessKey = fields[1]
+		case "bits.aws-profile":
+			conf.AWSProfile = fields[1]
+		case "bits.aws-region":
+			conf.AWSRegion = fields[1]
+		case "bits.aws-role-arn":
+			if !strings.HasPrefix(fields[1], "arn:") || strings.Count(fields[1], ":") < 5 {
+				return fmt.Errorf("unexpected format for configured role '%v', expected an arn such as 'arn:aws:iam::123456789012:role/name'", fields[1])
+			}
+
+			conf.AWSRoleARN = fields[1]
+		case "bits.s3-endpoint":
+			u, err := url.Parse(fields[1])
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("unexpected format for configured s3 endpoint '%v', expected a url such as 'https://s3.example.com'", fields[1])
+			}
+
+			conf.S3Endpoint = fields[1]
+		case "bits.fetch-include":
+			conf.FetchInclude = append(conf.FetchInclude, fields[1])
+		case "bits.fetch-exclude":
+			conf.FetchExclude = append(conf.FetchExclude, fields[1])
+		case "bits.skip-smudge":
+			skip, err := strconv.ParseBool(fields[1])
+			if err != nil {
+				return fmt.Errorf("unexpected format for configured skip smudge '%v', expected a boolean", fields[1])
+			}
+
+			conf.SkipSmudge = skip
+		case "bits.chunker":
+			conf.ChunkingAlgorithm = fields[1]
+		case "bits.chunk-min-size", "bits.chunk-avg-size", "bits.chunk-max-size":
+			size, err := humanize.ParseBytes(fields[1])
+			if err != nil {
+				return fmt.Errorf("unexpected format for configured '%s' value '%v', expected a size in bytes", fields[0], fields[1])
+			}
+
+			switch fields[0] {
+			case "bits.chunk-min-size":
+				conf.ChunkMinSize = size
+			case "bits.chunk-avg-size":
+				conf.ChunkAvgSize = size
+			default:
+				conf.ChunkMaxSize = size
+			}
+		default:
+			//named remotes are configured in a subsection: bits.<name>.aws-s3-bucket-name
+			name := strings.TrimSuffix(strings.TrimPrefix(fields[0], "bits."), ".aws-s3-bucket-name")
+			if strings.HasSuffix(fields[0], ".aws-s3-bucket-name") && remoteNameExp.MatchString(name) {
+				if conf.Remotes == nil {
+					conf.Remotes = map[string]string{}
+				}
+
+				conf.Remotes[name] = fields[1]
+			}
+		}
+	}
+
+	return s.Err()
+}
+
+//chunkingValues returns the git configuration that determines how files
+//are chunked, every clone needs the same values to deduplicate
+func (conf *Conf) chunkingValues() map[string]string {
+	return map[string]string{
+		"bits.deduplication-scope": strconv.FormatUint(conf.DeduplicationScope, 10),
+		"bits.chunker":             conf.ChunkingAlgorithm,
+		"bits.chunk-min-size":      strconv.FormatUint(conf.ChunkMinSize, 10),
+		"bits.chunk-avg-size":      strconv.FormatUint(conf.ChunkAvgSize, 10),
+		"bits.chunk-max-size":      strconv.FormatUint(conf.ChunkMaxSize, 10),
+	}
+}
+
+//ConfKeys are the keys of the bits configuration that SetConf accepts, the
+//bucket of a named remote is configured as bits.<name>.aws-s3-bucket-name
+var ConfKeys = []string{
+	"bits.aws-s3-bucket-name",
+	"bits.aws-access-key-id",
+	"bits.aws-secret-access-key",
+	"bits.aws-profile",
+	"bits.aws-region",
+	"bits.aws-role-arn",
+	"bits.s3-endpoint",
+	"bits.deduplication-scope",
+	"bits.chunker",
+	"bits.chunk-min-size",
+	"bits.chunk-avg-size",
+	"bits.chunk-max-size",
+	"bits.fetch-include",
+	"bits.fetch-exclude",
+	"bits.skip-smudge",
+}
+
+//secretConfKeys hold credentials, they are masked when listed and are never
+//recorded in ConfFile
+var secretConfKeys = []string{"bits.aws-access-key-id", "bits.aws-secret-access-key"}
+
+//confKey returns the full key of bits configuration 'key', the 'bits.'
+//prefix may be omitted
+func confKey(key string) (string, error) {
+	if !strings.HasPrefix(key, "bits.") {
+		key = "bits." + key
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(key, "bits."), ".aws-s3-bucket-name")
+	if contains(ConfKeys, key) || (strings.HasSuffix(key, ".aws-s3-bucket-name") && remoteNameExp.MatchString(name)) {
+		return key, nil
+	}
+
+	return "", fmt.Errorf("unknown configuration key '%s', expected one of %s or bits.<remote>.aws-s3-bucket-name", key, strings.Join(ConfKeys, ", "))
+}
+
+//SetConf validates 'val' for bits configuration 'key' and writes it to the
+//local git configuration, or to ConfFile if 'shared' is set such that every
+//clone uses it. Values of keys that may be given more than once are replaced
+func (repo *Repository) SetConf(key, val string, shared bool) (err error) {
+	key, err = confKey(key)
+	if err != nil {
+		return err
+	}
+
+	if shared && !isSharedConfKey(key) {
+		return fmt.Errorf("'%s' is not read from '%s', only the chunking parameters and buckets can be shared", key, ConfFile)
+	}
+
+	if !shared && isChunkingConfKey(key) {
+		return fmt.Errorf("'%s' is recorded in '%s' such that every clone chunks identically, set it with --shared", key, ConfFile)
+	}
+
+	if strings.TrimSpace(val) == "" {
+		return fmt.Errorf("no value given for '%s'", key)
+	}
+
+	//the value is checked against the configuration it becomes part of
+	conf := *repo.conf
+	err = conf.overwrite(strings.NewReader(key + " " + val))
+	if err != nil {
+		return fmt.Errorf("invalid value for '%s': %v", key, err)
+	}
+
+	if _, ok := conf.chunkingValues()[key]; ok {
+		err = conf.ValidateChunking()
+		if err != nil {
+			return fmt.Errorf("invalid value for '%s': %v", key, err)
+		}
+	}
+
+	args := []string{"config", "--local"}
+	if shared {
+		args = []string{"config", "--file", ConfFile}
+	}
+
+	err = repo.Git(context.Background(), nil, nil, append(args, "--replace-all", key, val)...)
+	if err != nil {
+		return fmt.Errorf("failed to write '%s': %v", key, err)
+	}
+
+	reloaded := DefaultConf()
+	err = reloaded.OverwriteFromGit(repo)
+	if err != nil {
+		return fmt.Errorf("failed to load bits configuration from git: %v", err)
+	}
+
+	repo.conf = reloaded
+	return nil
+}
+
+//GetConf returns the values of bits configuration 'key' as git-bits reads
+//them: git configuration takes precedence over ConfFile, which takes
+//precedence over the default chunking parameters. Chunking parameters that
+//ConfFile records are always read from it
+func (repo *Repository) GetConf(key string) (vals []string, err error) {
+	key, err = confKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if out := repo.gitOutput(ctx, "config", "--get-all", key); out != "" && !repo.recordedChunkingKeys()[key] {
+		return strings.Split(out, "\n"), nil
+	}
+
+	if isSharedConfKey(key) {
+		if out := repo.gitOutput(ctx, "config", "--file", ConfFile, "--get-all", key); out != "" {
+			return strings.Split(out, "\n"), nil
+		}
+	}
+
+	if val, ok := DefaultConf().chunkingValues()[key]; ok {
+		return []string{val}, nil
+	}
+
+	return nil, fmt.Errorf("'%s' is not configured", key)
+}
+
+//ListConf writes each bits configuration value with the file it is read
+//from, values of the default chunking parameters that are not configured
+//are listed as 'default' and values in ConfFile that are not read from it, or
+//chunking parameters in git configuration that ConfFile records, as
+//'ignored'. Credentials are masked
+func (repo *Repository) ListConf(w io.Writer) (err error) {
+	ctx := context.Background()
+	recorded := repo.recordedChunkingKeys()
+	configured := map[string]bool{}
+	entries := [][3]string{}
+
+	//lines are: <origin> TAB <key> SP <value>
+	for _, args := range [][]string{
+		{"config", "--file", ConfFile, "--show-origin", "--get-regexp", "^bits"},
+		{"config", "--show-origin", "--get-regexp", "^bits"},
+	} {
+		out := repo.gitOutput(ctx, args...)
+		for _, line := range strings.Split(out, "\n") {
+			origin, kv, ok := strings.Cut(line, "\t")
+			if !ok {
+				continue
+			}
+
+			key, val, _ := strings.Cut(kv, " ")
+			origin = strings.TrimPrefix(origin, "file:")
+			if (origin == ConfFile && !isSharedConfKey(key)) || (origin != ConfFile && recorded[key]) {
+				origin += ", ignored"
+			} else {
+				configured[key] = true
+			}
+
+			entries = append(entries, [3]string{key, val, origin})
+		}
+	}
+
+	defaults := DefaultConf().chunkingValues()
+	for _, key := range ConfKeys {
+		if val, ok := defaults[key]; ok && !configured[key] {
+			entries = append(entries, [3]string{key, val, "default"})
+		}
+	}
+
+	for _, e := range entries {
+		if contains(secretConfKeys, e[0]) {
+			e[1] = "****"
+		}
+
+		_, err = fmt.Fprintf(w, "%s=%s (%s)\n", e[0], e[1], e[2])
+		if err != nil {
+			return err
 		}
 	}
 
@@ -1,6 +1,10 @@
 package bits
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -48,4 +52,213 @@ func TestOperationConstants(t *testing.T) {
 			t.Errorf("Operation %v should have non-empty string value", op)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestValidateChunking(t *testing.T) {
+	if err := DefaultConf().ValidateChunking(); err != nil {
+		t.Errorf("default configuration should be valid: %v", err)
+	}
+
+	for _, c := range []struct {
+		name string
+		fn   func(*Conf)
+	}{
+		{"unknown algorithm", func(c *Conf) { c.ChunkingAlgorithm = "foo" }},
+		{"average not power of two", func(c *Conf) { c.ChunkAvgSize = 1000000 }},
+		{"min above average", func(c *Conf) { c.ChunkMinSize = 2 * 1024 * 1024 }},
+		{"max below average", func(c *Conf) { c.ChunkMaxSize = 1024 }},
+	} {
+		conf := DefaultConf()
+		c.fn(conf)
+		if err := conf.ValidateChunking(); err == nil {
+			t.Errorf("%s: expected validation to fail", c.name)
+		}
+	}
+}
+
+func TestOverwriteFromConfFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "git-bits-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := initGitRepo(tmpDir); err != nil {
+		t.Skip("Git not available")
+	}
+
+	err = os.WriteFile(filepath.Join(tmpDir, ConfFile), []byte("[bits]\n\tchunker = fastcdc\n\tchunk-min-size = 16KiB\n\tchunk-avg-size = 65536\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{"bits.chunk-min-size": "32768", "bits.chunk-max-size": "4MiB"} {
+		if err := runCommand(tmpDir, "git", "config", k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := NewRepository(tmpDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.conf.ChunkingAlgorithm != FastCDCChunking {
+		t.Errorf("expected chunker from '%s', got '%s'", ConfFile, repo.conf.ChunkingAlgorithm)
+	}
+
+	if repo.conf.ChunkAvgSize != 65536 {
+		t.Errorf("expected average size from '%s', got %d", ConfFile, repo.conf.ChunkAvgSize)
+	}
+
+	if repo.conf.ChunkMinSize != 16*1024 {
+		t.Errorf("expected the recorded min size to take precedence over git config, got %d", repo.conf.ChunkMinSize)
+	}
+
+	if repo.conf.ChunkMaxSize != 4*1024*1024 {
+		t.Errorf("expected git config for a parameter that isn't recorded, got max size %d", repo.conf.ChunkMaxSize)
+	}
+
+	if vals, err := repo.GetConf("chunk-min-size"); err != nil || len(vals) != 1 || vals[0] != "16KiB" {
+		t.Errorf("expected the recorded min size, got: %v, %v", vals, err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := repo.ListConf(buf); err != nil || !strings.Contains(buf.String(), "bits.chunk-min-size=32768 (.git/config, ignored)\n") {
+		t.Errorf("expected the git config of the recorded min size to be listed as ignored, got:\n%s", buf.String())
+	}
+}
+
+func TestConfGetSetList(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	for key, val := range map[string]string{
+		"bits.deduplication-scope": "0x3DA3",
+		"chunker":                  "zstd",
+		"bits.chunk-avg-size":      "1000",
+		"bits.s3-endpoint":         "localhost:4566",
+		"bits.skip-smudge":         "maybe",
+		"bits.aws-role-arn":        "bits-role",
+		"bits.unknown":             "value",
+	} {
+		if err := repo.SetConf(key, val, false); err == nil {
+			t.Errorf("expected value '%s' for '%s' to be rejected", val, key)
+		}
+	}
+
+	for _, key := range []string{"bits.aws-secret-access-key", "bits.s3-endpoint", "bits.aws-role-arn", "bits.aws-profile"} {
+		if err := repo.SetConf(key, "https://example.com", true); err == nil {
+			t.Errorf("expected '%s' not to be recorded in '%s'", key, ConfFile)
+		}
+	}
+
+	if err := repo.SetConf("chunker", FastCDCChunking, false); err == nil {
+		t.Errorf("expected a chunking parameter to require --shared")
+	}
+
+	if vals, err := repo.GetConf("chunker"); err != nil || len(vals) != 1 || vals[0] != RabinChunking {
+		t.Errorf("expected the default chunker, got: %v, %v", vals, err)
+	}
+
+	if _, err := repo.GetConf("bits.aws-s3-bucket-name"); err == nil {
+		t.Errorf("expected an error for a key that isn't configured")
+	}
+
+	for _, kv := range [][2]string{
+		{"chunker", FastCDCChunking},
+		{"bits.s3-endpoint", "http://localhost:4566"},
+		{"bits.aws-secret-access-key", "secret"},
+		{"bits.backup.aws-s3-bucket-name", "backup-bucket"},
+	} {
+		if err := repo.SetConf(kv[0], kv[1], kv[0] == "chunker"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if repo.conf.ChunkingAlgorithm != FastCDCChunking || repo.conf.S3Endpoint != "http://localhost:4566" || repo.conf.Remotes["backup"] != "backup-bucket" {
+		t.Errorf("expected the configuration to be reloaded, got: %+v", repo.conf)
+	}
+
+	if vals, err := repo.GetConf("bits.chunker"); err != nil || len(vals) != 1 || vals[0] != FastCDCChunking {
+		t.Errorf("expected the chunker from '%s', got: %v, %v", ConfFile, vals, err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := repo.ListConf(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"bits.chunker=fastcdc (" + ConfFile + ")",
+		"bits.aws-secret-access-key=**** (.git/config)",
+		"bits.chunk-avg-size=1048576 (default)",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected '%s' to be listed, got:\n%s", line, buf.String())
+		}
+	}
+
+	if strings.Contains(buf.String(), "secret\n") {
+		t.Errorf("expected the secret to be masked, got:\n%s", buf.String())
+	}
+}
+
+func TestHostileConfFile(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	err := os.WriteFile(filepath.Join(repo.rootDir, ConfFile), []byte("[bits]\n"+
+		"\ts3-endpoint = https://attacker.example.com\n"+
+		"\taws-role-arn = arn:aws:iam::123456789012:role/attacker\n"+
+		"\taws-profile = attacker\n"+
+		"\taws-access-key-id = ATTACKERKEY\n"+
+		"\taws-secret-access-key = attackersecret\n"+
+		"\tchunker = fastcdc\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := DefaultConf()
+	err = conf.OverwriteFromGit(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.S3Endpoint != "" || conf.AWSRoleARN != "" || conf.AWSProfile != "" || conf.AWSAccessKeyID != "" || conf.AWSSecretAccessKey != "" {
+		t.Errorf("expected the endpoint, role, profile and credentials in '%s' to be ignored, got: %+v", ConfFile, conf)
+	}
+
+	if conf.ChunkingAlgorithm != FastCDCChunking {
+		t.Errorf("expected the chunker to be read from '%s', got: %s", ConfFile, conf.ChunkingAlgorithm)
+	}
+
+	if _, err = repo.GetConf("bits.s3-endpoint"); err == nil {
+		t.Errorf("expected the endpoint in '%s' not to be returned", ConfFile)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = repo.ListConf(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "bits.s3-endpoint=https://attacker.example.com ("+ConfFile+", ignored)\n") {
+		t.Errorf("expected the endpoint to be listed as ignored, got:\n%s", buf.String())
+	}
+}
+
+func TestInstallNamedRemote(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	conf := DefaultConf()
+	conf.AWSS3BucketName = "default-bucket"
+	conf.Remotes = map[string]string{"public": "public-bucket"}
+	err := repo.InstallWith(bytes.NewBuffer(nil), conf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.Bucket("") != "default-bucket" || repo.Bucket("public") != "public-bucket" {
+		t.Errorf("unexpected buckets '%s' and '%s'", repo.Bucket(""), repo.Bucket("public"))
+	}
+
+	if val := strings.TrimSpace(testGit(t, repo, "config", "bits.public.aws-s3-bucket-name")); val != "public-bucket" {
+		t.Errorf("expected the named remote to be configured, got '%s'", val)
+	}
+}
@@ -0,0 +1,178 @@
+package bits
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/dustin/go-humanize"
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//ByteRange is a range of bytes in a file, 'End' is exclusive
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+//PointerDiff describes how the content of a file changed between two pointers
+type PointerDiff struct {
+	Path string
+
+	//whether the file doesn't exist in the old or the new revision
+	Added   bool
+	Deleted bool
+
+	//whether the old and new content have the same chunks in the same order
+	Unchanged bool
+
+	//size of the old and new content, -1 if the file doesn't exist or its
+	//pointer doesn't record it
+	OldSize int64
+	NewSize int64
+
+	//chunks of the new content and how many of those the old content has
+	Chunks       int
+	ReusedChunks int
+	NewChunks    int
+
+	//bytes of the unique chunks that the old content doesn't have, which is
+	//what needs to be transferred to go from the old to the new content
+	TransferBytes int64
+
+	//ranges of the new content that the old content doesn't have, empty if
+	//the pointer doesn't record chunk sizes
+	Changed []ByteRange
+}
+
+//DiffPointers compares pointer 'old' with pointer 'new' by their chunks,
+//either is nil if the file doesn't exist
+func DiffPointers(p string, old, new *pointer.Pointer) *PointerDiff {
+	d := &PointerDiff{Path: p, OldSize: -1, NewSize: -1, Added: old == nil, Deleted: new == nil}
+	oldKeys := map[pointer.Key]bool{}
+	if old != nil {
+		d.OldSize = old.Size
+		for _, c := range old.Chunks {
+			oldKeys[c.Key] = true
+		}
+	}
+
+	if new == nil {
+		return d
+	}
+
+	d.NewSize = new.Size
+	d.Chunks = len(new.Chunks)
+	d.Unchanged = old != nil && len(old.Chunks) == len(new.Chunks)
+	for i := 0; d.Unchanged && i < len(new.Chunks); i++ {
+		d.Unchanged = old.Chunks[i].Key == new.Chunks[i].Key
+	}
+
+	offset, transferred := int64(0), map[pointer.Key]bool{}
+	for _, c := range new.Chunks {
+		if oldKeys[c.Key] {
+			d.ReusedChunks++
+		} else {
+			d.NewChunks++
+			if !transferred[c.Key] && c.Size > 0 {
+				transferred[c.Key] = true
+				d.TransferBytes += c.Size
+			}
+
+			//adjacent changed chunks form a single range
+			if c.Size > 0 {
+				if n := len(d.Changed); n > 0 && d.Changed[n-1].End == offset {
+					d.Changed[n-1].End += c.Size
+				} else {
+					d.Changed = append(d.Changed, ByteRange{offset, offset + c.Size})
+				}
+			}
+		}
+
+		offset += max(c.Size, 0)
+	}
+
+	return d
+}
+
+//Diff compares the pointers of each path in 'paths' between revision 'from'
+//and 'to' and hands the result to 'fn'
+func (repo *Repository) Diff(from, to string, paths []string, fn func(*PointerDiff) error) (err error) {
+	ctx := context.Background()
+	for _, p := range paths {
+		old, err := repo.revPointer(ctx, from, p)
+		if err != nil {
+			return err
+		}
+
+		new, err := repo.revPointer(ctx, to, p)
+		if err != nil {
+			return err
+		}
+
+		if old == nil && new == nil {
+			return fmt.Errorf("'%s' doesn't exist in '%s' or '%s'", p, from, to)
+		}
+
+		err = fn(DiffPointers(p, old, new))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//revPointer returns the pointer of path 'p' in revision 'rev', nil if the
+//path doesn't exist in the revision
+func (repo *Repository) revPointer(ctx context.Context, rev, p string) (ptr *pointer.Pointer, err error) {
+	obj := repo.gitOutput(ctx, "rev-parse", "-q", "--verify", rev+":"+p)
+	if obj == "" {
+		return nil, nil
+	}
+
+	ptr, err = repo.indexPointer(ctx, indexEntry{obj: obj, path: p})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read '%s:%s': %v", rev, p, err)
+	}
+
+	if ptr == nil {
+		return nil, fmt.Errorf("'%s:%s' is not stored as a pointer", rev, p)
+	}
+
+	return ptr, nil
+}
+
+//Textconv writes a summary of the pointer read from 'r' to 'w' for use as
+//the textconv of a diff driver: the size, the hash of the content and the
+//number of chunks. Content that isn't a pointer is summarized by its size
+//and hash
+func Textconv(r io.Reader, w io.Writer) (err error) {
+	bufr := bufio.NewReader(r)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); !pointer.IsPointer(hdr) {
+		h := sha256.New()
+		n, err := io.Copy(h, bufr)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "content that isn't split\nsize: %s (%d bytes)\nsha256: %x\n", humanize.IBytes(uint64(n)), n, h.Sum(nil))
+		return err
+	}
+
+	ptr, err := pointer.Decode(bufr)
+	if err != nil {
+		return fmt.Errorf("failed to decode pointer: %v", err)
+	}
+
+	fmt.Fprintf(w, "git-bits pointer version %d\n", ptr.Version)
+	if ptr.Size >= 0 {
+		fmt.Fprintf(w, "size: %s (%d bytes)\n", humanize.IBytes(uint64(ptr.Size)), ptr.Size)
+		fmt.Fprintf(w, "sha256: %x\n", ptr.Hash)
+	}
+
+	_, err = fmt.Fprintf(w, "chunks: %d\n", len(ptr.Chunks))
+	return err
+}
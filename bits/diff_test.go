@@ -0,0 +1,94 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+func TestDiffPointers(t *testing.T) {
+	a, b, c, d := pointer.Key{1}, pointer.Key{2}, pointer.Key{3}, pointer.Key{4}
+	old := &pointer.Pointer{Version: 2, Size: 60, Chunks: []pointer.Chunk{{Key: a, Size: 10}, {Key: b, Size: 20}, {Key: c, Size: 30}}}
+	new := &pointer.Pointer{Version: 2, Size: 90, Chunks: []pointer.Chunk{{Key: a, Size: 10}, {Key: d, Size: 25}, {Key: c, Size: 30}, {Key: d, Size: 25}}}
+
+	act := DiffPointers("a.bin", old, new)
+	exp := &PointerDiff{
+		Path:          "a.bin",
+		OldSize:       60,
+		NewSize:       90,
+		Chunks:        4,
+		ReusedChunks:  2,
+		NewChunks:     2,
+		TransferBytes: 25,
+		Changed:       []ByteRange{{10, 35}, {65, 90}},
+	}
+
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("expected diff %+v, got %+v", exp, act)
+	}
+
+	if act = DiffPointers("a.bin", nil, old); !act.Added || act.TransferBytes != 60 || len(act.Changed) != 1 {
+		t.Errorf("expected an added file with all content changed, got %+v", act)
+	}
+
+	if act = DiffPointers("a.bin", old, nil); !act.Deleted || act.Chunks != 0 {
+		t.Errorf("expected a deleted file, got %+v", act)
+	}
+
+	//reordered chunks are a change, even though no chunk is new
+	reordered := &pointer.Pointer{Version: 2, Size: 60, Chunks: []pointer.Chunk{{Key: c, Size: 30}, {Key: b, Size: 20}, {Key: a, Size: 10}}}
+	if act = DiffPointers("a.bin", old, reordered); act.Unchanged || act.NewChunks != 0 {
+		t.Errorf("expected reordered chunks to be a change, got %+v", act)
+	}
+
+	if act = DiffPointers("a.bin", old, old); !act.Unchanged {
+		t.Errorf("expected equal pointers to be unchanged, got %+v", act)
+	}
+}
+
+func TestDiffAndTextconv(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	testGit(t, repo, "config", "diff.bits.textconv", filterConf["diff.bits.textconv"])
+	writeAttributes(t, repo, "*.bin filter=bits diff=bits")
+
+	for _, size := range []int{16 * 1024, 32 * 1024} {
+		data := make([]byte, size)
+		rand.Read(data)
+		os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), data, 0666)
+		os.WriteFile(filepath.Join(repo.rootDir, "b.txt"), data[:100], 0666)
+		testGit(t, repo, "add", "-A")
+		testGit(t, repo, "commit", "-m", "c")
+	}
+
+	var act *PointerDiff
+	err := repo.Diff("HEAD~1", "HEAD", []string{"a.bin"}, func(d *PointerDiff) error {
+		act = d
+		return nil
+	})
+
+	if err != nil || act.OldSize != 16*1024 || act.NewSize != 32*1024 || act.TransferBytes != 32*1024 {
+		t.Errorf("unexpected diff %+v: %v", act, err)
+	}
+
+	if err = repo.Diff("HEAD~1", "HEAD", []string{"b.txt"}, func(*PointerDiff) error { return nil }); err == nil {
+		t.Error("expected a file that isn't a pointer to be rejected")
+	}
+
+	diff := testGit(t, repo, "diff", "HEAD~1", "HEAD", "--", "a.bin")
+	if !strings.Contains(diff, "-size: 16 KiB (16384 bytes)") || !strings.Contains(diff, "+size: 32 KiB (32768 bytes)") {
+		t.Errorf("expected the diff to show the pointer summaries, got: %s", diff)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = Textconv(strings.NewReader("plain"), out)
+	if err != nil || !strings.Contains(out.String(), "size: 5 B (5 bytes)") {
+		t.Errorf("unexpected summary of content: %s, %v", out.String(), err)
+	}
+}
@@ -0,0 +1,333 @@
+package bits
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//CheckStatus is the outcome of a diagnostic check
+type CheckStatus string
+
+var (
+	//CheckOK tells nothing is wrong
+	CheckOK = CheckStatus("ok")
+
+	//CheckWarning tells something may not work as expected
+	CheckWarning = CheckStatus("warning")
+
+	//CheckFailed tells git-bits doesn't work until it is fixed
+	CheckFailed = CheckStatus("error")
+)
+
+//Check is the outcome of a diagnostic check with a fix if it didn't pass
+type Check struct {
+	Name    string
+	Status  CheckStatus
+	Message string
+	Fix     string
+}
+
+//guiPath holds the directories that GUI clients, which don't load the
+//profile of a shell, typically search for executables
+var guiPath = []string{"/usr/local/bin", "/usr/bin", "/bin", "/usr/sbin", "/sbin", "/opt/homebrew/bin"}
+
+//Doctor diagnoses the installation and configuration of git-bits in the
+//repository. If 'probe' is set a probe is written to, read from, listed on
+//and deleted from the default and every named remote
+func (repo *Repository) Doctor(probe bool) (checks []Check) {
+	ctx := context.Background()
+	checks = append(checks, repo.checkFilter(ctx))
+	checks = append(checks, repo.checkHook(ctx))
+	checks = append(checks, checkExecutable())
+	checks = append(checks, repo.checkChunking(ctx)...)
+
+	names := []string{}
+	for name := range repo.conf.Remotes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	remotes := map[string]Remote{}
+	switch {
+	case repo.conf.AWSS3BucketName != "" && repo.remote != nil:
+		remotes[""] = repo.remote
+		checks = append(checks, Check{Name: "remote", Status: CheckOK, Message: fmt.Sprintf("chunks are stored in bucket '%s'", repo.conf.AWSS3BucketName)})
+	case len(names) > 0:
+		checks = append(checks, Check{"remote", CheckWarning,
+			"no default bucket is configured, only paths with the 'bits-remote' attribute can be pushed",
+			"run 'git config bits.aws-s3-bucket-name <bucket>' if other paths are split"})
+	default:
+		return append(checks, Check{
+			Name:    "remote",
+			Status:  CheckFailed,
+			Message: "no bucket is configured to store chunks",
+			Fix:     "run 'git bits install' or 'git config bits.aws-s3-bucket-name <bucket>'",
+		})
+	}
+
+	for _, name := range names {
+		remote, err := repo.chunkRemote(name)
+		if err != nil {
+			checks = append(checks, Check{"remote", CheckFailed, err.Error(), fmt.Sprintf("check 'bits.%s.aws-s3-bucket-name'", name)})
+			continue
+		}
+
+		remotes[name] = remote
+		checks = append(checks, Check{Name: "remote", Status: CheckOK, Message: fmt.Sprintf("chunks of paths with 'bits-remote=%s' are stored in bucket '%s'", name, repo.conf.Remotes[name])})
+	}
+
+	//every remote is configured with the same credentials
+	for _, name := range append([]string{""}, names...) {
+		if s3r, ok := remotes[name].(*S3Remote); ok {
+			checks = append(checks, s3r.checkCredentials(ctx))
+			break
+		}
+	}
+
+	if probe {
+		for _, name := range append([]string{""}, names...) {
+			if remote, ok := remotes[name]; ok {
+				checks = append(checks, probeRemote(name, remote))
+			}
+		}
+	}
+
+	return checks
+}
+
+//checkFilter checks that the filter and drivers are configured as install
+//configures them
+func (repo *Repository) checkFilter(ctx context.Context) Check {
+	missing, differs := []string{}, []string{}
+	for k, v := range filterConf {
+		switch val := repo.gitOutput(ctx, "config", "--get", k); val {
+		case v:
+		case "":
+			missing = append(missing, k)
+		default:
+			differs = append(differs, fmt.Sprintf("%s is '%s' instead of '%s'", k, val, v))
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(differs)
+	switch {
+	case len(missing) > 0:
+		return Check{"filter", CheckFailed, "missing configuration: " + strings.Join(missing, ", "), "run 'git bits install'"}
+	case len(differs) > 0:
+		return Check{"filter", CheckWarning, strings.Join(differs, ", "), "run 'git bits install' to update the configuration"}
+	default:
+		return Check{"filter", CheckOK, "the bits filter and drivers are configured", ""}
+	}
+}
+
+//checkHook checks that the pre-push hook runs git-bits, a hook in the
+//default directory is not run if core.hooksPath points elsewhere
+func (repo *Repository) checkHook(ctx context.Context) Check {
+	hookp := repo.hookPath("pre-push")
+	data, err := os.ReadFile(hookp)
+	if err == nil && (bytes.Contains(data, []byte(hookBegin)) || string(data) == PrePushHook) {
+		if fi, err := os.Stat(hookp); err == nil && runtime.GOOS != "windows" && fi.Mode()&0111 == 0 {
+			return Check{"hook", CheckFailed, fmt.Sprintf("the pre-push hook '%s' is not executable", hookp), fmt.Sprintf("run 'chmod +x %s'", hookp)}
+		}
+
+		return Check{"hook", CheckOK, fmt.Sprintf("the pre-push hook '%s' runs git-bits", hookp), ""}
+	}
+
+	hooksPath := repo.gitOutput(ctx, "config", "core.hooksPath")
+	if def := filepath.Join(repo.gitDir, "hooks", "pre-push"); hooksPath != "" && def != hookp {
+		if data, err := os.ReadFile(def); err == nil && (bytes.Contains(data, []byte(hookBegin)) || string(data) == PrePushHook) {
+			return Check{"hook", CheckFailed,
+				fmt.Sprintf("the pre-push hook '%s' runs git-bits but git runs the hooks in core.hooksPath '%s'", def, hooksPath),
+				"run 'git bits install' again, or add 'git bits hook pre-push \"$@\"' to the pre-push hook of your hook manager"}
+		}
+	}
+
+	return Check{"hook", CheckFailed, fmt.Sprintf("the pre-push hook '%s' doesn't run git-bits, chunks are not pushed", hookp), "run 'git bits install'"}
+}
+
+//checkExecutable checks that git-bits can be found by git, also when it is
+//run by a GUI client that doesn't load the PATH of a shell
+func checkExecutable() Check {
+	exe, err := exec.LookPath("git-bits")
+	if err != nil {
+		return Check{"executable", CheckFailed, "git-bits is not in the PATH, git can't run the filter and hooks", "install git-bits in a directory that is in the PATH"}
+	}
+
+	if runtime.GOOS == "windows" {
+		return Check{"executable", CheckOK, fmt.Sprintf("git-bits is found at '%s'", exe), ""}
+	}
+
+	dir, _ := filepath.Abs(filepath.Dir(exe))
+	if !contains(guiPath, dir) {
+		return Check{"executable", CheckWarning,
+			fmt.Sprintf("git-bits is found at '%s', GUI clients that don't load your shell profile may not find it", exe),
+			fmt.Sprintf("link it into a system directory, e.g. 'ln -s %s /usr/local/bin/git-bits'", exe)}
+	}
+
+	return Check{"executable", CheckOK, fmt.Sprintf("git-bits is found at '%s'", exe), ""}
+}
+
+//checkChunking checks that this clone chunks like the others: the chunking
+//parameters are recorded in ConfFile and match the pointers in the index
+func (repo *Repository) checkChunking(ctx context.Context) (checks []Check) {
+	buf := bytes.NewBuffer(nil)
+	err := repo.Git(ctx, nil, buf, "config", "--file", filepath.Join(repo.rootDir, ConfFile), "--get-regexp", "^bits")
+	if err != nil {
+		checks = append(checks, Check{"chunking", CheckWarning,
+			fmt.Sprintf("the chunking parameters are not recorded in '%s', clones may chunk differently", ConfFile),
+			fmt.Sprintf("run 'git bits install' and commit '%s'", ConfFile)})
+	} else {
+		recorded := DefaultConf()
+		err = recorded.overwrite(buf)
+		if err != nil {
+			return append(checks, Check{"chunking", CheckFailed, fmt.Sprintf("invalid configuration in '%s': %v", ConfFile, err), "correct the values in " + ConfFile})
+		}
+
+		for k, v := range repo.conf.chunkingValues() {
+			if rv := recorded.chunkingValues()[k]; rv != v {
+				checks = append(checks, Check{"chunking", CheckFailed,
+					fmt.Sprintf("%s is '%s' in the local configuration but '%s' in '%s', this clone doesn't deduplicate with others", k, v, rv, ConfFile),
+					fmt.Sprintf("run 'git config --unset %s'", k)})
+			}
+		}
+
+		//git configuration of a recorded chunking parameter is ignored
+		for k := range repo.recordedChunkingKeys() {
+			val := repo.gitOutput(ctx, "config", "--get", k)
+			local := *recorded
+			if val == "" || local.overwrite(strings.NewReader(k+" "+val)) != nil || local.chunkingValues()[k] == recorded.chunkingValues()[k] {
+				continue
+			}
+
+			checks = append(checks, Check{"chunking", CheckWarning,
+				fmt.Sprintf("%s is '%s' in the git configuration but '%s' records '%s', the git configuration is ignored", k, val, ConfFile, recorded.chunkingValues()[k]),
+				fmt.Sprintf("run 'git config --unset %s'", k)})
+		}
+	}
+
+	//pointers in the index tell how files were chunked when they were added
+	entries, err := repo.trackedFiles(ctx)
+	if err != nil {
+		return append(checks, Check{"chunking", CheckWarning, fmt.Sprintf("failed to list tracked files: %v", err), ""})
+	}
+
+	paths := []string{}
+	for _, e := range entries {
+		paths = append(paths, e.path)
+	}
+
+	pols, err := repo.Policies(paths)
+	if err != nil {
+		return append(checks, Check{"chunking", CheckWarning, fmt.Sprintf("failed to read the attributes of tracked files: %v", err), "correct the bits attributes in .gitattributes"})
+	}
+
+	ptrs, err := repo.indexPointers(ctx, entries)
+	if err != nil {
+		return append(checks, Check{"chunking", CheckWarning, fmt.Sprintf("failed to read the pointers in the index: %v", err), ""})
+	}
+
+	mismatches := []string{}
+	for _, e := range entries {
+		ptr := ptrs[e.path]
+		if ptr == nil || ptr.Version < 2 {
+			continue
+		}
+
+		pol := pols[e.path]
+		if err := pol.Validate(repo.conf); err != nil {
+			checks = append(checks, Check{"chunking", CheckFailed, err.Error(), "correct 'bits-chunk-size' in .gitattributes, it must be a power of two"})
+			continue
+		}
+
+		conf := pol.Conf(repo.conf)
+		if ptr.Chunking != (pointer.Chunking{Algorithm: conf.ChunkingAlgorithm, Scope: conf.DeduplicationScope, MinSize: conf.ChunkMinSize, AvgSize: conf.ChunkAvgSize, MaxSize: conf.ChunkMaxSize}) {
+			mismatches = append(mismatches, e.path)
+		}
+	}
+
+	if len(mismatches) > 0 {
+		checks = append(checks, Check{"chunking", CheckWarning,
+			fmt.Sprintf("%d files were chunked with other parameters than configured, e.g. '%s'", len(mismatches), mismatches[0]),
+			"check the chunking configuration, 'git bits track --restage' splits files again"})
+	}
+
+	if len(checks) == 0 {
+		checks = append(checks, Check{"chunking", CheckOK, "the chunking parameters match the recorded ones", ""})
+	}
+
+	return checks
+}
+
+//probeRemote writes a probe to 'remote' with 'name', reads it back and checks
+//that it is listed. The probe is deleted afterwards, it is stored outside of
+//the chunks such that it is never indexed or pushed
+func probeRemote(name string, remote Remote) Check {
+	desc := "the remote"
+	if name != "" {
+		desc = fmt.Sprintf("remote '%s'", name)
+	}
+
+	data := make([]byte, 64)
+	rand.Read(data)
+	probe := fmt.Sprintf("%x", sha256.Sum256(data))
+	fix := "check that the credentials may put, get, list and delete objects in the bucket"
+	wc, err := remote.ProbeWriter(probe)
+	if err == nil {
+		_, err = wc.Write(data)
+		if cerr := wc.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	if err != nil {
+		return Check{"probe", CheckFailed, fmt.Sprintf("failed to write to %s: %v", desc, err), fix}
+	}
+
+	deleted := false
+	defer func() {
+		if !deleted {
+			remote.DeleteProbe(probe)
+		}
+	}()
+
+	rc, err := remote.ProbeReader(probe)
+	if err != nil {
+		return Check{"probe", CheckFailed, fmt.Sprintf("failed to read from %s: %v", desc, err), fix}
+	}
+
+	defer rc.Close()
+	read, err := io.ReadAll(rc)
+	if err != nil || !bytes.Equal(read, data) {
+		return Check{"probe", CheckFailed, fmt.Sprintf("the object read from %s differs from the one written: %v", desc, err), fix}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = remote.ListProbes(buf)
+	if err != nil {
+		return Check{"probe", CheckFailed, fmt.Sprintf("failed to list %s: %v", desc, err), fix}
+	}
+
+	if !strings.Contains(buf.String(), probe) {
+		return Check{"probe", CheckFailed, fmt.Sprintf("the object written to %s is not listed", desc), "check that the bucket lists consistently"}
+	}
+
+	err, deleted = remote.DeleteProbe(probe), true
+	if err != nil {
+		return Check{"probe", CheckWarning, fmt.Sprintf("failed to delete the probe from %s: %v", desc, err), fmt.Sprintf("remove '%s%s' from the bucket", ProbePrefix, probe)}
+	}
+
+	return Check{"probe", CheckOK, fmt.Sprintf("an object was written to, read from, listed on and deleted from %s", desc), ""}
+}
@@ -0,0 +1,94 @@
+package bits
+
+import (
+	"io"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	remote := newMemRemote()
+	repo := newTestRepository(t, remote)
+	status := func() map[string]CheckStatus {
+		statuses := map[string]CheckStatus{}
+		for _, c := range repo.Doctor(true) {
+			if statuses[c.Name] != CheckFailed {
+				statuses[c.Name] = c.Status
+			}
+		}
+
+		return statuses
+	}
+
+	st := status()
+	for _, name := range []string{"filter", "hook", "remote"} {
+		if st[name] != CheckFailed {
+			t.Errorf("expected check '%s' to fail before install, got: %s", name, st[name])
+		}
+	}
+
+	if st["chunking"] != CheckWarning {
+		t.Errorf("expected a warning for chunking that isn't recorded, got: %s", st["chunking"])
+	}
+
+	for k, v := range filterConf {
+		testGit(t, repo, "config", k, v)
+	}
+
+	for k, v := range repo.conf.chunkingValues() {
+		testGit(t, repo, "config", "--file", ConfFile, k, v)
+	}
+
+	err := repo.installHook("pre-push", io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.conf.AWSS3BucketName = "test-bucket"
+	st = status()
+	for _, name := range []string{"filter", "hook", "remote", "chunking", "probe"} {
+		if st[name] != CheckOK {
+			t.Errorf("expected check '%s' to pass after install, got: %s", name, st[name])
+		}
+	}
+
+	if n := len(remote.objects); n != 0 {
+		t.Errorf("expected the probe to leave no objects behind, got %d", n)
+	}
+
+	//named remotes are probed as well
+	public := newMemRemote()
+	repo.conf.Remotes = map[string]string{"public": "public-bucket"}
+	repo.remotes = map[string]Remote{"public": public}
+	probes := 0
+	for _, c := range repo.Doctor(true) {
+		if c.Name == "probe" && c.Status == CheckOK {
+			probes++
+		}
+	}
+
+	if probes != 2 || len(public.objects) != 0 {
+		t.Errorf("expected both remotes to be probed without leaving objects, got %d probes", probes)
+	}
+
+	//without a default bucket only paths with a named remote can be pushed
+	repo.conf.AWSS3BucketName = ""
+	if checks := repo.Doctor(false); checks[len(checks)-2].Status != CheckWarning || checks[len(checks)-1].Status != CheckOK {
+		t.Errorf("expected a warning for the missing default bucket, got: %v", checks)
+	}
+
+	repo.conf.AWSS3BucketName, repo.conf.Remotes = "test-bucket", nil
+
+	//git configuration of a recorded chunking parameter is ignored
+	testGit(t, repo, "config", "bits.chunker", FastCDCChunking)
+	if st = status(); st["chunking"] != CheckWarning {
+		t.Errorf("expected a warning for ignored chunking configuration, got: %s", st["chunking"])
+	}
+
+	testGit(t, repo, "config", "--unset", "bits.chunker")
+
+	//a local override makes this clone chunk differently
+	repo.conf.DeduplicationScope++
+	if st = status(); st["chunking"] != CheckFailed {
+		t.Errorf("expected a chunking mismatch to fail, got: %s", st["chunking"])
+	}
+}
@@ -0,0 +1,304 @@
+package bits
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+	"github.com/dustin/go-humanize"
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//PlanChunk is a chunk that an operation would transfer
+type PlanChunk struct {
+	K K
+
+	//size of the chunk, -1 if unknown
+	Size int64
+
+	//the file that the chunk belongs to, if known
+	Path string
+
+	//name of the remote the chunk is transferred to or from, empty for the
+	//default remote
+	Remote string
+}
+
+//Plan describes what an operation would do without doing it: the chunks it
+//would transfer and the files it would write
+type Plan struct {
+	Op     Op
+	Chunks []PlanChunk
+
+	//chunks that are stored at the destination already
+	Skipped int
+
+	//packs on the remote whose index isn't known locally, chunks they hold
+	//may be counted as not stored
+	Unindexed int
+
+	//bytes of the chunks that would be transferred, as far as known
+	Bytes int64
+
+	//files that would be written and the bytes of their content
+	Files     []string
+	FileBytes int64
+
+	seen map[remoteKey]bool
+}
+
+//newPlan returns an empty plan for operation 'op'
+func newPlan(op Op) *Plan {
+	return &Plan{Op: op, seen: map[remoteKey]bool{}}
+}
+
+//add records that chunk 'k' of 'size' bytes would be transferred to or from
+//the remote with 'name', or is skipped if 'stored' tells it is at the
+//destination already. Each chunk is counted once per remote
+func (p *Plan) add(name string, k K, size int64, path string, stored bool) {
+	if p.seen[remoteKey{name, k}] {
+		return
+	}
+
+	p.seen[remoteKey{name, k}] = true
+	if stored {
+		p.Skipped++
+		return
+	}
+
+	p.Chunks = append(p.Chunks, PlanChunk{k, size, path, name})
+	p.Bytes += max(size, 0)
+}
+
+//Write writes a line for each chunk and file of the plan and a summary
+func (p *Plan) Write(w io.Writer) (err error) {
+	for _, c := range p.Chunks {
+		size := "unknown size"
+		if c.Size >= 0 {
+			size = humanize.Bytes(uint64(c.Size))
+		}
+
+		fmt.Fprintf(w, "would %s %x (%s)", p.Op, c.K, size)
+		if c.Path != "" {
+			fmt.Fprintf(w, " of '%s'", c.Path)
+		}
+
+		if c.Remote != "" && p.Op == PushOp {
+			fmt.Fprintf(w, " to remote '%s'", c.Remote)
+		} else if c.Remote != "" {
+			fmt.Fprintf(w, " from remote '%s'", c.Remote)
+		}
+
+		fmt.Fprintf(w, "\n")
+	}
+
+	for _, f := range p.Files {
+		fmt.Fprintf(w, "would write '%s'\n", f)
+	}
+
+	fmt.Fprintf(w, "would %s %d chunks (%s), %d are stored already", p.Op, len(p.Chunks), humanize.Bytes(uint64(p.Bytes)), p.Skipped)
+	if p.Op == FetchOp && p.Files != nil {
+		fmt.Fprintf(w, ", and write %d files (%s)", len(p.Files), humanize.Bytes(uint64(p.FileBytes)))
+	}
+
+	_, err = fmt.Fprintf(w, "\n")
+	if p.Unindexed > 0 {
+		_, err = fmt.Fprintf(w, "%d packs on the remote aren't indexed locally, chunks they hold may be listed although they are stored already\n", p.Unindexed)
+	}
+
+	return err
+}
+
+//PushPlan returns what Push would upload for the keys on 'r' without
+//uploading anything. Nothing is written locally either: chunks count as
+//stored remotely when the local index knows them, the remote lists them as a
+//chunk object or they are in a pack whose index is cached
+func (repo *Repository) PushPlan(store *bolt.DB, r io.Reader) (plan *Plan, err error) {
+	names, groups, err := repo.groupRouted(r)
+	if err != nil {
+		return nil, err
+	}
+
+	plan = newPlan(PushOp)
+	for _, name := range names {
+		remote, err := repo.chunkRemote(name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to push: %v", err)
+		}
+
+		listed, unindexed, err := repo.listRemote(store, name, remote)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Unindexed += unindexed
+		bucket := indexBucket(name)
+		for _, k := range groups[name] {
+			stored := listed[k]
+			err = store.View(func(tx *bolt.Tx) error {
+				if b := tx.Bucket(bucket); b != nil && b.Get(k[:]) != nil {
+					stored = true
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to read index: %v", err)
+			}
+
+			size := int64(-1)
+			p, _ := repo.Path(k, false)
+			if fi, err := os.Stat(p); err == nil {
+				size = fi.Size()
+			}
+
+			plan.add(name, k, size, "", stored)
+		}
+	}
+
+	return plan, nil
+}
+
+//listRemote returns the chunks that 'remote' with 'name' stores beyond the
+//local index: the chunk objects it lists and the chunks of packs that are
+//not indexed yet but whose index is cached. Pack indexes are not downloaded,
+//the number of packs whose index isn't cached is returned instead
+func (repo *Repository) listRemote(store *bolt.DB, name string, remote Remote) (listed map[K]bool, unindexed int, err error) {
+	listed = map[K]bool{}
+	buf := bytes.NewBuffer(nil)
+	err = remote.ListChunks(buf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list remote chunk keys: %v", err)
+	}
+
+	err = repo.ForEach(buf, func(k K) error {
+		listed[k] = true
+		return nil
+	})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	buf.Reset()
+	err = remote.ListPacks(buf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list remote packs: %v", err)
+	}
+
+	pbucket := packsBucket(name)
+	err = repo.ForEach(buf, func(pk K) error {
+		indexed := false
+		err := store.View(func(tx *bolt.Tx) error {
+			pb := tx.Bucket(pbucket)
+			indexed = pb != nil && pb.Get(pk[:]) != nil
+			return nil
+		})
+
+		if err != nil || indexed {
+			return err
+		}
+
+		data, err := os.ReadFile(repo.packIndexPath(name, pk))
+		if os.IsNotExist(err) {
+			unindexed++
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to read cached index of pack '%x': %v", pk, err)
+		}
+
+		return ReadPackIndex(pk, bytes.NewReader(data), func(k K, loc PackLoc) error {
+			listed[k] = true
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return listed, unindexed, nil
+}
+
+//FetchPlan returns what FetchFrom would download from the remote with 'name'
+//for the pointer or the keys on 'r' without downloading anything
+func (repo *Repository) FetchPlan(name string, r io.Reader) (plan *Plan, err error) {
+	plan = newPlan(FetchOp)
+	err = repo.planPointer(plan, name, "", r)
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+//planPointer adds the chunks of the pointer or keys on 'r' for the file at
+//'path', if known, to 'plan' as fetched from the remote with 'name'. Chunks
+//that are stored locally are skipped
+func (repo *Repository) planPointer(plan *Plan, name, path string, r io.Reader) (err error) {
+	bufr := bufio.NewReader(r)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); !pointer.IsPointer(hdr) {
+		return repo.ForEach(bufr, func(k K) error {
+			plan.add(name, k, -1, path, repo.isStored(k))
+			return nil
+		})
+	}
+
+	ptr, err := pointer.Decode(bufr)
+	if err != nil {
+		return fmt.Errorf("failed to decode pointer: %v", err)
+	}
+
+	for _, c := range ptr.Chunks {
+		plan.add(name, K(c.Key), c.Size, path, repo.isStored(K(c.Key)))
+	}
+
+	if path != "" {
+		plan.Files = append(plan.Files, path)
+		plan.FileBytes += max(ptr.Size, 0)
+	}
+
+	return nil
+}
+
+//isStored returns whether the chunk with key 'k' is stored locally
+func (repo *Repository) isStored(k K) bool {
+	p, _ := repo.Path(k, false)
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+//PullPlan returns what PullWith would fetch and which files it would write
+//without changing the working tree
+func (repo *Repository) PullPlan(ref string, pathspecs []string, filter *PathFilter) (plan *Plan, err error) {
+	plan = newPlan(FetchOp)
+	plan.Files = []string{}
+	err = repo.refPointers(ref, pathspecs, filter, func(pol *Policy, data []byte) error {
+
+		//like pull only working tree files that hold the same pointer are written
+		path := ""
+		if holdsPointer(filepath.Join(repo.rootDir, pol.Path), data) {
+			path = pol.Path
+		}
+
+		err := repo.planPointer(plan, pol.Remote, path, bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %v", pol.Path, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,160 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDryRun(t *testing.T) {
+	remote := newMemRemote()
+	repo := newTestRepository(t, remote)
+	installCleanFilter(t, repo)
+	writeAttributes(t, repo, "*.bin filter=bits")
+
+	data := make([]byte, 16*1024)
+	rand.Read(data)
+	err := os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+	ptr := testGit(t, repo, "show", ":a.bin")
+
+	store, err := repo.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+	plan, err := repo.PushPlan(store, strings.NewReader(ptr))
+	if err != nil || len(plan.Chunks) != 1 || plan.Skipped != 0 || plan.Bytes <= 0 {
+		t.Fatalf("unexpected push plan: %+v, %v", plan, err)
+	}
+
+	if len(remote.objects) != 0 {
+		t.Errorf("expected nothing to be pushed, got %d objects", len(remote.objects))
+	}
+
+	store.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(IndexBucket).Cursor().First(); k != nil || tx.Bucket(PacksBucket) != nil {
+			t.Error("expected the dry run to leave the local index as is")
+		}
+
+		return nil
+	})
+
+	err = repo.Push(store, strings.NewReader(ptr), "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err = repo.PushPlan(store, strings.NewReader(ptr))
+	if err != nil || len(plan.Chunks) != 0 || plan.Skipped != 1 {
+		t.Errorf("expected the pushed chunk to be skipped: %+v, %v", plan, err)
+	}
+
+	//packs that aren't indexed locally are counted, their index isn't downloaded
+	err = store.Update(func(tx *bolt.Tx) error {
+		tx.DeleteBucket(IndexBucket)
+		return tx.DeleteBucket(PacksBucket)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.RemoveAll(repo.packIndexDir(""))
+	plan, err = repo.PushPlan(store, strings.NewReader(ptr))
+	if err != nil || len(plan.Chunks) != 1 || plan.Unindexed != 1 {
+		t.Errorf("expected the chunk in an unindexed pack to be listed: %+v, %v", plan, err)
+	}
+
+	if _, err = os.Stat(repo.packIndexDir("")); !os.IsNotExist(err) {
+		t.Errorf("expected no pack index to be downloaded: %v", err)
+	}
+
+	//remove the local chunk and leave a pointer in the working tree
+	for rk := range plan.seen {
+		p, _ := repo.Path(rk.k, false)
+		os.Remove(p)
+	}
+
+	err = os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), []byte(ptr), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err = repo.FetchPlan("", strings.NewReader(ptr))
+	if err != nil || len(plan.Chunks) != 1 || plan.Bytes != int64(len(data)) || plan.Files != nil {
+		t.Errorf("unexpected fetch plan: %+v, %v", plan, err)
+	}
+
+	plan, err = repo.PullPlan("HEAD", nil, nil)
+	if err != nil || len(plan.Chunks) != 1 || len(plan.Files) != 1 || plan.FileBytes != int64(len(data)) {
+		t.Fatalf("unexpected pull plan: %+v, %v", plan, err)
+	}
+
+	if wt, _ := os.ReadFile(filepath.Join(repo.rootDir, "a.bin")); string(wt) != ptr {
+		t.Error("expected the working tree to be left as is")
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = plan.Write(out)
+	if err != nil || !strings.Contains(out.String(), "would write 'a.bin'") || !strings.HasSuffix(out.String(), "would fetch 1 chunks (16 kB), 0 are stored already, and write 1 files (16 kB)\n") {
+		t.Errorf("unexpected plan output: %s", out.String())
+	}
+}
+
+func TestDryRunRemotes(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	repo.remotes = map[string]Remote{"public": newMemRemote()}
+	data := make([]byte, 1024)
+	rand.Read(data)
+	ptr := bytes.NewBuffer(nil)
+	err := repo.Split(bytes.NewReader(data), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := bytes.NewBuffer(nil)
+	err = repo.ForEach(bytes.NewReader(ptr.Bytes()), func(k K) error {
+		_, err := fmt.Fprintf(keys, "%x\n%x public\n", k, k)
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := repo.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+	plan, err := repo.PushPlan(store, keys)
+	if err != nil || len(plan.Chunks) != 2 || plan.Bytes != 2*plan.Chunks[0].Size {
+		t.Fatalf("expected a key routed to two remotes to be counted for both: %+v, %v", plan, err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = plan.Write(out)
+	if err != nil || !strings.Contains(out.String(), "to remote 'public'\n") {
+		t.Errorf("expected the named remote to be listed, got: %s", out.String())
+	}
+
+	os.RemoveAll(repo.chunkDir)
+	plan, err = repo.FetchPlan("public", bytes.NewReader(ptr.Bytes()))
+	if err != nil || len(plan.Chunks) != 1 || plan.Chunks[0].Remote != "public" {
+		t.Errorf("expected the chunk to be fetched from the named remote: %+v, %v", plan, err)
+	}
+}
@@ -3,6 +3,7 @@ package bits
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -260,4 +261,70 @@ func TestRemoteChunkConstant(t *testing.T) {
 	if len(RemoteChunk) != 0 {
 		t.Error("RemoteChunk should be empty slice")
 	}
-}
\ No newline at end of file
+}
+
+func TestCombineVerifiesPointer(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+	ptr := &bytes.Buffer{}
+	err := repo.Split(bytes.NewReader(data), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(ptr.String(), "version 2\nsize 65536\n") {
+		t.Errorf("expected pointer to record its version and size, got: %s", ptr.String())
+	}
+
+	//pointers written by earlier versions only list keys
+	legacy := &bytes.Buffer{}
+	err = repo.ForEach(bytes.NewReader(ptr.Bytes()), func(k K) error {
+		_, err := fmt.Fprintf(legacy, "%x\n", k)
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacyPtr := "--- to use this file decode it with the 'git-bits' extension ---\n" + legacy.String() +
+		"----------------------- end of chunks --------------------------\n"
+	output := &bytes.Buffer{}
+	err = repo.Combine(strings.NewReader(legacyPtr), output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(output.Bytes(), data) {
+		t.Error("legacy pointer round-trip failed")
+	}
+
+	//corrupt the chunk, the whole file hash should no longer match
+	err = repo.ForEach(bytes.NewReader(ptr.Bytes()), func(k K) error {
+		p, _ := repo.Path(k, false)
+		chunk, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		chunk[len(chunk)/2] ^= 0xff
+		return os.WriteFile(p, chunk, 0666)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Combine(bytes.NewReader(ptr.Bytes()), io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "doesn't match the hash") {
+		t.Errorf("expected corrupted content to be detected, got: %v", err)
+	}
+
+	//the smudge filter doesn't hand unverified content to git
+	output.Reset()
+	err = repo.Smudge("a.bin", bytes.NewReader(ptr.Bytes()), output)
+	if err == nil || output.Len() != 0 {
+		t.Errorf("expected smudge to fail without output, got %d bytes: %v", output.Len(), err)
+	}
+}
@@ -0,0 +1,260 @@
+package bits
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//Estimate predicts what storing content with git-bits takes compared to
+//storing each distinct file as a whole
+type Estimate struct {
+
+	//distinct file contents and their total size
+	Files int
+	Bytes int64
+
+	//chunks the content is split into and the chunks that are unique
+	Chunks       int
+	UniqueChunks int
+	UniqueBytes  int64
+
+	//content that is stored as a pointer already and isn't estimated
+	Pointers int
+
+	contents map[[sha256.Size]byte]bool
+	chunks   map[K]bool
+}
+
+//DedupRatio returns how many bytes of content are stored per byte of unique
+//chunks, zero if there is no content
+func (e *Estimate) DedupRatio() float64 {
+	if e.UniqueBytes == 0 {
+		return 0
+	}
+
+	return float64(e.Bytes) / float64(e.UniqueBytes)
+}
+
+//Savings returns the fraction of the bytes that are saved compared to
+//storing each distinct file as a whole
+func (e *Estimate) Savings() float64 {
+	if e.Bytes == 0 {
+		return 0
+	}
+
+	return 1 - float64(e.UniqueBytes)/float64(e.Bytes)
+}
+
+//Estimate splits the content of the files and directories in 'args', or of
+//the blobs in the revisions or revision ranges in 'args', in memory with the
+//chunking configuration of the repository. Nothing is stored. Only paths
+//selected by 'filter' are split
+func (repo *Repository) Estimate(args []string, filter *PathFilter) (est *Estimate, err error) {
+	est = &Estimate{contents: map[[sha256.Size]byte]bool{}, chunks: map[K]bool{}}
+	for _, arg := range args {
+		if _, err := os.Stat(arg); err == nil {
+			err = repo.estimateFiles(est, arg, filter)
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		//rev-list understands ranges like 'v1.0..main' itself
+		if repo.gitOutput(context.Background(), "rev-parse", "-q", "--revs-only", arg) == "" {
+			return nil, fmt.Errorf("'%s' is neither a file nor a revision", arg)
+		}
+
+		pols, err := repo.revPolicies(arg, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		err = repo.scanBlobs([]string{arg}, 1, -1, func(obj, p string, r io.Reader) error {
+			if !filter.Match(p) {
+				return nil
+			}
+
+			return repo.estimateContent(est, pols[p], p, r)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return est, nil
+}
+
+//revPolicies returns the policies of the paths of the blobs in revision
+//'rev' that 'filter' selects, they are checked at once
+func (repo *Repository) revPolicies(rev string, filter *PathFilter) (pols map[string]*Policy, err error) {
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(context.Background(), nil, buf, "rev-list", "--objects", rev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects of '%s': %v", rev, err)
+	}
+
+	paths, seen := []string{}, map[string]bool{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		_, p, ok := strings.Cut(line, " ")
+		if ok && p != "" && !seen[p] && filter.Match(p) {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	return repo.Policies(paths)
+}
+
+//estimateFiles splits the file at 'root', or each file in the directory at
+//'root', for the estimate 'est'. The policies of the files are checked at
+//once after the walk
+func (repo *Repository) estimateFiles(est *Estimate, root string, filter *PathFilter) (err error) {
+	files, rels := []string{}, []string{}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		//policies and filters take the path relative to the working tree
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(repo.rootDir, abs)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = p
+		}
+
+		rel = filepath.ToSlash(rel)
+		if filter.Match(rel) {
+			files, rels = append(files, p), append(rels, rel)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	pols, err := repo.Policies(rels)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range files {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+
+		err = repo.estimateContent(est, pols[rels[i]], rels[i], f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//estimateContent splits content 'r' of the file at path 'p' as policy 'pol'
+//dictates and counts its chunks, identical content is counted once. The
+//content is streamed through the chunker while it is hashed, the chunks of a
+//file are only counted once its hash is known
+func (repo *Repository) estimateContent(est *Estimate, pol *Policy, p string, r io.Reader) (err error) {
+	bufr := bufio.NewReader(r)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); pointer.IsPointer(hdr) {
+		est.Pointers++
+		return nil
+	}
+
+	err = pol.Validate(repo.conf)
+	if err != nil {
+		return err
+	}
+
+	conf := pol.Conf(repo.conf)
+	fileh := sha256.New()
+	chunkr, err := NewChunker(io.TeeReader(bufr, fileh), conf)
+	if err != nil {
+		return fmt.Errorf("failed to setup chunker: %v", err)
+	}
+
+	type chunk struct {
+		k    K
+		size int
+	}
+
+	chunks, size := []chunk{}, int64(0)
+	buf := make([]byte, conf.ChunkMaxSize)
+	for {
+		data, err := chunkr.Next(buf)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to chunk '%s': %v", p, err)
+		}
+
+		chunks = append(chunks, chunk{K(sha256.Sum256(data)), len(data)})
+		size += int64(len(data))
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], fileh.Sum(nil))
+	if est.contents[sum] {
+		return nil
+	}
+
+	est.contents[sum] = true
+	est.Files++
+	est.Bytes += size
+	for _, c := range chunks {
+		est.Chunks++
+		if !est.chunks[c.k] {
+			est.chunks[c.k] = true
+			est.UniqueChunks++
+			est.UniqueBytes += int64(c.size)
+		}
+	}
+
+	return nil
+}
+
+//Write writes the estimate in a human readable form
+func (e *Estimate) Write(w io.Writer) (err error) {
+	fmt.Fprintf(w, "distinct file contents: %d (%s)\n", e.Files, humanize.IBytes(uint64(e.Bytes)))
+	fmt.Fprintf(w, "chunks: %d, unique: %d (%s)\n", e.Chunks, e.UniqueChunks, humanize.IBytes(uint64(e.UniqueBytes)))
+	fmt.Fprintf(w, "deduplication ratio: %.2f\n", e.DedupRatio())
+	_, err = fmt.Fprintf(w, "compared to storing whole files: %s instead of %s, %.1f%% saved\n", humanize.IBytes(uint64(e.UniqueBytes)), humanize.IBytes(uint64(e.Bytes)), e.Savings()*100)
+	if e.Pointers > 0 {
+		_, err = fmt.Fprintf(w, "%d files are stored as pointers already and were skipped\n", e.Pointers)
+	}
+
+	return err
+}
@@ -0,0 +1,73 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	repo.conf.ChunkMinSize, repo.conf.ChunkAvgSize, repo.conf.ChunkMaxSize = 4*1024, 8*1024, 16*1024
+
+	//the second version appends to the first, most chunks are shared
+	data := make([]byte, 256*1024)
+	rand.Read(data)
+	os.MkdirAll(filepath.Join(repo.rootDir, "assets"), 0777)
+	for i, name := range []string{"assets/a.bin", "assets/b.bin", "assets/c.bin"} {
+		err := os.WriteFile(filepath.Join(repo.rootDir, name), data[:len(data)-(i%2)*64*1024], 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	os.WriteFile(filepath.Join(repo.rootDir, "readme.txt"), []byte("hello"), 0666)
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+
+	filter, err := NewPathFilter([]string{"*.bin"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{filepath.Join(repo.rootDir, "assets")}, {"HEAD"}} {
+		est, err := repo.Estimate(args, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if est.Files != 2 || est.Bytes != int64(len(data)+192*1024) {
+			t.Errorf("unexpected files for %v: %d (%d bytes)", args, est.Files, est.Bytes)
+		}
+
+		if est.UniqueBytes >= est.Bytes || est.UniqueBytes < int64(len(data)) || est.DedupRatio() <= 1 {
+			t.Errorf("expected chunks to be shared for %v: %d unique bytes of %d", args, est.UniqueBytes, est.Bytes)
+		}
+
+		out := bytes.NewBuffer(nil)
+		if err = est.Write(out); err != nil || !strings.Contains(out.String(), "saved") {
+			t.Errorf("unexpected output: %s", out.String())
+		}
+	}
+
+	//the attributes of the paths are applied
+	writeAttributes(t, repo, "*.bin bits-chunk-size=100k")
+	for _, args := range [][]string{{filepath.Join(repo.rootDir, "assets")}, {"HEAD"}} {
+		if _, err = repo.Estimate(args, filter); err == nil || !strings.Contains(err.Error(), ".bin") {
+			t.Errorf("expected the invalid chunk size of the path to fail for %v, got: %v", args, err)
+		}
+	}
+
+	_, err = repo.Estimate([]string{"no-such-rev"}, nil)
+	if err == nil {
+		t.Error("expected an unknown revision to fail")
+	}
+
+	//nothing is stored
+	if entries, _ := os.ReadDir(repo.chunkDir); len(entries) > 1 {
+		t.Errorf("expected no chunks to be stored, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,99 @@
+package bits
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+//Event is a line of the JSON event stream, it describes a single chunk
+//operation
+type Event struct {
+	Type       string  `json:"type"`
+	Op         Op      `json:"op"`
+	Key        string  `json:"key"`
+	Skipped    bool    `json:"skipped"`
+	Bytes      int64   `json:"bytes"`
+	Throughput float64 `json:"throughput"`
+	Path       string  `json:"path,omitempty"`
+}
+
+//OpTotals are the totals of all chunk operations of a kind
+type OpTotals struct {
+	Chunks  int   `json:"chunks"`
+	Skipped int   `json:"skipped"`
+	Bytes   int64 `json:"bytes"`
+}
+
+//Summary is the last line of the JSON event stream
+type Summary struct {
+	Type     string           `json:"type"`
+	Totals   map[Op]*OpTotals `json:"totals"`
+	Errors   []string         `json:"errors"`
+	Duration float64          `json:"duration"`
+}
+
+//EventStream writes an event for each chunk operation as a line of JSON,
+//assign its KeyProgress method to the KeyProgressFn of a repository
+type EventStream struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	sum   Summary
+	start time.Time
+}
+
+//NewEventStream returns an event stream that writes to 'w'
+func NewEventStream(w io.Writer) *EventStream {
+	return &EventStream{
+		enc:   json.NewEncoder(w),
+		sum:   Summary{Type: "summary", Totals: map[Op]*OpTotals{}, Errors: []string{}},
+		start: time.Now(),
+	}
+}
+
+//KeyProgress writes an event for chunk operation 'kop' with throughput 'tp'
+//in bytes per second and adds it to the totals
+func (s *EventStream) KeyProgress(kop KeyOp, tp float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tot, ok := s.sum.Totals[kop.Op]
+	if !ok {
+		tot = &OpTotals{}
+		s.sum.Totals[kop.Op] = tot
+	}
+
+	tot.Chunks++
+	tot.Bytes += kop.CopyN
+	if kop.Skipped {
+		tot.Skipped++
+	}
+
+	err := s.enc.Encode(Event{
+		Type:       "chunk",
+		Op:         kop.Op,
+		Key:        fmt.Sprintf("%x", kop.K),
+		Skipped:    kop.Skipped,
+		Bytes:      kop.CopyN,
+		Throughput: tp,
+		Path:       kop.Path,
+	})
+
+	if err != nil {
+		s.sum.Errors = append(s.sum.Errors, fmt.Sprintf("failed to write event: %v", err))
+	}
+}
+
+//Close writes the summary with the totals, 'err' is the error that the
+//operation ended with, if any
+func (s *EventStream) Close(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.sum.Errors = append(s.sum.Errors, err.Error())
+	}
+
+	s.sum.Duration = time.Since(s.start).Seconds()
+	return s.enc.Encode(s.sum)
+}
@@ -0,0 +1,68 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEventStream(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	out := bytes.NewBuffer(nil)
+	events := NewEventStream(out)
+	repo.KeyProgressFn = events.KeyProgress
+
+	data := make([]byte, 16*1024)
+	rand.Read(data)
+	pol, err := repo.Policy("a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ptr := bytes.NewBuffer(nil)
+	err = repo.SplitWith(pol, bytes.NewReader(data), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Combine(bytes.NewReader(ptr.Bytes()), bytes.NewBuffer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.Close()
+	err = events.Close(fmt.Errorf("some error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	for _, line := range lines[:len(lines)-1] {
+		ev := Event{}
+		err = json.Unmarshal([]byte(line), &ev)
+		if err != nil || ev.Type != "chunk" || len(ev.Key) != 2*KeySize {
+			t.Errorf("unexpected event '%s': %v", line, err)
+		}
+
+		if ev.Op == StageOp && ev.Path != "a.bin" {
+			t.Errorf("expected stage event for 'a.bin', got: %s", line)
+		}
+	}
+
+	sum := Summary{}
+	err = json.Unmarshal([]byte(lines[len(lines)-1]), &sum)
+	if err != nil || sum.Type != "summary" {
+		t.Fatalf("unexpected summary '%s': %v", lines[len(lines)-1], err)
+	}
+
+	if sum.Totals[StageOp] == nil || sum.Totals[StageOp].Bytes == 0 || sum.Totals[CombineOp] == nil || sum.Totals[CombineOp].Bytes != int64(len(data)) {
+		t.Errorf("unexpected totals: %s", lines[len(lines)-1])
+	}
+
+	if len(sum.Errors) != 1 || sum.Errors[0] != "some error" {
+		t.Errorf("expected the error in the summary, got: %v", sum.Errors)
+	}
+}
@@ -0,0 +1,285 @@
+package bits
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//PrePushHook is the pre-push hook that install wrote before hooks were
+//chained, hooks with exactly this content are replaced
+const PrePushHook = `#!/bin/sh
+			command -v git-bits >/dev/null 2>&1 || { echo >&2 "This project was setup with git-bits but it can (no longer) be found in your PATH: $PATH."; exit 0; }
+			git-bits scan | git-bits push
+	`
+
+const (
+	//hookBegin and hookEnd mark the git-bits section of a hook
+	hookBegin = "# >>> git-bits >>>"
+	hookEnd   = "# <<< git-bits <<<"
+
+	//hookChainSuffix is appended to the name of a hook that isn't a shell
+	//script, it is run from the hook that install writes in its place
+	hookChainSuffix = ".pre-bits"
+)
+
+//Hooks are the names of the git hooks that git-bits can run
+var Hooks = []string{"pre-push", "post-checkout", "post-merge", "post-rewrite"}
+
+//PullHooks are the hooks that combine the files that were changed by a
+//checkout, merge or rewrite
+var PullHooks = []string{"post-checkout", "post-merge", "post-rewrite"}
+
+//hookPath returns the path of the git hook with 'name', it honors the
+//core.hooksPath configuration
+func (repo *Repository) hookPath(name string) string {
+	p := repo.gitOutput(context.Background(), "rev-parse", "--git-path", filepath.Join("hooks", name))
+	if p == "" {
+		return filepath.Join(repo.gitDir, "hooks", name)
+	}
+
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(repo.rootDir, p)
+	}
+
+	return p
+}
+
+//hookSection returns the git-bits section of the hook with 'name'. Input on
+//stdin is kept for the commands that follow the section and 'chained' tells
+//whether a renamed hook is run after git-bits
+func hookSection(name string, chained bool) string {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "%s\n", hookBegin)
+	fmt.Fprintf(buf, "if command -v git-bits >/dev/null 2>&1; then\n")
+	fmt.Fprintf(buf, "\tbits_input=\"$(mktemp)\" && cat >\"$bits_input\" || exit 1\n")
+	fmt.Fprintf(buf, "\tgit bits hook %s \"$@\" <\"$bits_input\" || { rm -f \"$bits_input\"; exit 1; }\n", name)
+	fmt.Fprintf(buf, "\texec <\"$bits_input\"; rm -f \"$bits_input\"\n")
+	fmt.Fprintf(buf, "else\n")
+	fmt.Fprintf(buf, "\techo >&2 \"This project was setup with git-bits but it can (no longer) be found in your PATH: $PATH.\"\n")
+	fmt.Fprintf(buf, "fi\n")
+	if chained {
+		fmt.Fprintf(buf, "exec \"$0%s\" \"$@\"\n", hookChainSuffix)
+	}
+
+	fmt.Fprintf(buf, "%s\n", hookEnd)
+	return buf.String()
+}
+
+//InstallHooks writes the git-bits section into each hook in 'names' like
+//install does for the pre-push hook, e.g. to add the PullHooks
+func (repo *Repository) InstallHooks(w io.Writer, names ...string) (err error) {
+	for _, name := range names {
+		if !contains(Hooks, name) {
+			return fmt.Errorf("unsupported hook '%s', expected one of: %s", name, strings.Join(Hooks, ", "))
+		}
+
+		err = repo.installHook(name, w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//installHook writes the git-bits section into the hook with 'name'. A new
+//hook is created if there is none, a shell script gets the section inserted
+//after its shebang or replaced if it has one already and any other hook is
+//renamed such that the new hook runs it after git-bits
+func (repo *Repository) installHook(name string, w io.Writer) (err error) {
+	hookp := repo.hookPath(name)
+	err = os.MkdirAll(filepath.Dir(hookp), 0777)
+	if err != nil {
+		return fmt.Errorf("failed to create hooks directory: %v", err)
+	}
+
+	data, err := os.ReadFile(hookp)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read hook: %v", err)
+	}
+
+	text := string(data)
+	if text == PrePushHook {
+		text = ""
+	}
+
+	var ndata string
+	switch {
+	case text == "":
+		ndata = "#!/bin/sh\n" + hookSection(name, false)
+	case strings.Contains(text, hookBegin):
+		begin := strings.Index(text, hookBegin)
+		end := strings.Index(text, hookEnd)
+		if end < begin {
+			return fmt.Errorf("the git-bits section of hook '%s' is not closed with '%s'", hookp, hookEnd)
+		}
+
+		chained := strings.Contains(text[begin:end], hookChainSuffix)
+		ndata = text[:begin] + hookSection(name, chained) + strings.TrimPrefix(text[end+len(hookEnd):], "\n")
+	case isShellScript(text):
+		shebang, rest, _ := strings.Cut(text, "\n")
+		ndata = shebang + "\n" + hookSection(name, false) + rest
+	default:
+		err = os.Rename(hookp, hookp+hookChainSuffix)
+		if err != nil {
+			return fmt.Errorf("failed to rename hook: %v", err)
+		}
+
+		fmt.Fprintf(w, "moved the existing %s hook to '%s', it is run after git-bits\n", name, hookp+hookChainSuffix)
+		ndata = "#!/bin/sh\n" + hookSection(name, true)
+	}
+
+	if ndata == string(data) {
+		return nil
+	}
+
+	err = os.WriteFile(hookp, []byte(ndata), 0777)
+	if err != nil {
+		return fmt.Errorf("failed to write hook: %v", err)
+	}
+
+	fmt.Fprintf(w, "installed git-bits in %s hook '%s'\n", name, hookp)
+	return nil
+}
+
+//isShellScript returns whether hook content 'text' is run by a shell that
+//understands the git-bits section
+func isShellScript(text string) bool {
+	shebang, _, _ := strings.Cut(text, "\n")
+	fields := strings.Fields(strings.TrimPrefix(shebang, "#!"))
+	if !strings.HasPrefix(shebang, "#!") || len(fields) == 0 {
+		return false
+	}
+
+	interp := filepath.Base(fields[0])
+	if interp == "env" && len(fields) > 1 {
+		interp = fields[1]
+	}
+
+	switch interp {
+	case "sh", "bash", "dash", "ksh", "zsh":
+		return true
+	default:
+		return false
+	}
+}
+
+//uninstallHook removes the git-bits section from the hook with 'name', or
+//the whole hook if it is the PrePushHook that older versions wrote. The hook
+//is removed if nothing else remains, other lines are never touched. A hook
+//that was renamed by install is put back
+func (repo *Repository) uninstallHook(name string, w io.Writer) (err error) {
+	hookp := repo.hookPath(name)
+	data, err := os.ReadFile(hookp)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read hook: %v", err)
+	}
+
+	text := string(data)
+	if text == PrePushHook {
+		text = ""
+	}
+
+	if begin, end := strings.Index(text, hookBegin), strings.Index(text, hookEnd); begin >= 0 && end > begin {
+		text = text[:begin] + strings.TrimPrefix(text[end+len(hookEnd):], "\n")
+	}
+
+	other := false
+	for _, line := range strings.Split(text, "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			other = true
+		}
+	}
+
+	if !other {
+		err = os.Remove(hookp)
+		if err != nil {
+			return fmt.Errorf("failed to remove hook: %v", err)
+		}
+
+		fmt.Fprintf(w, "removed %s hook\n", name)
+		if _, err = os.Stat(hookp + hookChainSuffix); err == nil {
+			err = os.Rename(hookp+hookChainSuffix, hookp)
+			if err != nil {
+				return fmt.Errorf("failed to restore hook: %v", err)
+			}
+
+			fmt.Fprintf(w, "restored the original %s hook\n", name)
+		}
+
+		return nil
+	}
+
+	if text != string(data) {
+		err = os.WriteFile(hookp, []byte(text), 0777)
+		if err != nil {
+			return fmt.Errorf("failed to write hook: %v", err)
+		}
+
+		fmt.Fprintf(w, "removed git-bits from %s hook, other commands in '%s' are kept\n", name, hookp)
+	}
+
+	return nil
+}
+
+//RunHook runs the git-bits part of the git hook with 'name', 'args' and 'r'
+//are the arguments and input that git passed to the hook
+func (repo *Repository) RunHook(name string, args []string, r io.Reader, w io.Writer) (err error) {
+	switch name {
+	case "pre-push":
+		store, err := repo.LocalStore()
+		if err != nil {
+			return fmt.Errorf("failed to open local store: %v", err)
+		}
+
+		defer store.Close()
+		keys := bytes.NewBuffer(nil)
+		err = repo.ScanEach(r, keys)
+		if err != nil {
+			return fmt.Errorf("failed to scan: %v", err)
+		}
+
+		return repo.Push(store, keys, "origin")
+	case "post-checkout":
+		//args are: <previous HEAD> <new HEAD> <whether branches were switched>
+		if len(args) < 2 {
+			return fmt.Errorf("expected the previous and new HEAD as arguments, got: %v", args)
+		}
+
+		return repo.PullChanged(args[0], args[1], w)
+	case "post-merge":
+		return repo.PullChanged(repo.origHead(), "HEAD", w)
+	case "post-rewrite":
+		//a rebase moves the working tree from ORIG_HEAD, an amend only
+		//replaces the commit that is the first on the input: <old> <new>
+		from := repo.origHead()
+		if len(args) > 0 && args[0] == "amend" {
+			s := bufio.NewScanner(r)
+			if !s.Scan() {
+				return s.Err()
+			}
+
+			from = strings.Fields(s.Text() + " ")[0]
+		}
+
+		return repo.PullChanged(from, "HEAD", w)
+	default:
+		return fmt.Errorf("unsupported hook '%s', expected one of: %s", name, strings.Join(Hooks, ", "))
+	}
+}
+
+//origHead returns the commit that HEAD pointed to before the last merge or
+//rebase, empty if there is none
+func (repo *Repository) origHead() string {
+	return repo.gitOutput(context.Background(), "rev-parse", "-q", "--verify", "ORIG_HEAD")
+}
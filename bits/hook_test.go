@@ -0,0 +1,180 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstallHook(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	hookp := repo.hookPath("pre-push")
+	out := bytes.NewBuffer(nil)
+
+	//hooks written by older versions are replaced
+	os.WriteFile(hookp, []byte(PrePushHook), 0777)
+	err := repo.installHook("pre-push", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(hookp)
+	if exp := "#!/bin/sh\n" + hookSection("pre-push", false); string(data) != exp {
+		t.Errorf("expected hook '%s', got '%s'", exp, data)
+	}
+
+	out.Reset()
+	err = repo.installHook("pre-push", out)
+	if err != nil || out.Len() != 0 {
+		t.Errorf("expected installing twice to change nothing, got '%s', %v", out.String(), err)
+	}
+
+	//a shell hook gets the section after its shebang
+	orig := "#!/usr/bin/env bash\n./lint.sh\n"
+	os.WriteFile(hookp, []byte(orig), 0777)
+	for i := 0; i < 2; i++ {
+		err = repo.installHook("pre-push", out)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, _ = os.ReadFile(hookp)
+	if exp := "#!/usr/bin/env bash\n" + hookSection("pre-push", false) + "./lint.sh\n"; string(data) != exp {
+		t.Errorf("expected hook '%s', got '%s'", exp, data)
+	}
+
+	err = repo.uninstallHook("pre-push", out)
+	if data, _ = os.ReadFile(hookp); err != nil || string(data) != orig {
+		t.Errorf("expected uninstall to restore the hook, got '%s', %v", data, err)
+	}
+
+	//other hooks are renamed and run after git-bits
+	orig = "#!/usr/bin/env python3\nprint('hi')\n"
+	os.WriteFile(hookp, []byte(orig), 0777)
+	err = repo.installHook("pre-push", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ = os.ReadFile(hookp)
+	if chained, _ := os.ReadFile(hookp + hookChainSuffix); string(chained) != orig || !strings.Contains(string(data), hookChainSuffix) {
+		t.Errorf("expected the hook to be chained, got '%s'", data)
+	}
+
+	err = repo.uninstallHook("pre-push", out)
+	if data, _ = os.ReadFile(hookp); err != nil || string(data) != orig {
+		t.Errorf("expected uninstall to restore the chained hook, got '%s', %v", data, err)
+	}
+}
+
+func TestHooksPath(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	testGit(t, repo, "config", "core.hooksPath", "githooks")
+	if exp := filepath.Join(repo.rootDir, "githooks", "pre-push"); repo.hookPath("pre-push") != exp {
+		t.Errorf("expected hook path '%s', got '%s'", exp, repo.hookPath("pre-push"))
+	}
+
+	err := repo.installHook("pre-push", bytes.NewBuffer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(filepath.Join(repo.rootDir, "githooks", "pre-push")); err != nil {
+		t.Errorf("expected the hook in core.hooksPath: %v", err)
+	}
+
+	if err = repo.RunHook("pre-commit", nil, nil, nil); err == nil {
+		t.Error("expected an unsupported hook to fail")
+	}
+}
+
+func TestPullChanged(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	writeAttributes(t, repo, "*.bin filter=bits")
+
+	contents := map[string][]byte{}
+	commits := []string{}
+	for _, name := range []string{"a.bin", "b.bin"} {
+		contents[name] = make([]byte, 16*1024)
+		rand.Read(contents[name])
+		os.WriteFile(filepath.Join(repo.rootDir, name), contents[name], 0666)
+		testGit(t, repo, "add", "-A")
+		testGit(t, repo, "commit", "-m", name)
+		commits = append(commits, strings.TrimSpace(testGit(t, repo, "rev-parse", "HEAD")))
+	}
+
+	//the working tree holds pointers as if the smudge filter didn't run
+	pointers := func() {
+		for name := range contents {
+			os.WriteFile(filepath.Join(repo.rootDir, name), []byte(testGit(t, repo, "show", ":"+name)), 0666)
+		}
+	}
+
+	pointers()
+	err := repo.RunHook("post-checkout", []string{commits[0], commits[1], "1"}, nil, bytes.NewBuffer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, exp := range map[string]bool{"a.bin": false, "b.bin": true} {
+		if data, _ := os.ReadFile(filepath.Join(repo.rootDir, name)); bytes.Equal(data, contents[name]) != exp {
+			t.Errorf("expected '%s' to be combined: %v", name, exp)
+		}
+	}
+
+	//after a clone the previous HEAD is the null commit
+	pointers()
+	err = repo.PullChanged(strings.Repeat("0", 40), commits[1], bytes.NewBuffer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range contents {
+		if data, _ := os.ReadFile(filepath.Join(repo.rootDir, name)); !bytes.Equal(data, contents[name]) {
+			t.Errorf("expected '%s' to be combined", name)
+		}
+	}
+}
+
+func TestPrePushRefs(t *testing.T) {
+	remote := newMemRemote()
+	repo := newTestRepository(t, remote)
+	installCleanFilter(t, repo)
+	writeAttributes(t, repo, "*.bin filter=bits")
+	testGit(t, repo, "add", ".gitattributes")
+	testGit(t, repo, "commit", "-m", "c0")
+	base := strings.TrimSpace(testGit(t, repo, "rev-parse", "HEAD"))
+
+	//each branch adds a file, both are pushed at once
+	input := ""
+	for _, branch := range []string{"one", "two"} {
+		testGit(t, repo, "checkout", "-q", "-b", branch, base)
+		data := make([]byte, 16*1024)
+		rand.Read(data)
+		os.WriteFile(filepath.Join(repo.rootDir, branch+".bin"), data, 0666)
+		testGit(t, repo, "add", "-A")
+		testGit(t, repo, "commit", "-m", branch)
+		input += fmt.Sprintf("refs/heads/%s %s refs/heads/%s %040d\n", branch, strings.TrimSpace(testGit(t, repo, "rev-parse", "HEAD")), branch, 0)
+	}
+
+	//a deleted ref has nothing to push
+	input += fmt.Sprintf("(delete) %040d refs/heads/old %s\n", 0, base)
+	err := repo.RunHook("pre-push", nil, strings.NewReader(input), bytes.NewBuffer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo2 := newTestRepository(t, remote)
+	for _, rev := range []string{"one:one.bin", "two:two.bin"} {
+		err = repo2.Fetch(strings.NewReader(testGit(t, repo, "show", rev)), bytes.NewBuffer(nil))
+		if err != nil {
+			t.Errorf("expected the chunks of '%s' to be pushed: %v", rev, err)
+		}
+	}
+}
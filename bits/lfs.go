@@ -0,0 +1,298 @@
+package bits
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	//LFSPointerMaxSize is the largest size of a Git LFS pointer file
+	LFSPointerMaxSize = int64(1024)
+
+	//lfsVersionLine starts every Git LFS pointer file
+	lfsVersionLine = "version https://git-lfs.github.com/spec/v1"
+)
+
+//LFSPointer describes a Git LFS pointer file
+type LFSPointer struct {
+	OID  string
+	Size int64
+}
+
+//ParseLFSPointer parses the content of a Git LFS pointer file, it returns
+//false if 'data' isn't a pointer
+func ParseLFSPointer(data []byte) (ptr *LFSPointer, ok bool) {
+	if int64(len(data)) > LFSPointerMaxSize || !bytes.HasPrefix(data, []byte(lfsVersionLine+"\n")) {
+		return nil, false
+	}
+
+	ptr = &LFSPointer{Size: -1}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		key, val, _ := strings.Cut(s.Text(), " ")
+		switch key {
+		case "oid":
+			oid, found := strings.CutPrefix(val, "sha256:")
+			if b, err := hex.DecodeString(oid); !found || err != nil || len(b) != sha256.Size {
+				return nil, false
+			}
+
+			ptr.OID = oid
+		case "size":
+			size, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || size < 0 {
+				return nil, false
+			}
+
+			ptr.Size = size
+		}
+	}
+
+	if ptr.OID == "" || ptr.Size < 0 {
+		return nil, false
+	}
+
+	return ptr, true
+}
+
+//lfsObjectPath returns where Git LFS stores the object locally
+func (repo *Repository) lfsObjectPath(oid string) string {
+	return filepath.Join(repo.gitDir, "lfs", "objects", oid[0:2], oid[2:4], oid)
+}
+
+//splitLFSObject splits the local Git LFS object of pointer 'lptr' with the
+//policy of its file and writes the git-bits pointer to 'w'
+func (repo *Repository) splitLFSObject(pol *Policy, lptr *LFSPointer, w io.Writer) (err error) {
+	p := pol.Path
+	f, err := os.Open(repo.lfsObjectPath(lptr.OID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("object '%s' of '%s' isn't in the local LFS store, run `git lfs fetch --all` first", lptr.OID, p)
+		}
+
+		return fmt.Errorf("failed to open LFS object: %v", err)
+	}
+
+	defer f.Close()
+	h := sha256.New()
+	err = repo.SplitWith(pol, io.TeeReader(f, h), w)
+	if err != nil {
+		return fmt.Errorf("failed to split LFS object '%s': %v", lptr.OID, err)
+	}
+
+	if fmt.Sprintf("%x", h.Sum(nil)) != lptr.OID {
+		return fmt.Errorf("LFS object '%s' of '%s' is corrupt, its content has a different hash", lptr.OID, p)
+	}
+
+	return nil
+}
+
+//ConvertLFSAttributes replaces the Git LFS filter in .gitattributes content
+//with the bits filter, the LFS diff and merge drivers are removed
+func ConvertLFSAttributes(data []byte) []byte {
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		changed := false
+		nfields := []string{}
+		for _, f := range fields {
+			switch f {
+			case "filter=lfs":
+				f, changed = "filter=bits", true
+			case "diff=lfs", "merge=lfs":
+				changed = true
+				continue
+			}
+
+			nfields = append(nfields, f)
+		}
+
+		if changed {
+			lines[i] = strings.Join(nfields, " ")
+			if strings.HasSuffix(line, "\n") {
+				lines[i] += "\n"
+			}
+		}
+	}
+
+	return []byte(strings.Join(lines, ""))
+}
+
+//ImportLFS converts the files in the index that are Git LFS pointers to
+//git-bits pointers using the objects in the local LFS store and converts the
+//filters in .gitattributes. The converted files are staged and their path is
+//written to 'w', the working tree receives their content if it held pointers
+func (repo *Repository) ImportLFS(w io.Writer) (err error) {
+	ctx := context.Background()
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(ctx, nil, buf, "ls-files", "-s", "-z")
+	if err != nil {
+		return fmt.Errorf("failed to list index: %v", err)
+	}
+
+	//attributes are converted first, they determine the policy of each path
+	type entry struct{ mode, obj, path string }
+	entries := []entry{}
+	for _, line := range strings.Split(buf.String(), "\x00") {
+		tfields := strings.SplitN(line, "\t", 2)
+		fields := strings.Fields(tfields[0])
+		if len(tfields) != 2 || len(fields) != 3 || fields[0] == "160000" || fields[0] == "120000" {
+			continue
+		}
+
+		e := entry{fields[0], fields[1], tfields[1]}
+		if path.Base(e.path) != ".gitattributes" {
+			entries = append(entries, e)
+			continue
+		}
+
+		fpath := filepath.Join(repo.rootDir, e.path)
+		data, err := os.ReadFile(fpath)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %v", e.path, err)
+		}
+
+		if ndata := ConvertLFSAttributes(data); !bytes.Equal(ndata, data) {
+			err = os.WriteFile(fpath, ndata, 0666)
+			if err != nil {
+				return fmt.Errorf("failed to write '%s': %v", e.path, err)
+			}
+
+			err = repo.Git(ctx, nil, nil, "add", "--", e.path)
+			if err != nil {
+				return fmt.Errorf("failed to stage '%s': %v", e.path, err)
+			}
+
+			fmt.Fprintf(w, "%s\n", e.path)
+		}
+	}
+
+	for _, e := range entries {
+		data := bytes.NewBuffer(nil)
+		err = repo.Git(ctx, nil, data, "cat-file", "blob", e.obj)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %v", e.path, err)
+		}
+
+		lptr, ok := ParseLFSPointer(data.Bytes())
+		if !ok {
+			continue
+		}
+
+		pol, err := repo.Policy(e.path)
+		if err != nil {
+			return err
+		}
+
+		ptr := bytes.NewBuffer(nil)
+		err = repo.splitLFSObject(pol, lptr, ptr)
+		if err != nil {
+			return err
+		}
+
+		obj, err := repo.writeBlob(ctx, ptr)
+		if err != nil {
+			return err
+		}
+
+		err = repo.Git(ctx, nil, nil, "update-index", "--cacheinfo", e.mode+","+obj+","+e.path)
+		if err != nil {
+			return fmt.Errorf("failed to stage '%s': %v", e.path, err)
+		}
+
+		//a working tree without the LFS filter holds the pointer, replace it
+		//with the actual content
+		fpath := filepath.Join(repo.rootDir, e.path)
+		if wdata, err := os.ReadFile(fpath); err == nil {
+			if _, ok := ParseLFSPointer(wdata); ok {
+				err = copyFile(repo.lfsObjectPath(lptr.OID), fpath)
+				if err != nil {
+					return fmt.Errorf("failed to write content of '%s': %v", e.path, err)
+				}
+			}
+		}
+
+		fmt.Fprintf(w, "%s\n", e.path)
+	}
+
+	return nil
+}
+
+//ImportLFSHistory rewrites the commits of 'refs' such that Git LFS pointers
+//become git-bits pointers and .gitattributes use the bits filter. The LFS
+//pointers are found with a single pass over the small blobs in history
+func (repo *Repository) ImportLFSHistory(refs []string, w io.Writer) (err error) {
+	return repo.RewriteHistory(refs, &Rewrite{
+		Match: func(p string, size int64) bool {
+			return size <= LFSPointerMaxSize
+		},
+		Candidates: func(refs []string) (map[string]bool, error) {
+			objs := map[string]bool{}
+			return objs, repo.scanBlobs(refs, 1, LFSPointerMaxSize, func(obj, p string, r io.Reader) error {
+				data, err := io.ReadAll(r)
+				if err != nil {
+					return err
+				}
+
+				if _, ok := ParseLFSPointer(data); ok {
+					objs[obj] = true
+				}
+
+				return nil
+			})
+		},
+		Blob: func(pol *Policy, r io.Reader, w io.Writer) error {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+
+			lptr, ok := ParseLFSPointer(data)
+			if !ok {
+				_, err = w.Write(data)
+				return err
+			}
+
+			return repo.splitLFSObject(pol, lptr, w)
+		},
+		Attributes: func(p string, data []byte) ([]byte, error) {
+			return ConvertLFSAttributes(data), nil
+		},
+	}, w)
+}
+
+//copyFile overwrites file 'dst' with the content of 'src'
+func copyFile(src, dst string) (err error) {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer sf.Close()
+	df, err := os.OpenFile(dst, os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(df, sf)
+	if err != nil {
+		df.Close()
+		return err
+	}
+
+	return df.Close()
+}
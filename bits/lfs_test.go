@@ -0,0 +1,182 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//testGit runs git in the test repository and fails the test on errors
+func testGit(t *testing.T, repo *Repository, args ...string) string {
+	buf := bytes.NewBuffer(nil)
+	err := repo.Git(nil, nil, buf, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+//testGitInput runs git like testGit with 'input' on stdin
+func testGitInput(t *testing.T, repo *Repository, input string, args ...string) string {
+	buf := bytes.NewBuffer(nil)
+	err := repo.Git(nil, strings.NewReader(input), buf, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+//writeLFSObject stores 'data' in the local LFS store and returns its pointer
+func writeLFSObject(t *testing.T, repo *Repository, data []byte) string {
+	oid := fmt.Sprintf("%x", sha256.Sum256(data))
+	p := repo.lfsObjectPath(oid)
+	os.MkdirAll(filepath.Dir(p), 0777)
+	err := os.WriteFile(p, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", lfsVersionLine, oid, len(data))
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	ptr, ok := ParseLFSPointer([]byte(lfsVersionLine + "\noid sha256:" + oid + "\nsize 12345\n"))
+	if !ok || ptr.OID != oid || ptr.Size != 12345 {
+		t.Errorf("expected pointer to be parsed, got: %+v", ptr)
+	}
+
+	for _, data := range []string{
+		"oid sha256:" + oid + "\nsize 1\n",
+		lfsVersionLine + "\noid sha256:abab\nsize 1\n",
+		lfsVersionLine + "\noid sha256:" + oid + "\n",
+	} {
+		if _, ok := ParseLFSPointer([]byte(data)); ok {
+			t.Errorf("expected '%s' not to be a pointer", data)
+		}
+	}
+}
+
+func TestConvertLFSAttributes(t *testing.T) {
+	attrs := "# large files\n*.psd filter=lfs diff=lfs merge=lfs -text\n*.txt text\n"
+	exp := "# large files\n*.psd filter=bits -text\n*.txt text\n"
+	if act := string(ConvertLFSAttributes([]byte(attrs))); act != exp {
+		t.Errorf("expected converted attributes '%s', got '%s'", exp, act)
+	}
+}
+
+func TestImportLFS(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	data := make([]byte, 128*1024)
+	rand.Read(data)
+
+	writeAttributes(t, repo, "*.psd filter=lfs diff=lfs merge=lfs -text")
+	err := os.WriteFile(filepath.Join(repo.rootDir, "a.psd"), []byte(writeLFSObject(t, repo, data)), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+
+	out := bytes.NewBuffer(nil)
+	err = repo.ImportLFS(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != ".gitattributes\na.psd\n" {
+		t.Errorf("unexpected converted files: %s", out.String())
+	}
+
+	if attrs := testGit(t, repo, "show", ":.gitattributes"); attrs != "*.psd filter=bits -text\n" {
+		t.Errorf("unexpected staged attributes: %s", attrs)
+	}
+
+	combined := bytes.NewBuffer(nil)
+	err = repo.Combine(strings.NewReader(testGit(t, repo, "show", ":a.psd")), combined)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(combined.Bytes(), data) {
+		t.Error("staged pointer doesn't combine into the LFS object")
+	}
+
+	wdata, _ := os.ReadFile(filepath.Join(repo.rootDir, "a.psd"))
+	if !bytes.Equal(wdata, data) {
+		t.Error("expected the working tree to receive the content of the LFS object")
+	}
+}
+
+func TestImportLFSHistory(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	writeAttributes(t, repo, "*.psd filter=lfs diff=lfs merge=lfs -text")
+	err := os.WriteFile(filepath.Join(repo.rootDir, "notes.txt"), []byte("not a pointer\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := [][]byte{}
+	for i := 0; i < 2; i++ {
+		data := make([]byte, 64*1024)
+		rand.Read(data)
+		contents = append(contents, data)
+		err := os.WriteFile(filepath.Join(repo.rootDir, "a.psd"), []byte(writeLFSObject(t, repo, data)), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testGit(t, repo, "add", "-A")
+		testGit(t, repo, "commit", "-m", fmt.Sprintf("c%d", i))
+	}
+
+	old := strings.TrimSpace(testGit(t, repo, "rev-parse", "HEAD"))
+	meta := testGit(t, repo, "log", "-1", "--format=%s %an %ae %ad %cn %ce %cd")
+	notes := testGit(t, repo, "rev-parse", "HEAD:notes.txt")
+	out := bytes.NewBuffer(nil)
+	err = repo.ImportLFSHistory(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], old+" ") {
+		t.Fatalf("expected both commits to be rewritten, got: %v", lines)
+	}
+
+	if orig := strings.TrimSpace(testGit(t, repo, "for-each-ref", "--format=%(objectname)", "refs/original/")); orig != old {
+		t.Errorf("expected original ref to be kept, got '%s'", orig)
+	}
+
+	for i, rev := range []string{"HEAD~1", "HEAD"} {
+		if attrs := testGit(t, repo, "show", rev+":.gitattributes"); attrs != "*.psd filter=bits -text\n" {
+			t.Errorf("unexpected attributes in %s: %s", rev, attrs)
+		}
+
+		combined := bytes.NewBuffer(nil)
+		err = repo.Combine(strings.NewReader(testGit(t, repo, "show", rev+":a.psd")), combined)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(combined.Bytes(), contents[i]) {
+			t.Errorf("pointer in %s doesn't combine into the LFS object", rev)
+		}
+	}
+
+	if nmeta := testGit(t, repo, "log", "-1", "--format=%s %an %ae %ad %cn %ce %cd"); nmeta != meta {
+		t.Errorf("expected commit message, author and committer to be kept, got '%s' instead of '%s'", nmeta, meta)
+	}
+
+	if nnotes := testGit(t, repo, "rev-parse", "HEAD:notes.txt"); nnotes != notes {
+		t.Errorf("expected a blob that isn't an LFS pointer to be kept, got %s instead of %s", nnotes, notes)
+	}
+}
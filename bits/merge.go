@@ -0,0 +1,104 @@
+package bits
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//MergeSuffixes are appended to the path of a conflicting file for the
+//working tree copies of our and their version
+var MergeSuffixes = [2]string{".ours", ".theirs"}
+
+//Merge merges the pointers of file 'p' as a git merge driver: 'base' holds
+//the common ancestor, 'ours' our version which is replaced by the result and
+//'theirs' their version. Pointers are only merged if one side didn't change,
+//otherwise our pointer is kept and both versions are combined next to the
+//file for manual resolution. It returns whether the merge is clean
+func (repo *Repository) Merge(base, ours, theirs, p string, w io.Writer) (clean bool, err error) {
+	ptrs := [3]*pointer.Pointer{}
+	datas := [3][]byte{}
+	for i, fpath := range []string{base, ours, theirs} {
+		datas[i], err = os.ReadFile(fpath)
+		if err != nil {
+			return false, fmt.Errorf("failed to read merge input: %v", err)
+		}
+
+		//the common ancestor is empty if the file was added on both sides
+		if i == 0 && len(datas[i]) == 0 {
+			continue
+		}
+
+		ptrs[i], err = pointer.Parse(datas[i])
+		if err != nil {
+			return false, fmt.Errorf("'%s' doesn't hold a valid pointer on %s side: %v", p, []string{"the base", "our", "their"}[i], err)
+		}
+	}
+
+	switch {
+	case ptrs[1].Equal(ptrs[2]), ptrs[0] != nil && ptrs[0].Equal(ptrs[2]):
+		return true, nil
+	case ptrs[0] != nil && ptrs[0].Equal(ptrs[1]):
+		err = os.WriteFile(ours, datas[2], 0666)
+		if err != nil {
+			return false, fmt.Errorf("failed to write merge result: %v", err)
+		}
+
+		return true, nil
+	}
+
+	//both sides changed the content, both versions are combined next to the
+	//file such that they can be compared
+	copies := []string{}
+	for i, suffix := range MergeSuffixes {
+		cpath := p + suffix
+		err = repo.combineTo(p, datas[i+1], filepath.Join(repo.rootDir, cpath))
+		if err != nil {
+			fmt.Fprintf(w, "failed to write '%s': %v\n", cpath, err)
+			continue
+		}
+
+		copies = append(copies, cpath)
+	}
+
+	fmt.Fprintf(w, "CONFLICT (content): both sides changed '%s', it holds our version\n", p)
+	if len(copies) == len(MergeSuffixes) {
+		fmt.Fprintf(w, "our and their version are written to '%s' and '%s', copy the version to keep to '%s' and add it\n", copies[0], copies[1], p)
+	}
+
+	return false, nil
+}
+
+//combineTo writes the content of pointer 'data' of the file at path 'p' to
+//the file at 'dst', chunks are fetched as needed
+func (repo *Repository) combineTo(p string, data []byte, dst string) (err error) {
+	pol, err := repo.Policy(p)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(repo.fetchFrom(pol.Remote, pol.Path, bytes.NewReader(data), pw))
+	}()
+
+	defer pr.Close()
+	err = repo.Combine(pr, f)
+	if err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,79 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeDriver(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	for _, k := range []string{"filter.bits.smudge", "merge.bits.driver"} {
+		testGit(t, repo, "config", k, filterConf[k])
+	}
+
+	writeAttributes(t, repo, "*.bin filter=bits merge=bits")
+	write := func(name string) []byte {
+		data := make([]byte, 16*1024)
+		rand.Read(data)
+		os.WriteFile(filepath.Join(repo.rootDir, name), data, 0666)
+		return data
+	}
+
+	write("a.bin")
+	write("b.bin")
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+
+	//their side changes both files, our side only b.bin
+	testGit(t, repo, "checkout", "-q", "-b", "theirs")
+	theirA, theirB := write("a.bin"), write("b.bin")
+	testGit(t, repo, "commit", "-qam", "theirs")
+	testGit(t, repo, "checkout", "-q", "-")
+	ourB := write("b.bin")
+	testGit(t, repo, "commit", "-qam", "ours")
+
+	err := repo.Git(nil, nil, nil, "merge", "-q", "theirs")
+	if err == nil {
+		t.Fatal("expected the merge to conflict")
+	}
+
+	for name, exp := range map[string][]byte{
+		"a.bin":                    theirA,
+		"b.bin":                    ourB,
+		"b.bin" + MergeSuffixes[0]: ourB,
+		"b.bin" + MergeSuffixes[1]: theirB,
+	} {
+		if data, _ := os.ReadFile(filepath.Join(repo.rootDir, name)); !bytes.Equal(data, exp) {
+			t.Errorf("unexpected content of '%s' after the merge", name)
+		}
+	}
+
+	if staged := testGit(t, repo, "show", ":3:b.bin"); staged != testGit(t, repo, "show", "theirs:b.bin") {
+		t.Error("expected their pointer to be staged as is")
+	}
+}
+
+func TestSplitRejectsInvalidPointers(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	ptr := bytes.NewBuffer(nil)
+	err := repo.Split(bytes.NewReader(make([]byte, 1024)), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = repo.Split(bytes.NewReader(ptr.Bytes()), out)
+	if err != nil || !bytes.Equal(out.Bytes(), ptr.Bytes()) {
+		t.Errorf("expected a valid pointer to be stored as is: %v", err)
+	}
+
+	mixed := ptr.String() + "=======\n" + ptr.String()
+	err = repo.Split(bytes.NewReader([]byte(mixed)), out)
+	if err == nil {
+		t.Error("expected a pointer with conflict markers to be rejected")
+	}
+}
@@ -0,0 +1,124 @@
+package bits
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//ImportHistory rewrites the commits of 'refs' such that blobs that match the
+//'include' patterns, and not the 'exclude' patterns, are replaced by pointers.
+//The root .gitattributes of each rewritten commit tracks each include pattern
+//with the bits filter and drivers, and gets a '-filter' entry for each
+//exclude pattern. Blobs are split with the attributes of the commit they are in
+func (repo *Repository) ImportHistory(include, exclude, refs []string, w io.Writer) (err error) {
+	rw, err := repo.importRewrite(include, exclude)
+	if err != nil {
+		return err
+	}
+
+	return repo.RewriteHistory(refs, rw, w)
+}
+
+//ImportHistoryPlan writes the blobs that ImportHistory would replace by
+//pointers to 'w' without rewriting anything
+func (repo *Repository) ImportHistoryPlan(include, exclude, refs []string, w io.Writer) (err error) {
+	rw, err := repo.importRewrite(include, exclude)
+	if err != nil {
+		return err
+	}
+
+	return repo.PlanHistory(refs, rw, w)
+}
+
+//importRewrite returns the rewrite that imports the blobs that match the
+//'include' patterns, and not the 'exclude' patterns
+func (repo *Repository) importRewrite(include, exclude []string) (rw *Rewrite, err error) {
+	include, exclude = SplitPatterns(include), SplitPatterns(exclude)
+	if len(include) == 0 {
+		return nil, fmt.Errorf("no patterns to include, files to import are selected with --include")
+	}
+
+	filter, err := NewPathFilter(include, exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rewrite{
+		Match: func(p string, size int64) bool {
+			return filter.Match(p)
+		},
+		Blob: func(pol *Policy, r io.Reader, w io.Writer) error {
+			return repo.SplitWith(pol, r, w)
+		},
+		Changes: func(r io.Reader) (bool, error) {
+			return !isPointer(r), nil
+		},
+		Attributes: func(p string, data []byte) ([]byte, error) {
+			if p != ".gitattributes" {
+				return data, nil
+			}
+
+			data, _ = trackAttributes(data, include)
+			return excludeAttributes(data, exclude), nil
+		},
+	}, nil
+}
+
+//ExportHistory rewrites the commits of 'refs' such that every pointer is
+//replaced by the content it points to, chunks that are not stored locally are
+//fetched. The bits filter and attributes are removed from .gitattributes and
+//the .bitsconfig file is removed so the result works without git-bits
+func (repo *Repository) ExportHistory(refs []string, w io.Writer) (err error) {
+	return repo.RewriteHistory(refs, repo.exportRewrite(), w)
+}
+
+//ExportHistoryPlan writes the pointers that ExportHistory would replace by
+//their content to 'w' without rewriting or fetching anything
+func (repo *Repository) ExportHistoryPlan(refs []string, w io.Writer) (err error) {
+	return repo.PlanHistory(refs, repo.exportRewrite(), w)
+}
+
+//exportRewrite returns the rewrite that replaces pointers by their content,
+//the pointers are found with a single pass over the blobs in history such
+//that other blobs are left as they are
+func (repo *Repository) exportRewrite() *Rewrite {
+	return &Rewrite{
+		Match: func(p string, size int64) bool {
+			return size >= pointer.MinSize
+		},
+		Candidates: func(refs []string) (map[string]bool, error) {
+			objs := map[string]bool{}
+			return objs, repo.scanPointers(refs, func(obj, p string, data []byte) error {
+				objs[obj] = true
+				return nil
+			})
+		},
+		Blob: func(pol *Policy, r io.Reader, w io.Writer) error {
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(repo.fetchFrom(pol.Remote, pol.Path, r, pw))
+			}()
+
+			err := repo.Combine(pr, w)
+			pr.Close()
+			return err
+		},
+		Attributes: func(p string, data []byte) ([]byte, error) {
+			data, _ = untrackAttributes(data, nil)
+			return data, nil
+		},
+		Changes: func(r io.Reader) (bool, error) {
+			return isPointer(r), nil
+		},
+		Remove: []string{ConfFile},
+	}
+}
+
+//isPointer returns whether the content on 'r' starts like a pointer
+func isPointer(r io.Reader) bool {
+	hdr := make([]byte, len(pointer.Header))
+	n, _ := io.ReadFull(r, hdr)
+	return pointer.IsPointer(hdr[:n])
+}
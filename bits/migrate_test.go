@@ -0,0 +1,276 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+func TestImportHistory(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	contents := [][]byte{}
+	for i := 0; i < 2; i++ {
+		data := make([]byte, 32*1024)
+		rand.Read(data)
+		contents = append(contents, data)
+		err := os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), data, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(filepath.Join(repo.rootDir, "readme.txt"), []byte(fmt.Sprintf("version %d", i)), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testGit(t, repo, "add", "-A")
+		testGit(t, repo, "commit", "-m", fmt.Sprintf("c%d", i))
+	}
+
+	err := repo.ImportHistory(nil, nil, nil, bytes.NewBuffer(nil))
+	if err == nil {
+		t.Error("expected import without include patterns to fail")
+	}
+
+	head := testGit(t, repo, "rev-parse", "HEAD")
+	plan := bytes.NewBuffer(nil)
+	err = repo.ImportHistoryPlan([]string{"*.bin"}, nil, nil, plan)
+	if err != nil || !strings.HasSuffix(plan.String(), "would rewrite 2 blobs (66 kB) in 2 commits, and the .gitattributes files\n") {
+		t.Errorf("unexpected import plan: %s, %v", plan.String(), err)
+	}
+
+	if testGit(t, repo, "rev-parse", "HEAD") != head {
+		t.Error("expected the import plan to leave history as is")
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = repo.ImportHistory([]string{"*.bin"}, nil, nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 {
+		t.Errorf("expected two rewritten commits, got: %v", lines)
+	}
+
+	for i, rev := range []string{"HEAD~1", "HEAD"} {
+		if attrs := testGit(t, repo, "show", rev+":.gitattributes"); attrs != "*.bin filter=bits diff=bits merge=bits\n" {
+			t.Errorf("unexpected attributes in %s: %s", rev, attrs)
+		}
+
+		if txt := testGit(t, repo, "show", rev+":readme.txt"); txt != fmt.Sprintf("version %d", i) {
+			t.Errorf("expected other files to be kept in %s, got: %s", rev, txt)
+		}
+
+		combined := bytes.NewBuffer(nil)
+		err = repo.Combine(strings.NewReader(testGit(t, repo, "show", rev+":a.bin")), combined)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(combined.Bytes(), contents[i]) {
+			t.Errorf("pointer in %s doesn't combine into the original content", rev)
+		}
+	}
+
+	//importing again doesn't change anything
+	out.Reset()
+	err = repo.ImportHistory([]string{"*.bin"}, nil, nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("expected no commits to be rewritten again, got: %s", out.String())
+	}
+}
+
+func TestImportHistoryTags(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	data := make([]byte, 32*1024)
+	rand.Read(data)
+	err := os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "-c", "i18n.commitEncoding=ISO-8859-1", "commit", "-m", "c0")
+
+	//sign the commit and the tag with signatures that can't be verified
+	sig := "-----BEGIN PGP SIGNATURE-----\n\nfake\n-----END PGP SIGNATURE-----\n"
+	hdr, msg, _ := strings.Cut(testGit(t, repo, "cat-file", "commit", "HEAD"), "\n\n")
+	raw := hdr + "\ngpgsig " + strings.ReplaceAll(strings.TrimSpace(sig), "\n", "\n ") + "\n\n" + msg
+	signed := strings.TrimSpace(testGitInput(t, repo, raw, "hash-object", "-t", "commit", "-w", "--stdin"))
+	testGit(t, repo, "update-ref", "HEAD", signed)
+
+	raw = fmt.Sprintf("object %s\ntype commit\ntag v1\ntagger t <t@t> 0 +0000\n\nrelease\n%s", signed, sig)
+	tag := strings.TrimSpace(testGitInput(t, repo, raw, "hash-object", "-t", "tag", "-w", "--stdin"))
+	testGit(t, repo, "update-ref", "refs/tags/v1", tag)
+	testGit(t, repo, "tag", "-a", "-m", "tree", "tree", "HEAD^{tree}")
+
+	err = repo.ImportHistory([]string{"*.bin"}, nil, []string{"tree"}, bytes.NewBuffer(nil))
+	if err == nil || !strings.Contains(err.Error(), "points to a tree") {
+		t.Errorf("expected a tag of a tree to be rejected, got: %v", err)
+	}
+
+	err = repo.ImportHistory([]string{"*.bin"}, nil, []string{"v1"}, bytes.NewBuffer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if orig := strings.TrimSpace(testGit(t, repo, "rev-parse", "refs/original/refs/tags/v1")); orig != tag {
+		t.Errorf("expected the original tag to be kept, got %s", orig)
+	}
+
+	ntag := testGit(t, repo, "cat-file", "tag", "v1")
+	if !strings.HasSuffix(ntag, "\n\nrelease\n") || !strings.Contains(ntag, "tag v1\ntagger t <t@t> 0 +0000\n") {
+		t.Errorf("expected the tag to be copied without its signature, got: %s", ntag)
+	}
+
+	commit := testGit(t, repo, "cat-file", "commit", "v1^{commit}")
+	if !strings.Contains(commit, "\nencoding ISO-8859-1\n") || strings.Contains(commit, "gpgsig") {
+		t.Errorf("expected the commit to keep its encoding without the signature, got: %s", commit)
+	}
+
+	if !pointer.IsPointer([]byte(testGit(t, repo, "show", "v1:a.bin"))) {
+		t.Error("expected the tag to point to the rewritten commit")
+	}
+}
+
+func TestImportHistoryAttributes(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	for i, attrs := range []string{"*.bin bits-chunk-size=4KiB", "*.bin bits-chunk-size=16KiB"} {
+		writeAttributes(t, repo, attrs)
+		for _, name := range []string{"a.bin", "raw.bin"} {
+			data := make([]byte, 64*1024)
+			rand.Read(data)
+			os.WriteFile(filepath.Join(repo.rootDir, name), data, 0666)
+		}
+
+		testGit(t, repo, "add", "-A")
+		testGit(t, repo, "commit", "-m", fmt.Sprintf("c%d", i))
+	}
+
+	err := repo.ImportHistory([]string{"*.bin"}, []string{"raw.bin"}, nil, bytes.NewBuffer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for rev, exp := range map[string]uint64{"HEAD~1": 4 * 1024, "HEAD": 16 * 1024} {
+		if attrs := testGit(t, repo, "show", rev+":.gitattributes"); !strings.HasSuffix(attrs, " filter=bits diff=bits merge=bits\nraw.bin -filter\n") {
+			t.Errorf("expected an exclude line in the attributes of %s, got: %s", rev, attrs)
+		}
+
+		if raw := testGit(t, repo, "show", rev+":raw.bin"); pointer.IsPointer([]byte(raw)) {
+			t.Errorf("expected the excluded file in %s to be kept", rev)
+		}
+
+		ptr, err := pointer.Decode(strings.NewReader(testGit(t, repo, "show", rev+":a.bin")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ptr.Chunking.AvgSize != exp {
+			t.Errorf("expected %s to be split with the attributes of its commit, got an average chunk size of %d", rev, ptr.Chunking.AvgSize)
+		}
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	remote := newMemRemote()
+	repo := newTestRepository(t, remote)
+	writeAttributes(t, repo, "*.bin filter=bits")
+	data := make([]byte, 32*1024)
+	rand.Read(data)
+	ptr := bytes.NewBuffer(nil)
+	err := repo.Split(bytes.NewReader(data), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), ptr.Bytes(), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(repo.rootDir, ConfFile), []byte("[bits]\n\tchunker = rabin\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(repo.rootDir, "plain.dat"), bytes.Repeat([]byte("plain "), 1024), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+	plain := testGit(t, repo, "rev-parse", "HEAD:plain.dat")
+
+	//move the chunks to the remote so export has to fetch them
+	keys := bytes.NewBuffer(nil)
+	err = repo.Scan("", "HEAD", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := repo.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Push(store, bytes.NewReader(keys.Bytes()), "origin")
+	store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.ForEach(keys, func(k K) error {
+		p, _ := repo.Path(k, false)
+		return os.Remove(p)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := bytes.NewBuffer(nil)
+	err = repo.ExportHistoryPlan(nil, plan)
+	if err != nil || !strings.HasPrefix(plan.String(), "would remove '.bitsconfig'\nwould rewrite 'a.bin' (") || !strings.HasSuffix(plan.String(), "in 1 commits, and the .gitattributes files\n") {
+		t.Errorf("unexpected export plan: %s, %v", plan.String(), err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = repo.ExportHistory(nil, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 1 {
+		t.Errorf("expected a single rewritten commit, got: %v", lines)
+	}
+
+	if act := testGit(t, repo, "show", "HEAD:a.bin"); act != string(data) {
+		t.Error("expected the pointer to be replaced by its content")
+	}
+
+	if nplain := testGit(t, repo, "rev-parse", "HEAD:plain.dat"); nplain != plain {
+		t.Errorf("expected a blob that isn't a pointer to be kept, got %s instead of %s", nplain, plain)
+	}
+
+	if files := testGit(t, repo, "ls-tree", "--name-only", "HEAD"); files != "a.bin\nplain.dat\n" {
+		t.Errorf("expected the emptied .gitattributes and the .bitsconfig to be removed, got: %s", files)
+	}
+
+	wdata, _ := os.ReadFile(filepath.Join(repo.rootDir, "a.bin"))
+	if !bytes.Equal(wdata, data) {
+		t.Error("expected the working tree to hold the content after export")
+	}
+}
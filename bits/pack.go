@@ -0,0 +1,464 @@
+package bits
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	//PackSize determines the number of chunk bytes that are bundled in a
+	//single pack before it is written to the remote
+	PackSize = 32 * 1024 * 1024 //32MiB
+)
+
+//PackLoc describes where the (encrypted) content of a chunk can be
+//found inside a pack that is stored remotely
+type PackLoc struct {
+	Pack   K
+	Offset int64
+	Length int64
+}
+
+//encode the location into the value that is stored in the local index
+func (loc PackLoc) encode() []byte {
+	b := make([]byte, KeySize+16)
+	copy(b, loc.Pack[:])
+	binary.BigEndian.PutUint64(b[KeySize:], uint64(loc.Offset))
+	binary.BigEndian.PutUint64(b[KeySize+8:], uint64(loc.Length))
+	return b
+}
+
+//decodePackLoc reads a pack location from an index value, it returns false
+//if the value doesnt describe a pack location (e.g a single chunk object)
+func decodePackLoc(b []byte) (loc PackLoc, ok bool) {
+	if len(b) != KeySize+16 {
+		return loc, false
+	}
+
+	copy(loc.Pack[:], b[:KeySize])
+	loc.Offset = int64(binary.BigEndian.Uint64(b[KeySize:]))
+	loc.Length = int64(binary.BigEndian.Uint64(b[KeySize+8:]))
+	return loc, true
+}
+
+//pack buffers chunk content until it is large enough to be pushed as a
+//single object, the index records where each chunk ends up
+type pack struct {
+	buf  bytes.Buffer
+	keys []K
+	locs map[K]PackLoc
+}
+
+func newPack() *pack {
+	return &pack{locs: map[K]PackLoc{}}
+}
+
+//add will read the chunk content from 'r' to the end of the pack
+func (p *pack) add(k K, r io.Reader) (n int64, err error) {
+	if _, ok := p.locs[k]; ok {
+		return 0, nil
+	}
+
+	off := int64(p.buf.Len())
+	n, err = io.Copy(&p.buf, r)
+	if err != nil {
+		return n, err
+	}
+
+	p.keys = append(p.keys, k)
+	p.locs[k] = PackLoc{Offset: off, Length: n}
+	return n, nil
+}
+
+//seal determines the pack key from its content and returns the final
+//location of each chunk
+func (p *pack) seal() (pk K) {
+	pk = sha256.Sum256(p.buf.Bytes())
+	for k, loc := range p.locs {
+		loc.Pack = pk
+		p.locs[k] = loc
+	}
+
+	return pk
+}
+
+//writeIndex writes the pack index: one line with key, offset and length
+//for each chunk in the order they were added
+func (p *pack) writeIndex(w io.Writer) (err error) {
+	for _, k := range p.keys {
+		loc := p.locs[k]
+		_, err = fmt.Fprintf(w, "%x %d %d\n", k, loc.Offset, loc.Length)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//ReadPackIndex calls 'fn' for each chunk that is listed in the index of
+//pack 'pk' as read from 'r'
+func ReadPackIndex(pk K, r io.Reader, fn func(K, PackLoc) error) (err error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 3 {
+			return fmt.Errorf("unexpected pack index line: '%s'", s.Text())
+		}
+
+		data, err := hex.DecodeString(fields[0])
+		if err != nil || len(data) != KeySize {
+			return fmt.Errorf("invalid chunk key '%s' in pack index", fields[0])
+		}
+
+		loc := PackLoc{Pack: pk}
+		loc.Offset, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid offset for chunk '%s' in pack index: %v", fields[0], err)
+		}
+
+		loc.Length, err = strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid length for chunk '%s' in pack index: %v", fields[0], err)
+		}
+
+		k := K{}
+		copy(k[:], data)
+		err = fn(k, loc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
+
+//packCache holds the pack indexes of a single remote that are cached
+//locally, they are loaded from disk lazily
+type packCache struct {
+	mu   sync.Mutex
+	locs map[K]PackLoc
+}
+
+//packCache returns the cache of pack indexes for the remote with 'name'
+func (repo *Repository) packCache(name string) *packCache {
+	repo.packCachesMu.Lock()
+	defer repo.packCachesMu.Unlock()
+	if repo.packCaches == nil {
+		repo.packCaches = map[string]*packCache{}
+	}
+
+	pc, ok := repo.packCaches[name]
+	if !ok {
+		pc = &packCache{}
+		repo.packCaches[name] = pc
+	}
+
+	return pc
+}
+
+//pushPack seals the pack and writes it to the remote, the index is written
+//last such that only complete packs are ever listed. On success the local
+//index is updated with the location of each chunk
+func (repo *Repository) pushPack(store *bolt.DB, name string, p *pack) (err error) {
+	if len(p.keys) == 0 {
+		return nil
+	}
+
+	remote, err := repo.chunkRemote(name)
+	if err != nil {
+		return err
+	}
+
+	pk := p.seal()
+	wc, err := remote.PackWriter(pk)
+	if err != nil {
+		return fmt.Errorf("failed to get pack writer: %v", err)
+	}
+
+	n, err := wc.Write(p.buf.Bytes())
+	if err != nil {
+		wc.Close()
+		return fmt.Errorf("failed to write pack '%x' after %d bytes: %v", pk, n, err)
+	}
+
+	err = wc.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write pack '%x': %v", pk, err)
+	}
+
+	idxbuf := bytes.NewBuffer(nil)
+	err = p.writeIndex(idxbuf)
+	if err != nil {
+		return fmt.Errorf("failed to encode pack index: %v", err)
+	}
+
+	err = repo.cachePackIndex(name, pk, idxbuf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	iwc, err := remote.PackIndexWriter(pk)
+	if err != nil {
+		return fmt.Errorf("failed to get pack index writer: %v", err)
+	}
+
+	_, err = iwc.Write(idxbuf.Bytes())
+	if err != nil {
+		iwc.Close()
+		return fmt.Errorf("failed to write index of pack '%x': %v", pk, err)
+	}
+
+	err = iwc.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write index of pack '%x': %v", pk, err)
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(indexBucket(name))
+		if err != nil {
+			return fmt.Errorf("failed to create bucket: %v", err)
+		}
+
+		for k, loc := range p.locs {
+			err := b.Put(k[:], loc.encode())
+			if err != nil {
+				return fmt.Errorf("failed to put '%x': %v", k, err)
+			}
+		}
+
+		//the next push doesn't need to index the pack again
+		pb, err := tx.CreateBucketIfNotExists(packsBucket(name))
+		if err != nil {
+			return fmt.Errorf("failed to create bucket: %v", err)
+		}
+
+		return pb.Put(pk[:], []byte{1})
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to index pushed pack '%x': %v", pk, err)
+	}
+
+	for _, k := range p.keys {
+		repo.keyProgressCh <- KeyOp{PushOp, k, false, p.locs[k].Length, ""}
+	}
+
+	return nil
+}
+
+//packIndexDir returns the directory in which pack indexes of the remote
+//with 'name' are cached, the default remote uses the top level directory
+func (repo *Repository) packIndexDir(name string) string {
+	return filepath.Join(repo.chunkDir, "packs", name)
+}
+
+//packIndexPath returns where the index of pack 'pk' is cached locally
+func (repo *Repository) packIndexPath(name string, pk K) string {
+	return filepath.Join(repo.packIndexDir(name), fmt.Sprintf("%x.idx", pk))
+}
+
+//cachePackIndex stores a pack index locally, since packs are content
+//addressed their index never changes once it is written
+func (repo *Repository) cachePackIndex(name string, pk K, data []byte) (err error) {
+	p := repo.packIndexPath(name, pk)
+	err = os.MkdirAll(filepath.Dir(p), 0777)
+	if err != nil {
+		return fmt.Errorf("failed to create pack index dir: %v", err)
+	}
+
+	tmpf, err := os.CreateTemp(filepath.Dir(p), "idx_tmp_")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary pack index: %v", err)
+	}
+
+	defer os.Remove(tmpf.Name())
+	_, err = tmpf.Write(data)
+	tmpf.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write pack index '%s': %v", p, err)
+	}
+
+	err = os.Rename(tmpf.Name(), p)
+	if err != nil {
+		return fmt.Errorf("failed to move pack index to '%s': %v", p, err)
+	}
+
+	pc := repo.packCache(name)
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.locs != nil {
+		return ReadPackIndex(pk, bytes.NewReader(data), func(k K, loc PackLoc) error {
+			pc.locs[k] = loc
+			return nil
+		})
+	}
+
+	return nil
+}
+
+//SyncPackIndexes asks the default remote for all packs and downloads the
+//index of each pack that is not yet cached locally, 'fn' is called for
+//every chunk in every pack known to the remote
+func (repo *Repository) SyncPackIndexes(fn func(K, PackLoc) error) (err error) {
+	return repo.syncPackIndexes("", fn)
+}
+
+//syncPackIndexes updates the cached pack indexes of the remote with 'name'
+func (repo *Repository) syncPackIndexes(name string, fn func(K, PackLoc) error) (err error) {
+	packs, err := repo.remotePacks(name)
+	if err != nil || fn == nil {
+		return err
+	}
+
+	for _, pk := range packs {
+		data, err := os.ReadFile(repo.packIndexPath(name, pk))
+		if err != nil {
+			return fmt.Errorf("failed to read cached index of pack '%x': %v", pk, err)
+		}
+
+		err = ReadPackIndex(pk, bytes.NewReader(data), fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//remotePacks returns the packs on the remote with 'name' and downloads the
+//index of each pack that is not yet cached locally
+func (repo *Repository) remotePacks(name string) (packs []K, err error) {
+	remote, err := repo.chunkRemote(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list packs: %v", err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = remote.ListPacks(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote packs: %v", err)
+	}
+
+	err = repo.ForEach(buf, func(pk K) error {
+		packs = append(packs, pk)
+		_, err := os.Stat(repo.packIndexPath(name, pk))
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		rc, err := remote.PackIndexReader(pk)
+		if err != nil {
+			return fmt.Errorf("failed to get index reader for pack '%x': %v", pk, err)
+		}
+
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return fmt.Errorf("failed to read index of pack '%x': %v", pk, err)
+		}
+
+		return repo.cachePackIndex(name, pk, data)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return packs, nil
+}
+
+//lookupPack returns the location of chunk 'k' based on the locally cached
+//pack indexes of the remote with 'name', these are loaded from disk only once
+func (repo *Repository) lookupPack(name string, k K) (loc PackLoc, ok bool, err error) {
+	pc := repo.packCache(name)
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.locs == nil {
+		packs := map[K]PackLoc{}
+		dir := repo.packIndexDir(name)
+		fis, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return loc, false, fmt.Errorf("failed to read pack index dir '%s': %v", dir, err)
+		}
+
+		for _, fi := range fis {
+			name := strings.TrimSuffix(fi.Name(), ".idx")
+			data, derr := hex.DecodeString(name)
+			if fi.IsDir() || derr != nil || len(data) != KeySize {
+				continue
+			}
+
+			pk := K{}
+			copy(pk[:], data)
+			f, err := os.Open(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				return loc, false, fmt.Errorf("failed to open pack index: %v", err)
+			}
+
+			err = ReadPackIndex(pk, f, func(k K, loc PackLoc) error {
+				packs[k] = loc
+				return nil
+			})
+
+			f.Close()
+			if err != nil {
+				return loc, false, fmt.Errorf("failed to read index of pack '%x': %v", pk, err)
+			}
+		}
+
+		pc.locs = packs
+	}
+
+	loc, ok = pc.locs[k]
+	return loc, ok, nil
+}
+
+//remoteChunkReader opens the content of chunk 'k' on the remote, it uses
+//the pack indexes to read it from a pack and falls back to the single chunk
+//object used by older versions. Pack indexes are refreshed when the chunk
+//cannot be found at all.
+func (repo *Repository) remoteChunkReader(name string, k K) (rc io.ReadCloser, err error) {
+	remote, err := repo.chunkRemote(name)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, ok, err := repo.lookupPack(name, k)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		return remote.PackReader(loc.Pack, loc.Offset, loc.Length)
+	}
+
+	rc, err = remote.ChunkReader(k)
+	if err == nil {
+		return rc, nil
+	}
+
+	serr := repo.syncPackIndexes(name, nil)
+	if serr != nil {
+		return nil, fmt.Errorf("%v, and failed to update pack indexes: %v", err, serr)
+	}
+
+	loc, ok, lerr := repo.lookupPack(name, k)
+	if lerr != nil || !ok {
+		return nil, err
+	}
+
+	return remote.PackReader(loc.Pack, loc.Offset, loc.Length)
+}
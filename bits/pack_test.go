@@ -0,0 +1,333 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//memRemote keeps chunks and packs in memory
+type memRemote struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemRemote() *memRemote {
+	return &memRemote{objects: map[string][]byte{}}
+}
+
+type memWriter struct {
+	bytes.Buffer
+	remote *memRemote
+	name   string
+}
+
+func (w *memWriter) Close() error {
+	w.remote.Lock()
+	defer w.remote.Unlock()
+	w.remote.objects[w.name] = w.Bytes()
+	return nil
+}
+
+func (r *memRemote) reader(name string) (io.ReadCloser, error) {
+	r.Lock()
+	defer r.Unlock()
+	data, ok := r.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("object '%s' doesnt exist", name)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (r *memRemote) list(w io.Writer, prefix, suffix string) error {
+	r.Lock()
+	defer r.Unlock()
+	for name := range r.objects {
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			fmt.Fprintf(w, "%s\n", strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix))
+		}
+	}
+
+	return nil
+}
+
+func (r *memRemote) ChunkReader(k K) (io.ReadCloser, error) {
+	return r.reader(fmt.Sprintf("chunk/%x", k))
+}
+
+func (r *memRemote) ChunkWriter(k K) (io.WriteCloser, error) {
+	return &memWriter{remote: r, name: fmt.Sprintf("chunk/%x", k)}, nil
+}
+
+func (r *memRemote) ListChunks(w io.Writer) error {
+	return r.list(w, "chunk/", "")
+}
+
+func (r *memRemote) PackReader(p K, off, n int64) (io.ReadCloser, error) {
+	rc, err := r.reader(fmt.Sprintf("pack/%x.pack", p))
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := io.ReadAll(rc)
+	return io.NopCloser(bytes.NewReader(data[off : off+n])), nil
+}
+
+func (r *memRemote) PackWriter(p K) (io.WriteCloser, error) {
+	return &memWriter{remote: r, name: fmt.Sprintf("pack/%x.pack", p)}, nil
+}
+
+func (r *memRemote) PackIndexReader(p K) (io.ReadCloser, error) {
+	return r.reader(fmt.Sprintf("pack/%x.idx", p))
+}
+
+func (r *memRemote) PackIndexWriter(p K) (io.WriteCloser, error) {
+	return &memWriter{remote: r, name: fmt.Sprintf("pack/%x.idx", p)}, nil
+}
+
+func (r *memRemote) ListPacks(w io.Writer) error {
+	return r.list(w, "pack/", ".idx")
+}
+
+func (r *memRemote) ProbeReader(name string) (io.ReadCloser, error) {
+	return r.reader("probe/" + name)
+}
+
+func (r *memRemote) ProbeWriter(name string) (io.WriteCloser, error) {
+	return &memWriter{remote: r, name: "probe/" + name}, nil
+}
+
+func (r *memRemote) ListProbes(w io.Writer) error {
+	return r.list(w, "probe/", "")
+}
+
+func (r *memRemote) DeleteProbe(name string) error {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.objects, "probe/"+name)
+	return nil
+}
+
+func (r *memRemote) count(prefix, suffix string) int {
+	buf := bytes.NewBuffer(nil)
+	r.list(buf, prefix, suffix)
+	return strings.Count(buf.String(), "\n")
+}
+
+//newTestRepository creates a repository in a temporary directory that uses
+//the given remote for storing chunks
+func newTestRepository(t *testing.T, remote Remote) *Repository {
+	tmpDir, err := os.MkdirTemp("", "git-bits-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(tmpDir) })
+	if err := initGitRepo(tmpDir); err != nil {
+		t.Skip("Git not available")
+	}
+
+	repo, err := NewRepository(tmpDir, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.remote = remote
+	return repo
+}
+
+func TestPackLocEncoding(t *testing.T) {
+	loc := PackLoc{Pack: K{0x01, 0x02}, Offset: 1 << 40, Length: 12345}
+	dec, ok := decodePackLoc(loc.encode())
+	if !ok {
+		t.Fatal("encoded pack location should decode")
+	}
+
+	if dec != loc {
+		t.Errorf("expected %+v, got %+v", loc, dec)
+	}
+
+	if _, ok := decodePackLoc(RemoteChunk); ok {
+		t.Error("a remote chunk marker should not decode as a pack location")
+	}
+}
+
+func TestPushFetchPacks(t *testing.T) {
+	defer func(size int) { PackSize = size }(PackSize)
+	PackSize = 1024 * 1024
+
+	remote := newMemRemote()
+	repo1 := newTestRepository(t, remote)
+	data := make([]byte, 6*1024*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	ptr := bytes.NewBuffer(nil)
+	err := repo1.Split(bytes.NewReader(data), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store1, err := repo1.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store1.Close()
+	err = repo1.Push(store1, bytes.NewReader(ptr.Bytes()), "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := remote.count("chunk/", ""); n != 0 {
+		t.Errorf("expected no single chunk objects, got %d", n)
+	}
+
+	packs := remote.count("pack/", ".idx")
+	if packs < 2 {
+		t.Errorf("expected chunks to be spread over multiple packs, got %d", packs)
+	}
+
+	//pushing again shouldnt create any new packs
+	err = repo1.Push(store1, bytes.NewReader(ptr.Bytes()), "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := remote.count("pack/", ".idx"); n != packs {
+		t.Errorf("expected no new packs to be pushed, got %d instead of %d", n, packs)
+	}
+
+	//a chunk stored the legacy way should still be fetched
+	legacy := []byte("legacy chunk content")
+	lptr := bytes.NewBuffer(nil)
+	err = repo1.Split(bytes.NewReader(legacy), lptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo1.ForEach(bytes.NewReader(lptr.Bytes()), func(k K) error {
+		p, _ := repo1.Path(k, false)
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+		wc, _ := remote.ChunkWriter(k)
+		io.Copy(wc, f)
+		return wc.Close()
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo2 := newTestRepository(t, remote)
+	for _, c := range []struct {
+		ptr  []byte
+		data []byte
+	}{{ptr.Bytes(), data}, {lptr.Bytes(), legacy}} {
+		keys := bytes.NewBuffer(nil)
+		err = repo2.Fetch(bytes.NewReader(c.ptr), keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := bytes.NewBuffer(nil)
+		err = repo2.Combine(keys, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.Bytes(), c.data) {
+			t.Errorf("fetched content differs from original, got %d bytes expected %d", out.Len(), len(c.data))
+		}
+	}
+}
+
+func TestIndexRemotePacks(t *testing.T) {
+	defer func(size int) { PackSize = size }(PackSize)
+	PackSize = 1024 * 1024
+
+	remote := newMemRemote()
+	repo1 := newTestRepository(t, remote)
+	data := make([]byte, 3*1024*1024)
+	rand.Read(data)
+	ptr := bytes.NewBuffer(nil)
+	err := repo1.Split(bytes.NewReader(data), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store1, err := repo1.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store1.Close()
+	err = repo1.Push(store1, bytes.NewReader(ptr.Bytes()), "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo2 := newTestRepository(t, remote)
+	store2, err := repo2.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store2.Close()
+	err = repo2.indexRemote(store2, "", remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []K{}
+	repo2.ForEach(bytes.NewReader(ptr.Bytes()), func(k K) error {
+		keys = append(keys, k)
+		return nil
+	})
+
+	indexed := func(k K) (ok bool) {
+		store2.View(func(tx *bolt.Tx) error {
+			ok = tx.Bucket(IndexBucket).Get(k[:]) != nil
+			return nil
+		})
+
+		return ok
+	}
+
+	for _, k := range keys {
+		if !indexed(k) {
+			t.Fatalf("expected chunk '%x' of a pack to be indexed", k)
+		}
+	}
+
+	//packs that were indexed before are not read again
+	err = store2.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(IndexBucket).Delete(keys[0][:])
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo2.indexRemote(store2, "", remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if indexed(keys[0]) {
+		t.Errorf("expected a pack that was indexed before to be skipped")
+	}
+}
@@ -0,0 +1,133 @@
+package bits
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//PathFilter selects paths with gitattributes style patterns: patterns without
+//a slash match the name of a file or any of its directories, other patterns
+//match from the root. A '*' doesnt match a slash, '**' does
+type PathFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+//NewPathFilter returns a filter that matches paths that match any of the
+//'include' patterns (or all paths if there are none) and none of the
+//'exclude' patterns. Patterns can also be given as a comma seperated list
+func NewPathFilter(include, exclude []string) (f *PathFilter, err error) {
+	f = &PathFilter{}
+	f.include, err = compilePatterns(include)
+	if err != nil {
+		return nil, err
+	}
+
+	f.exclude, err = compilePatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+//Match returns whether path 'p' is selected by the filter
+func (f *PathFilter) Match(p string) bool {
+	if f == nil {
+		return true
+	}
+
+	included := len(f.include) == 0
+	for _, exp := range f.include {
+		if exp.MatchString(p) {
+			included = true
+			break
+		}
+	}
+
+	if !included {
+		return false
+	}
+
+	for _, exp := range f.exclude {
+		if exp.MatchString(p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//SplitPatterns splits comma seperated patterns and drops empty ones
+func SplitPatterns(patterns []string) (all []string) {
+	for _, p := range patterns {
+		for _, pp := range strings.Split(p, ",") {
+			if pp = strings.TrimSpace(pp); pp != "" {
+				all = append(all, pp)
+			}
+		}
+	}
+
+	return all
+}
+
+//compilePatterns turns patterns into regular expressions
+func compilePatterns(patterns []string) (exps []*regexp.Regexp, err error) {
+	for _, p := range SplitPatterns(patterns) {
+		exp, err := compilePattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %v", p, err)
+		}
+
+		exps = append(exps, exp)
+	}
+
+	return exps, nil
+}
+
+//compilePattern turns a single pattern into a regular expression
+func compilePattern(p string) (*regexp.Regexp, error) {
+	p = strings.TrimSuffix(p, "/")
+	anchored := strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+
+	exp := strings.Builder{}
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case strings.HasPrefix(p[i:], "**/"):
+			exp.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**"):
+			exp.WriteString(".*")
+			i++
+		case c == '*':
+			exp.WriteString("[^/]*")
+		case c == '?':
+			exp.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(p[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+
+			class := p[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			exp.WriteString("[" + class + "]")
+			i += j
+		default:
+			exp.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	//a match on a directory also matches everything inside of it
+	prefix := "^(.*/)?"
+	if anchored {
+		prefix = "^"
+	}
+
+	return regexp.Compile(prefix + exp.String() + "(/.*)?$")
+}
@@ -0,0 +1,40 @@
+package bits
+
+import (
+	"testing"
+)
+
+func TestPathFilter(t *testing.T) {
+	f, err := NewPathFilter([]string{"*.bin,/assets/**/*.psd", "media"}, []string{"*.tmp.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, exp := range map[string]bool{
+		"a.bin":               true,
+		"dir/sub/a.bin":       true,
+		"a.tmp.bin":           false,
+		"a.binx":              false,
+		"assets/cover.psd":    true,
+		"assets/x/y/cover.ps": false,
+		"assets/x/y/big.psd":  true,
+		"other/assets/a.psd":  false,
+		"media/clip.mp4":      true,
+		"docs/media/clip.mp4": true,
+		"multimedia/clip.mp4": false,
+	} {
+		if act := f.Match(p); act != exp {
+			t.Errorf("expected match of '%s' to be %v, got %v", p, exp, act)
+		}
+	}
+
+	all, _ := NewPathFilter(nil, nil)
+	if !all.Match("anything/at/all") {
+		t.Error("expected a filter without patterns to match all paths")
+	}
+
+	_, err = NewPathFilter([]string{"[abc"}, nil)
+	if err == nil {
+		t.Error("expected an unterminated character class to be rejected")
+	}
+}
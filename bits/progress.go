@@ -0,0 +1,197 @@
+package bits
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/term"
+)
+
+const (
+	//progressBarWidth is the number of characters between the brackets of
+	//the progress bar
+	progressBarWidth = 24
+
+	//ProgressRedraw is how often the progress bar is redrawn on a terminal
+	ProgressRedraw = 100 * time.Millisecond
+
+	//ProgressInterval is how often a summary line is written when the output
+	//is not a terminal
+	ProgressInterval = 5 * time.Second
+)
+
+//opProgress counts the chunks that an operation handled
+type opProgress struct {
+	chunks  int
+	skipped int
+	bytes   int64
+
+	//what the operation is expected to handle, zero if unknown
+	totalChunks int
+	totalBytes  int64
+
+	//moving average of the throughput in bytes per second
+	tp float64
+}
+
+//Progress renders the progress of chunk operations. On a terminal a single
+//line with a bar is redrawn, otherwise a summary line is written
+//periodically. Its KeyProgress and AddTotal methods are the KeyProgressFn
+//and KeyTotalFn of a repository
+type Progress struct {
+	mu       sync.Mutex
+	w        io.Writer
+	tty      bool
+	interval time.Duration
+
+	ops   map[Op]*opProgress
+	cur   Op
+	last  time.Time
+	dirty bool
+	drawn bool
+}
+
+//NewProgress returns a progress renderer that writes to 'w', a bar is drawn
+//if 'w' is a terminal
+func NewProgress(w io.Writer) *Progress {
+	p := &Progress{w: w, interval: ProgressInterval, ops: map[Op]*opProgress{}}
+	if f, ok := w.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		p.tty, p.interval = true, ProgressRedraw
+	}
+
+	return p
+}
+
+//op returns the counts of operation 'op'
+func (p *Progress) op(op Op) *opProgress {
+	o, ok := p.ops[op]
+	if !ok {
+		o = &opProgress{}
+		p.ops[op] = o
+	}
+
+	return o
+}
+
+//AddTotal adds 'chunks' chunks with 'size' bytes to what operation 'op' is
+//expected to handle, a negative size is unknown
+func (p *Progress) AddTotal(op Op, chunks int, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o := p.op(op)
+	o.totalChunks += chunks
+	if size > 0 {
+		o.totalBytes += size
+	}
+}
+
+//KeyProgress counts chunk operation 'kop' with throughput 'tp' and redraws
+//the progress if it is due. Combined chunks are not shown, they are read
+//from local storage
+func (p *Progress) KeyProgress(kop KeyOp, tp float64) {
+	if kop.Op == CombineOp {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if kop.Op != p.cur {
+		p.finish()
+		p.cur = kop.Op
+		if !p.tty {
+			p.last = time.Now()
+		}
+	}
+
+	o := p.op(kop.Op)
+	o.chunks++
+	o.bytes += kop.CopyN
+	o.tp = tp
+	if kop.Skipped {
+		o.skipped++
+	}
+
+	p.dirty = true
+	if time.Since(p.last) >= p.interval || o.chunks == o.totalChunks {
+		p.draw()
+	}
+}
+
+//Finish writes the final progress of the current operation
+func (p *Progress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finish()
+	p.cur = ""
+}
+
+//finish writes the final progress of the current operation and ends its line
+func (p *Progress) finish() {
+	if p.dirty {
+		p.draw()
+	}
+
+	if p.drawn && p.tty {
+		fmt.Fprintf(p.w, "\n")
+	}
+
+	p.drawn = false
+}
+
+//draw writes the progress of the current operation
+func (p *Progress) draw() {
+	if p.cur == "" {
+		return
+	}
+
+	line := p.ops[p.cur].line(p.cur, p.tty)
+	if p.tty {
+		fmt.Fprintf(p.w, "\r%s\x1b[K", line)
+	} else {
+		fmt.Fprintf(p.w, "%s\n", line)
+	}
+
+	p.last, p.dirty, p.drawn = time.Now(), false, true
+}
+
+//line describes the progress of operation 'op', with a bar if 'bar' is set
+//and the total number of chunks is known
+func (o *opProgress) line(op Op, bar bool) string {
+	buf := strings.Builder{}
+	fmt.Fprintf(&buf, "%s", op)
+	done := min(o.chunks, o.totalChunks)
+	if o.totalChunks > 0 {
+		frac := float64(done) / float64(o.totalChunks)
+		if bar {
+			n := int(frac * progressBarWidth)
+			fmt.Fprintf(&buf, " [%s%s]", strings.Repeat("=", n), strings.Repeat(" ", progressBarWidth-n))
+		}
+
+		fmt.Fprintf(&buf, " %3.0f%% %d/%d chunks", frac*100, o.chunks, o.totalChunks)
+	} else {
+		fmt.Fprintf(&buf, " %d chunks", o.chunks)
+	}
+
+	if op == IndexOp {
+		return buf.String()
+	}
+
+	fmt.Fprintf(&buf, ", %d skipped, %s", o.skipped, humanize.Bytes(uint64(o.bytes)))
+	if o.tp > 0 {
+		fmt.Fprintf(&buf, ", %s/s", humanize.Bytes(uint64(o.tp)))
+	}
+
+	//the remaining bytes are estimated from the remaining chunks
+	if o.totalChunks > done && o.totalBytes > 0 && o.tp > 0 {
+		remaining := float64(o.totalChunks-done) / float64(o.totalChunks) * float64(o.totalBytes)
+		eta := time.Duration(remaining / o.tp * float64(time.Second))
+		fmt.Fprintf(&buf, ", ETA %s", eta.Round(time.Second))
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,29 @@
+package bits
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	p := NewProgress(out)
+	p.KeyProgress(KeyOp{Op: IndexOp}, 0)
+	p.AddTotal(PushOp, 4, 4000)
+	for i := 0; i < 4; i++ {
+		p.KeyProgress(KeyOp{Op: PushOp, Skipped: i == 0, CopyN: 1000 * int64(min(i, 1))}, 1000)
+		p.KeyProgress(KeyOp{Op: CombineOp, CopyN: 1000}, 0)
+	}
+
+	p.Finish()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || lines[0] != "index 1 chunks" || lines[1] != "push 100% 4/4 chunks, 1 skipped, 3.0 kB, 1.0 kB/s" {
+		t.Errorf("unexpected progress output: %q", out.String())
+	}
+
+	o := &opProgress{chunks: 2, skipped: 1, bytes: 1000, totalChunks: 4, totalBytes: 4000, tp: 1000}
+	if line := o.line(FetchOp, true); line != "fetch [============            ]  50% 2/4 chunks, 1 skipped, 1.0 kB, 1.0 kB/s, ETA 2s" {
+		t.Errorf("unexpected progress line: %q", line)
+	}
+}
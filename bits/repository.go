@@ -4,16 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,8 +19,7 @@ import (
 
 	"github.com/VividCortex/ewma"
 	bolt "go.etcd.io/bbolt"
-	"github.com/dustin/go-humanize"
-	"github.com/restic/chunker"
+	"github.com/nerdalize/git-bits/pointer"
 )
 
 //RemoteChunk indicates a certain chunk is know but stored remotely
@@ -33,7 +30,7 @@ var (
 )
 
 var (
-	//ChunkBufferSize determines the size of the buffer that wil hold each chunk
+	//ChunkBufferSize determines the default maximum size of each chunk
 	ChunkBufferSize = 8 * 1024 * 1024 //8MiB
 
 	//RemoteBranchSuffix identifies the specialty branches used for persisting remote information
@@ -43,8 +40,31 @@ var (
 var (
 	//IndexBucket holds remotely whether chunks are stored remotely
 	IndexBucket = []byte("index")
+
+	//PacksBucket holds the packs whose chunks are in the IndexBucket
+	PacksBucket = []byte("packs")
 )
 
+//indexBucket returns the bucket that indexes chunks of the remote with
+//'name', the default remote has no name and uses the IndexBucket
+func indexBucket(name string) []byte {
+	if name == "" {
+		return IndexBucket
+	}
+
+	return []byte(string(IndexBucket) + "." + name)
+}
+
+//packsBucket returns the bucket that records which packs of the remote with
+//'name' are in its index bucket
+func packsBucket(name string) []byte {
+	if name == "" {
+		return PacksBucket
+	}
+
+	return []byte(string(PacksBucket) + "." + name)
+}
+
 //Repository provides an abstraction on top of a Git repository for a
 //certain directory that is queried by git commands
 type Repository struct {
@@ -63,24 +83,37 @@ type Repository struct {
 	//stderr from executions will be written here
 	output io.Writer
 
-	//Header key allows us to recognize the start of a key listing
-	header []byte
-
-	//Footer Key allows us to recognize the end of a key listing
-	footer []byte
-
 	//remotes hold the remote chunk store we're using
 	remote Remote
 
+	//named remotes that paths can select through the 'bits-remote' attribute
+	remotes   map[string]Remote
+	remotesMu sync.Mutex
+
 	//bits specific configuration
 	conf *Conf
 
+	//chunk locations from pack indexes that are cached locally, per remote
+	packCaches   map[string]*packCache
+	packCachesMu sync.Mutex
+
 	//this channel receives any chunk Key that is hanled in an any operation
 	keyProgressCh chan KeyOp
 
+	//closed once every key operation is handed to KeyProgressFn
+	keyProgressDone chan struct{}
+	closeOnce       sync.Once
+
 	//is called when a chunk was handled in any operation, can be called
 	//concurrently
 	KeyProgressFn func(KeyOp, float64)
+
+	//is called when an operation learns how many chunks and bytes, negative
+	//if unknown, it is going to handle. Can be nil
+	KeyTotalFn func(op Op, chunks int, size int64)
+
+	//renders the progress with the default KeyProgressFn
+	progress *Progress
 }
 
 //NewRepository sets up an interface on top of a Git repository in the
@@ -123,13 +156,6 @@ func NewRepository(dir string, output io.Writer) (repo *Repository, err error) {
 		return nil, fmt.Errorf("couldnt setup chunk directory at '%s': %v", repo.chunkDir, err)
 	}
 
-	//setup header and footers
-	repo.header = []byte("--- to use this file decode it with the 'git-bits' extension ---\n")
-	repo.footer = []byte("----------------------- end of chunks --------------------------\n")
-	if len(repo.header) != (hex.EncodedLen(KeySize)+1) || len(repo.footer) != (hex.EncodedLen(KeySize)+1) {
-		return nil, fmt.Errorf("repository header and footer size are not '%d': header: %d, footer: %d", hex.EncodedLen(KeySize)+1, len(repo.header), len(repo.footer))
-	}
-
 	//setup configuration
 	repo.conf = DefaultConf()
 	err = repo.conf.OverwriteFromGit(repo)
@@ -150,35 +176,18 @@ func NewRepository(dir string, output io.Writer) (repo *Repository, err error) {
 		}
 	}
 
-	//default output function will do basic logging of key progress
-	indexBucketMax := 500
-	indexedTotalKeys := 0
-	repo.KeyProgressFn = func(kop KeyOp, tp float64) {
-		if kop.Op == IndexOp {
-			indexedTotalKeys++
-			if indexedTotalKeys%indexBucketMax == 0 {
-				fmt.Fprintf(repo.output, "indexed %d remote chunks, total: ~%s\n", indexBucketMax, humanize.FormatInteger("#.", indexedTotalKeys))
-			}
-
-			return
-		}
-
-		if kop.Op != IndexOp && indexedTotalKeys > 0 {
-			fmt.Fprintf(repo.output, "indexing of remote chunks ended, total: ~%s\n", humanize.FormatInteger("#.", indexedTotalKeys))
-			indexedTotalKeys = 0
-		}
-
-		if kop.Skipped {
-			fmt.Fprintf(repo.output, "%x (skip: already %s)\n", kop.K, strings.Replace(fmt.Sprintf("%sed", string(kop.Op)), "ee", "e", 1))
-		} else {
-			fmt.Fprintf(repo.output, "%x (%s) %s/s\n", kop.K, string(kop.Op), humanize.Bytes(uint64(tp)))
-		}
-	}
+	//by default the progress is rendered as a bar on a terminal or with
+	//periodic summary lines otherwise
+	repo.progress = NewProgress(repo.output)
+	repo.KeyProgressFn = repo.progress.KeyProgress
+	repo.KeyTotalFn = repo.progress.AddTotal
 
 	//we start handling key events while keeping a moving
 	//average for the number of bytes moving through
 	repo.keyProgressCh = make(chan KeyOp, 1)
+	repo.keyProgressDone = make(chan struct{})
 	go func() {
+		defer close(repo.keyProgressDone)
 		lastT := time.Now()
 		e := ewma.NewMovingAverage()
 		for kop := range repo.keyProgressCh {
@@ -197,14 +206,40 @@ func NewRepository(dir string, output io.Writer) (repo *Repository, err error) {
 	return repo, nil
 }
 
+//Close waits until every chunk operation is handed to KeyProgressFn and
+//writes the final progress, the repository can't handle chunks after it is
+//closed
+func (repo *Repository) Close() {
+	repo.closeOnce.Do(func() { close(repo.keyProgressCh) })
+	<-repo.keyProgressDone
+	repo.progress.Finish()
+}
+
+//addTotal hands what operation 'op' is going to handle to KeyTotalFn
+func (repo *Repository) addTotal(op Op, chunks int, size int64) {
+	if repo.KeyTotalFn != nil {
+		repo.KeyTotalFn(op, chunks, size)
+	}
+}
+
 //Git runs the git executable with the working directory set to the repository director
 func (repo *Repository) Git(ctx context.Context, in io.Reader, out io.Writer, args ...string) (err error) {
+	return repo.GitEnv(ctx, nil, in, out, args...)
+}
+
+//GitEnv runs the git executable like Git but adds 'env' to the environment
+//of the process, e.g. to select another index file or commit identity
+func (repo *Repository) GitEnv(ctx context.Context, env []string, in io.Reader, out io.Writer, args ...string) (err error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
 	cmd := exec.CommandContext(ctx, repo.exe, args...)
 	cmd.Dir = repo.rootDir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
 	cmd.Stderr = repo.output
 	cmd.Stdin = in
 	cmd.Stdout = out
@@ -217,18 +252,88 @@ func (repo *Repository) Git(ctx context.Context, in io.Reader, out io.Writer, ar
 	return nil
 }
 
+//namedRemotes returns the sorted names of the remotes that are configured
+//besides the default remote
+func (repo *Repository) namedRemotes() (names []string) {
+	repo.remotesMu.Lock()
+	defer repo.remotesMu.Unlock()
+	for name := range repo.remotes {
+		names = append(names, name)
+	}
+
+	for name := range repo.conf.Remotes {
+		if _, ok := repo.remotes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+//Bucket returns the bucket configured for the remote with 'name', the
+//default remote has no name
+func (repo *Repository) Bucket(name string) string {
+	if name == "" {
+		return repo.conf.AWSS3BucketName
+	}
+
+	return repo.conf.Remotes[name]
+}
+
+//chunkRemote returns the remote with the given name, the default remote
+//has no name. Named remotes are configured with a bucket through the
+//'bits.<name>.aws-s3-bucket-name' git configuration
+func (repo *Repository) chunkRemote(name string) (remote Remote, err error) {
+	if name == "" {
+		if repo.remote == nil {
+			return nil, fmt.Errorf("no remote configured")
+		}
+
+		return repo.remote, nil
+	}
+
+	repo.remotesMu.Lock()
+	defer repo.remotesMu.Unlock()
+	if remote, ok := repo.remotes[name]; ok {
+		return remote, nil
+	}
+
+	bucket, ok := repo.conf.Remotes[name]
+	if !ok {
+		return nil, fmt.Errorf("no bucket configured for remote '%s', set 'bits.%s.aws-s3-bucket-name'", name, name)
+	}
+
+	remote, err = NewS3Remote(repo, name, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to setup chunk remote '%s': %v", name, err)
+	}
+
+	if repo.remotes == nil {
+		repo.remotes = map[string]Remote{}
+	}
+
+	repo.remotes[name] = remote
+	return remote, nil
+}
+
 //Install will prepare a git repository for usage with git bits, it configures
 //filters, installs hooks and pulls chunks to write files in the current
 //working tree. A configuration struct can be provided to populate local
 //git configuration got future bits commands
 func (repo *Repository) Install(w io.Writer, conf *Conf) (err error) {
+	return repo.InstallWith(w, conf, true)
+}
+
+//InstallWith prepares the repository like Install, chunks are only pulled
+//if 'pull' is set
+func (repo *Repository) InstallWith(w io.Writer, conf *Conf, pull bool) (err error) {
 	ctx := context.Background()
 
 	//configure filter
-	gconf := map[string]string{
-		"filter.bits.clean":    "git bits split",
-		"filter.bits.smudge":   "git bits fetch | git bits combine",
-		"filter.bits.required": "true",
+	gconf := map[string]string{}
+	for k, v := range filterConf {
+		gconf[k] = v
 	}
 
 	//add bits configuration
@@ -237,22 +342,48 @@ func (repo *Repository) Install(w io.Writer, conf *Conf) (err error) {
 			gconf["bits.aws-s3-bucket-name"] = conf.AWSS3BucketName
 		}
 
-		if conf.DeduplicationScope != 0 {
-			gconf["bits.deduplication-scope"] = strconv.FormatUint(conf.DeduplicationScope, 10)
+		for name, bucket := range conf.Remotes {
+			gconf["bits."+name+".aws-s3-bucket-name"] = bucket
+		}
+
+		if conf.S3Endpoint != "" {
+			gconf["bits.s3-endpoint"] = conf.S3Endpoint
+		}
+
+		//chunking parameters are recorded in the repository such that every
+		//clone chunks identically, only the first install writes them
+		_, err = os.Stat(filepath.Join(repo.rootDir, ConfFile))
+		if os.IsNotExist(err) {
+			err = conf.ValidateChunking()
+			if err != nil {
+				return fmt.Errorf("invalid chunking configuration: %v", err)
+			}
+
+			for k, val := range conf.chunkingValues() {
+				err = repo.Git(ctx, nil, nil, "config", "--file", ConfFile, k, val)
+				if err != nil {
+					return fmt.Errorf("failed to record chunking configuration: %v", err)
+				}
+			}
+
+			fmt.Fprintf(repo.output, "recorded chunking configuration in '%s', commit it so every clone chunks identically\n", ConfFile)
 		}
 
 		repo.conf = conf
+		repo.remote, repo.remotes = nil, nil
 
 		//@TODO init can complete remote configuration
 		//@TODO obvious code duplication with constructor
-		repo.remote, err = NewS3Remote(
-			repo,
-			"origin",
-			repo.conf.AWSS3BucketName,
-		)
+		if repo.conf.AWSS3BucketName != "" {
+			repo.remote, err = NewS3Remote(
+				repo,
+				"origin",
+				repo.conf.AWSS3BucketName,
+			)
 
-		if err != nil {
-			return fmt.Errorf("unable to setup default chunk remote: %v", err)
+			if err != nil {
+				return fmt.Errorf("unable to setup default chunk remote: %v", err)
+			}
 		}
 	}
 
@@ -264,25 +395,21 @@ func (repo *Repository) Install(w io.Writer, conf *Conf) (err error) {
 		}
 	}
 
-	//write hook if doesnt exist yet
-	hookp := filepath.Join(repo.gitDir, "hooks", "pre-push")
-	f, err := os.OpenFile(hookp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0777)
+	//values recorded in the repository take precedence over the defaults
+	err = repo.conf.OverwriteFromGit(repo)
 	if err != nil {
-		if os.IsExist(err) {
-			fmt.Fprintf(repo.output, "a file already exists at '%s' already, skip writing git-bits hook\n", hookp)
-		} else {
-			return fmt.Errorf("couldnt setup hook: %v", err)
-		}
-	} else {
-		defer f.Close()
-		_, err = f.WriteString(`#!/bin/sh
-			command -v git-bits >/dev/null 2>&1 || { echo >&2 "This project was setup with git-bits but it can (no longer) be found in your PATH: $PATH."; exit 0; }
-			git-bits scan | git-bits push
-	`)
+		return fmt.Errorf("failed to load bits configuration from git: %v", err)
+	}
 
-		if err != nil {
-			return fmt.Errorf("failed to git hook: %v", err)
-		}
+	//add git-bits to the pre-push hook, other hooks are kept
+	err = repo.installHook("pre-push", w)
+	if err != nil {
+		return fmt.Errorf("failed to install hook: %v", err)
+	}
+
+	//a repository without commits has nothing to pull
+	if !pull || repo.gitOutput(ctx, "rev-parse", "-q", "--verify", "HEAD") == "" {
+		return nil
 	}
 
 	err = repo.Pull("HEAD", w)
@@ -293,23 +420,50 @@ func (repo *Repository) Install(w io.Writer, conf *Conf) (err error) {
 	return nil
 }
 
-//ForEach is a convenient method for running logic for each chunk
-//key in stream 'r', it will skip the chunk header and footer
+//ForEach is a convenient method for running logic for each chunk key in
+//stream 'r', which is either a pointer of any version or a plain listing
+//of keys
 func (repo *Repository) ForEach(r io.Reader, fn func(K) error) error {
-	s := bufio.NewScanner(r)
+	return repo.forEachRouted(r, func(k K, _ string) error {
+		return fn(k)
+	})
+}
+
+//forEachRouted runs logic for each chunk key in stream 'r' like ForEach, it
+//also hands over the name of the remote that may follow the key as written
+//by Scan for paths that store their chunks on a named remote
+func (repo *Repository) forEachRouted(r io.Reader, fn func(K, string) error) error {
+	bufr := bufio.NewReader(r)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); pointer.IsPointer(hdr) {
+		ptr, err := pointer.Decode(bufr)
+		if err != nil {
+			return fmt.Errorf("failed to decode pointer: %v", err)
+		}
+
+		for _, c := range ptr.Chunks {
+			err = fn(K(c.Key), "")
+			if err != nil {
+				return fmt.Errorf("failed to handle key '%x': %v", c.Key, err)
+			}
+		}
+
+		return nil
+	}
+
+	s := bufio.NewScanner(bufr)
 	for s.Scan() {
 
-		//and in any case skip it
-		if bytes.Equal(s.Bytes(), repo.header[:len(repo.header)-1]) ||
-			bytes.Equal(s.Bytes(), repo.footer[:len(repo.footer)-1]) {
-			continue
+		//the key is the first field, optionally followed by a remote
+		line, name := s.Bytes(), ""
+		if i := bytes.IndexByte(line, ' '); i >= 0 {
+			line, name = line[:i], strings.TrimSpace(string(line[i+1:]))
 		}
 
 		//decode the actual keys
-		data := make([]byte, hex.DecodedLen(len(s.Bytes())))
-		_, err := hex.Decode(data, s.Bytes())
+		data := make([]byte, hex.DecodedLen(len(line)))
+		_, err := hex.Decode(data, line)
 		if err != nil {
-			return fmt.Errorf("failed to decode '%x' as hex: %v", s.Bytes(), err)
+			return fmt.Errorf("failed to decode '%x' as hex: %v", line, err)
 		}
 
 		//check key length
@@ -320,7 +474,7 @@ func (repo *Repository) ForEach(r io.Reader, fn func(K) error) error {
 
 		//fill K and hand it over
 		copy(k[:], data[:KeySize])
-		err = fn(k)
+		err = fn(k, name)
 		if err != nil {
 			return fmt.Errorf("failed to handle key '%x': %v", k, err)
 		}
@@ -336,9 +490,59 @@ func (repo *Repository) ForEach(r io.Reader, fn func(K) error) error {
 //Push takes a list of chunk keys on reader 'r' and moves each chunk from
 //the local storage to the remote store with name 'remote'. Prior to pushing
 //the local index of the remote is updated so chunks are not uploaded twice.
+//Only the remotes that the keys are routed to need to be configured.
 func (repo *Repository) Push(store *bolt.DB, r io.Reader, remoteName string) (err error) {
-	if repo.remote == nil {
-		return fmt.Errorf("unable to push, no remote configured")
+	names, groups, err := repo.groupRouted(r)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = repo.pushTo(store, name, groups[name])
+		if err != nil {
+			if name != "" {
+				return fmt.Errorf("failed to push to remote '%s': %v", name, err)
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+//groupRouted groups the keys on 'r' by the remote that stores them, scan
+//output names the remote after the key for paths with a 'bits-remote'
+//attribute. Remotes are returned in the order they are first named
+func (repo *Repository) groupRouted(r io.Reader) (names []string, groups map[string][]K, err error) {
+	groups = map[string][]K{}
+	err = repo.forEachRouted(r, func(k K, name string) error {
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+
+		groups[name] = append(groups[name], k)
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read keys: %v", err)
+	}
+
+	return names, groups, nil
+}
+
+//indexRemote records the chunks that 'remote' with 'name' stores in the
+//local index, such that chunks that are stored already aren't pushed again
+func (repo *Repository) indexRemote(store *bolt.DB, name string, remote Remote) (err error) {
+	bucket := indexBucket(name)
+	err = store.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to create bucket '%s': %v", string(bucket), err)
 	}
 
 	//err handling
@@ -354,7 +558,7 @@ func (repo *Repository) Push(store *bolt.DB, r io.Reader, remoteName string) (er
 	//ask the remote to fetch all chunk keys
 	pr, pw := io.Pipe()
 	go func() {
-		err = repo.remote.ListChunks(pw)
+		err = remote.ListChunks(pw)
 		defer pw.Close()
 		if err != nil {
 			errCh <- fmt.Errorf("failed to list remote chunk keys: %v", err)
@@ -369,13 +573,13 @@ func (repo *Repository) Push(store *bolt.DB, r io.Reader, remoteName string) (er
 			err = store.Batch(func(tx *bolt.Tx) error {
 				wg.Add(1)
 				defer wg.Done()
-				b := tx.Bucket(IndexBucket)
+				b := tx.Bucket(bucket)
 				err = b.Put(k[:], RemoteChunk)
 				if err != nil {
 					return fmt.Errorf("failed to put '%x': %v", k, err)
 				}
 
-				repo.keyProgressCh <- KeyOp{IndexOp, k, false, 0}
+				repo.keyProgressCh <- KeyOp{IndexOp, k, false, 0, ""}
 				return nil
 			})
 
@@ -393,328 +597,582 @@ func (repo *Repository) Push(store *bolt.DB, r io.Reader, remoteName string) (er
 		return fmt.Errorf("there were errors while indexing: \n %s", strings.Join(errs, "\n\t"))
 	}
 
-	//scan for chunk keys
-	err = repo.ForEach(r, func(k K) (ferr error) {
-		err = store.View(func(tx *bolt.Tx) error {
-			b := tx.Bucket(IndexBucket)
-			c := b.Get(k[:])
-			if c == nil {
-				return nil //doesnt exist
+	//index chunks that are stored in packs. The pack indexes are cached
+	//locally, only packs that were pushed elsewhere are downloaded and that
+	//happens before the store is locked for writing
+	packs, err := repo.remotePacks(name)
+	if err != nil {
+		return fmt.Errorf("failed to index remote packs: %v", err)
+	}
+
+	pbucket := packsBucket(name)
+	for _, pk := range packs {
+		err = store.Update(func(tx *bolt.Tx) error {
+			pb, err := tx.CreateBucketIfNotExists(pbucket)
+			if err != nil {
+				return err
 			}
 
-			if bytes.Equal(c, RemoteChunk) {
-				return ErrAlreadyPushed
+			//packs never change, one that was indexed before is skipped
+			if pb.Get(pk[:]) != nil {
+				return nil
 			}
 
-			return nil
-		})
+			data, err := os.ReadFile(repo.packIndexPath(name, pk))
+			if err != nil {
+				return fmt.Errorf("failed to read cached index of pack '%x': %v", pk, err)
+			}
 
-		//already pushed err is a good think, we can skip uploading this chunk!
-		if err == ErrAlreadyPushed {
-			repo.keyProgressCh <- KeyOp{PushOp, k, true, 0}
-			return nil
-		}
+			b := tx.Bucket(bucket)
+			err = ReadPackIndex(pk, bytes.NewReader(data), func(k K, loc PackLoc) error {
+				err := b.Put(k[:], loc.encode())
+				if err != nil {
+					return fmt.Errorf("failed to put '%x': %v", k, err)
+				}
+
+				repo.keyProgressCh <- KeyOp{IndexOp, k, false, 0, ""}
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+
+			return pb.Put(pk[:], []byte{1})
+		})
 
 		if err != nil {
-			return fmt.Errorf("failed to read index: %v", err)
+			return fmt.Errorf("failed to index remote pack '%x': %v", pk, err)
 		}
+	}
+
+	return nil
+}
+
+//pushTo moves the chunks with keys 'keys' to the remote with 'name'
+func (repo *Repository) pushTo(store *bolt.DB, name string, keys []K) (err error) {
+	remote, err := repo.chunkRemote(name)
+	if err != nil {
+		return fmt.Errorf("unable to push: %v", err)
+	}
+
+	err = repo.indexRemote(store, name, remote)
+	if err != nil {
+		return err
+	}
+
+	bucket := indexBucket(name)
 
-		//open local chunk file
+	//check each chunk key, chunks that are not yet stored remotely are
+	//bundled into packs that are pushed once they are large enough
+	total := int64(0)
+	for _, k := range keys {
 		p, _ := repo.Path(k, false)
-		f, err := os.OpenFile(p, os.O_RDONLY, 0666)
-		if err != nil {
-			return fmt.Errorf("failed to open chunk '%x' at '%s' for pushing: %v", k, p, err)
+		if fi, err := os.Stat(p); err == nil {
+			total += fi.Size()
 		}
+	}
 
-		//get remote writer
-		defer f.Close()
-		wc, err := repo.remote.ChunkWriter(k)
-		if err != nil {
-			return fmt.Errorf("failed to get chunk writer: %v", err)
-		}
+	repo.addTotal(PushOp, len(keys), total)
+	pck := newPack()
+	for _, k := range keys {
+		err = func() error {
+			err = store.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket(bucket)
+				c := b.Get(k[:])
+				if c == nil {
+					return nil //doesnt exist
+				}
+
+				return ErrAlreadyPushed
+			})
+
+			//already pushed err is a good think, we can skip uploading this chunk!
+			if err == ErrAlreadyPushed {
+				repo.keyProgressCh <- KeyOp{PushOp, k, true, 0, ""}
+				return nil
+			}
+
+			if err != nil {
+				return fmt.Errorf("failed to read index: %v", err)
+			}
+
+			//open local chunk file
+			p, _ := repo.Path(k, false)
+			f, err := os.OpenFile(p, os.O_RDONLY, 0666)
+			if err != nil {
+				return fmt.Errorf("failed to open chunk '%x' at '%s' for pushing: %v", k, p, err)
+			}
+
+			//add to the current pack
+			defer f.Close()
+			n, err := pck.add(k, f)
+			if err != nil {
+				return fmt.Errorf("failed to copy file '%s' to pack after %d bytes: %v", f.Name(), n, err)
+			}
+
+			if pck.buf.Len() < PackSize {
+				return nil
+			}
+
+			//pack is full, push it and start a new one
+			err = repo.pushPack(store, name, pck)
+			if err != nil {
+				return fmt.Errorf("failed to push pack: %v", err)
+			}
+
+			pck = newPack()
+			return nil
+		}()
 
-		//start upload
-		defer wc.Close()
-		n, err := io.Copy(wc, f)
 		if err != nil {
-			return fmt.Errorf("failed to copy file '%s' to remote writer after %d bytes: %v", f.Name(), n, err)
+			return fmt.Errorf("failed to handle key '%x': %v", k, err)
 		}
+	}
 
-		//indicate we pushed the chunk
-		repo.keyProgressCh <- KeyOp{PushOp, k, false, n}
-		return nil
-	})
-
+	err = repo.pushPack(store, name, pck)
 	if err != nil {
-		return fmt.Errorf("failed to loop over each key: %v", err)
+		return fmt.Errorf("failed to push pack: %v", err)
 	}
 
 	return nil
 }
 
-//Fetch takes a list of chunk keys on reader 'r' and will try to fetch chunks
-//that are not yet stored locally. Chunks that are already stored locally should
-//result in a no-op, all keys (fetched or not) will be written to 'w'.
+//Fetch takes a pointer or a list of chunk keys on reader 'r' and will try to
+//fetch chunks that are not yet stored locally. Chunks that are already stored
+//locally should result in a no-op. A pointer is written to 'w' as it was read
+//such that Combine can verify the content, otherwise all keys (fetched or not)
+//will be written to 'w'.
 func (repo *Repository) Fetch(r io.Reader, w io.Writer) (err error) {
-	printk := func(k K) error {
-		_, err := fmt.Fprintf(w, "%x\n", k)
-		return err
-	}
+	return repo.FetchFrom("", r, w)
+}
 
-	return repo.ForEach(r, func(k K) error {
+//FetchFrom fetches chunks like Fetch but from the remote with 'name', as
+//selected for a path by the 'bits-remote' attribute
+func (repo *Repository) FetchFrom(name string, r io.Reader, w io.Writer) (err error) {
+	return repo.fetchFrom(name, "", r, w)
+}
 
-		//setup chunk path
-		p, err := repo.Path(k, true)
+//fetchFrom fetches chunks like FetchFrom for the file at 'path', if known
+func (repo *Repository) fetchFrom(name, path string, r io.Reader, w io.Writer) (err error) {
+	bufr := bufio.NewReader(r)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); pointer.IsPointer(hdr) {
+		ptr, err := pointer.Decode(bufr)
 		if err != nil {
-			return fmt.Errorf("failed to create chunk path for key '%x': %v", k, err)
+			return fmt.Errorf("failed to decode pointer: %v", err)
 		}
 
-		//attempt to open, if its already assume it was written concurrently
-		f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
-		if err != nil {
-			if os.IsExist(err) {
-				repo.keyProgressCh <- KeyOp{FetchOp, k, true, 0}
-				return printk(k)
+		repo.addTotal(FetchOp, len(ptr.Chunks), ptr.Size)
+		for _, c := range ptr.Chunks {
+			err = repo.fetchChunk(name, path, K(c.Key))
+			if err != nil {
+				return fmt.Errorf("failed to handle key '%x': %v", c.Key, err)
 			}
-
-			return fmt.Errorf("failed to open chunk file '%s' for writing: %v", p, err)
 		}
 
-		if repo.remote == nil {
-			return fmt.Errorf("key '%x' isn't stored locally, but no remote is configured", k)
-		}
-
-		rc, err := repo.remote.ChunkReader(k)
-		if err != nil {
-			return fmt.Errorf("failed to get chunk reader for key '%x': %v", k, err)
-		}
+		return repo.writePointer(ptr, w)
+	}
 
-		defer rc.Close()
-		n, err := io.Copy(f, rc)
+	return repo.ForEach(bufr, func(k K) error {
+		err := repo.fetchChunk(name, path, k)
 		if err != nil {
-			return fmt.Errorf("failed to clone chunk '%x' from remote: %v", k, err)
+			return err
 		}
 
-		//indicate we fetched a key
-		repo.keyProgressCh <- KeyOp{FetchOp, k, false, n}
-		return printk(k)
+		_, err = fmt.Fprintf(w, "%x\n", k)
+		return err
 	})
 }
 
-//Path returns the local path to the chunk file based on the key, it can
-//create required directories when 'mkdir' is set to true, in that case
-//err might container directory creation failure.
-func (repo *Repository) Path(k K, mkdir bool) (p string, err error) {
-	dir := filepath.Join(repo.chunkDir, fmt.Sprintf("%x", k[:2]))
-	if mkdir {
-		err = os.MkdirAll(dir, 0777)
-		if err != nil {
-			return "", fmt.Errorf("failed to create chunk dir '%s': %v", dir, err)
+//writePointer writes the pointer in the format that it was read in, version 1
+//pointers only list their keys
+func (repo *Repository) writePointer(ptr *pointer.Pointer, w io.Writer) (err error) {
+	if ptr.Version > 1 {
+		return ptr.Encode(w)
+	}
+
+	_, err = w.Write(pointer.Header)
+	for _, c := range ptr.Chunks {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%x\n", c.Key)
 		}
 	}
 
-	return filepath.Join(dir, fmt.Sprintf("%x", k[2:])), nil
+	if err == nil {
+		_, err = w.Write(pointer.Footer)
+	}
+
+	return err
 }
 
-//LocalStore will return the local chunk store, creating it in the
-//repositories chunk directory if it doesnt exist yet. It creates
-//the necessary buckets if they dont exist yet
-func (repo *Repository) LocalStore() (db *bolt.DB, err error) {
-	dbpath := filepath.Join(repo.chunkDir, "a.chunks")
-	db, err = bolt.Open(dbpath, 0666, &bolt.Options{Timeout: 1 * time.Second})
+//fetchChunk fetches the chunk with key 'k' of the file at 'path', if known,
+//from the remote with 'name' if it is not yet stored locally
+func (repo *Repository) fetchChunk(name, path string, k K) (err error) {
+	//setup chunk path
+	p, err := repo.Path(k, true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open chunks database '%s': %v", dbpath, err)
+		return fmt.Errorf("failed to create chunk path for key '%x': %v", k, err)
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(IndexBucket)
-		if err != nil {
-			return fmt.Errorf("failed to create bucket: %s", err)
-		}
+	//chunks that are stored already were fetched before or concurrently
+	if _, err = os.Stat(p); err == nil {
+		repo.keyProgressCh <- KeyOp{FetchOp, k, true, 0, path}
 		return nil
-	})
+	}
 
+	//the chunk is written to a temporary file that is moved in place once
+	//it is complete, an interrupted fetch never leaves a partial chunk
+	f, err := os.CreateTemp(filepath.Dir(p), ".fetch_")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create bucket '%s': %v", string(IndexBucket), err)
+		return fmt.Errorf("failed to open chunk file '%s' for writing: %v", p, err)
 	}
 
-	return db, nil
-}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	n, err := func() (int64, error) {
+		_, err := repo.chunkRemote(name)
+		if err != nil {
+			return 0, fmt.Errorf("key '%x' isn't stored locally: %v", k, err)
+		}
+
+		rc, err := repo.remoteChunkReader(name, k)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get chunk reader for key '%x': %v", k, err)
+		}
+
+		defer rc.Close()
+		n, err := io.Copy(f, rc)
+		if err != nil {
+			return n, fmt.Errorf("failed to clone chunk '%x' from remote: %v", k, err)
+		}
+
+		return n, nil
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	err = f.Chmod(0644)
+	if err == nil {
+		err = f.Close()
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to write chunk '%x': %v", k, err)
+	}
+
+	err = os.Rename(f.Name(), p)
+	if err != nil {
+		return fmt.Errorf("failed to move chunk '%x' in place: %v", k, err)
+	}
+
+	//indicate we fetched a key
+	repo.keyProgressCh <- KeyOp{FetchOp, k, false, n, path}
+	return nil
+}
+
+//Path returns the local path to the chunk file based on the key, it can
+//create required directories when 'mkdir' is set to true, in that case
+//err might container directory creation failure.
+func (repo *Repository) Path(k K, mkdir bool) (p string, err error) {
+	dir := filepath.Join(repo.chunkDir, fmt.Sprintf("%x", k[:2]))
+	if mkdir {
+		err = os.MkdirAll(dir, 0777)
+		if err != nil {
+			return "", fmt.Errorf("failed to create chunk dir '%s': %v", dir, err)
+		}
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("%x", k[2:])), nil
+}
+
+//LocalStore will return the local chunk store, creating it in the
+//repositories chunk directory if it doesnt exist yet. It creates
+//the necessary buckets if they dont exist yet
+func (repo *Repository) LocalStore() (db *bolt.DB, err error) {
+	dbpath := filepath.Join(repo.chunkDir, "a.chunks")
+	db, err = bolt.Open(dbpath, 0666, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chunks database '%s': %v", dbpath, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(IndexBucket)
+		if err != nil {
+			return fmt.Errorf("failed to create bucket: %s", err)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bucket '%s': %v", string(IndexBucket), err)
+	}
+
+	return db, nil
+}
 
 //Pull get all file paths of blobs that hold chunk keys in the provided ref
 //and combine the chunks in them into their original file, fetching any chunks
 //not currently available in the local store
 func (repo *Repository) Pull(ref string, w io.Writer) (err error) {
+	filter, err := repo.FetchFilter()
+	if err != nil {
+		return err
+	}
 
-	// ls-tree -r -l | f1 | f2 | git update-index -q --refresh --stdin
+	return repo.PullWith(ref, nil, filter, w)
+}
+
+//FetchFilter returns the filter of the paths whose content is fetched as
+//configured with 'bits.fetch-include' and 'bits.fetch-exclude'
+func (repo *Repository) FetchFilter() (f *PathFilter, err error) {
+	f, err = NewPathFilter(repo.conf.FetchInclude, repo.conf.FetchExclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fetch patterns: %v", err)
+	}
+
+	return f, nil
+}
+
+//refPointers hands each pointer in the tree of 'ref' at the paths that match
+//'pathspecs', if any, and are selected by 'filter' to 'fn' with the policy of
+//its path. Pathspecs are matched by ls-tree which doesn't support patterns
+func (repo *Repository) refPointers(ref string, pathspecs []string, filter *PathFilter, fn func(pol *Policy, data []byte) error) (err error) {
 	ctx := context.Background()
-	r1, w1 := io.Pipe()
-	r2, w2 := io.Pipe()
-	r3, w3 := io.Pipe()
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(ctx, nil, buf, append([]string{"ls-tree", "-r", "-l", "-z", ref, "--"}, pathspecs...)...)
+	if err != nil {
+		return fmt.Errorf("failed to list tree of '%s': %v", ref, err)
+	}
 
-	errs := []string{}
-	errCh := make(chan error)
-	defer close(errCh)
-	go func() {
-		for err := range errCh {
-			errs = append(errs, fmt.Sprintf("%v", err))
+	//entries are: <mode> SP <type> SP <object> SP <size> TAB <path>, blobs
+	//that can't even hold an empty pointer are skipped
+	objs, paths := bytes.NewBuffer(nil), []string{}
+	for _, entry := range strings.Split(buf.String(), "\x00") {
+		tfields := strings.SplitN(entry, "\t", 2)
+		fields := strings.Fields(tfields[0])
+		if len(tfields) != 2 || len(fields) != 4 || fields[1] != "blob" {
+			continue
 		}
-	}()
 
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil || size < pointer.MinSize || !filter.Match(tfields[1]) {
+			continue
+		}
+
+		fmt.Fprintf(objs, "%s\n", fields[2])
+		paths = append(paths, tfields[1])
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	pols, err := repo.Policies(paths)
+	if err != nil {
+		return err
+	}
+
+	//the blobs are streamed as: <object> SP <type> SP <size> LF <content> LF
+	pr, pw := io.Pipe()
 	go func() {
-		defer w1.Close()
-		err = repo.Git(ctx, nil, w1, "ls-tree", "-r", "-l", ref)
+		pw.CloseWithError(repo.Git(ctx, objs, pw, "cat-file", "--batch"))
+	}()
+
+	defer pr.Close()
+	bufr := bufio.NewReader(pr)
+	for _, p := range paths {
+		hdr, err := bufr.ReadString('\n')
 		if err != nil {
-			//@TODO this will error if the repository is empty (no commits yet)
-			//probably throw a warning instead
-			// errCh <- err
+			return fmt.Errorf("failed to read blob of '%s': %v", p, err)
 		}
-	}()
 
-	go func() {
-		defer w2.Close()
-		s := bufio.NewScanner(r1)
-		for s.Scan() {
+		fields := strings.Fields(hdr)
+		size, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err != nil || len(fields) != 3 {
+			return fmt.Errorf("unexpected blob header for '%s': %s", p, hdr)
+		}
 
-			//@see https://git-scm.com/docs/git-ls-tree
-			//line : <mode> SP <type> SP <object> TAB <file>, we use the
-			//tab to be able to clearly seperate the file name as it may contain
-			//field seperating characters
-			tfields := bytes.SplitN(s.Bytes(), []byte("\t"), 2)
-			fields := bytes.Fields(s.Bytes())
-			if len(fields) < 5 || len(tfields) != 2 || !bytes.Equal(fields[1], []byte("blob")) {
-				continue
-			}
+		//only pointers are read into memory, other content is skipped
+		var data []byte
+		if peek, _ := bufr.Peek(len(pointer.Header)); pointer.IsPointer(peek) {
+			data = make([]byte, size)
+			_, err = io.ReadFull(bufr, data)
+		} else {
+			_, err = io.CopyN(io.Discard, bufr, size)
+		}
 
-			objSize, err := strconv.ParseInt(string(fields[3]), 10, 64)
-			if err != nil {
-				errCh <- err
-				continue
-			}
+		if err == nil {
+			_, err = bufr.Discard(1)
+		}
 
-			//all key files have a size that is the exact multiple of
-			//33 bytes: 32 bytes hex encoded hashes with a newline character
-			if objSize%int64(hex.EncodedLen(KeySize)+1) != 0 {
-				continue
-			}
+		if err != nil {
+			return fmt.Errorf("failed to read blob of '%s': %v", p, err)
+		}
 
-			fmt.Fprintf(w2, "%s\n", tfields[1])
+		if data == nil {
+			continue
 		}
 
-		if err = s.Err(); err != nil {
-			errCh <- err
+		err = fn(pols[p], data)
+		if err != nil {
+			return err
 		}
-	}()
+	}
 
-	go func() {
-		defer w3.Close()
-		s := bufio.NewScanner(r2)
-		for s.Scan() {
-			err = func() error {
-				fpath := filepath.Join(repo.rootDir, s.Text())
-				tmpfpath := ""
-
-				err = func() error {
-					f, err := os.OpenFile(fpath, os.O_RDWR|os.O_CREATE, 0666)
-					if err != nil {
-						return err
-					}
-
-					defer f.Close()
-					hdr := make([]byte, hex.EncodedLen(KeySize))
-					_, err = f.Read(hdr)
-					if err != nil {
-						//if we cant even read a complete header, its not gonna contain chunks
-						return nil
-					}
-
-					offs, err := f.Seek(0, 0)
-					if err != nil || offs != 0 {
-						return fmt.Errorf("failed to seek files: %v", err)
-					}
-
-					if !bytes.Equal(hdr, repo.header[:len(repo.header)-1]) {
-						return nil
-					}
-
-					//We know its a chunks file that needs filling
-					tmpf, err := ioutil.TempFile("", "bits_tmp_")
-					if err != nil {
-						return err
-					}
-
-					tmpfpath = tmpf.Name()
-					defer tmpf.Close()
-					fi, err := f.Stat()
-					if err != nil {
-						return fmt.Errorf("failed to stat original file for permissions: %v", err)
-					}
-
-					//mod the tempfile as the original
-					err = os.Chmod(tmpfpath, fi.Mode())
-					if err != nil {
-						return fmt.Errorf("failed to modify temp file permissions: %v", err)
-					}
-
-					pr, pw := io.Pipe()
-					go func() {
-						defer pw.Close()
-						err = repo.Fetch(f, pw)
-						if err != nil {
-							errCh <- err
-						}
-					}()
-
-					err = repo.Combine(pr, tmpf)
-					if err != nil {
-						return fmt.Errorf("failed to combine: %v", err)
-					}
+	return nil
+}
 
-					return nil
-				}()
+//holdsPointer returns whether the file at 'fpath' holds exactly pointer 'data'
+func holdsPointer(fpath string, data []byte) bool {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return false
+	}
 
-				if err != nil {
-					return err
-				}
+	defer f.Close()
+	cur := make([]byte, len(data)+1)
+	n, _ := io.ReadFull(f, cur)
+	return bytes.Equal(cur[:n], data)
+}
 
-				//no tmpfpath means we have no files to move, wer're done here
-				if tmpfpath == "" {
-					return nil
-				}
+//PullWith combines files like Pull but only considers the paths in 'ref'
+//that match 'pathspecs', if any, and are selected by 'filter'. The chunks of
+//each pointer in 'ref' are fetched but a file is only written if the working
+//tree holds that same pointer, pulling another ref fetches the chunks for a
+//later checkout without changing the working tree
+func (repo *Repository) PullWith(ref string, pathspecs []string, filter *PathFilter, w io.Writer) (err error) {
+	errs := []string{}
+	pulled := bytes.NewBuffer(nil)
+	err = repo.refPointers(ref, pathspecs, filter, func(pol *Policy, data []byte) error {
+		written, err := repo.pullFile(pol, data)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("failed to pull '%s': %v", pol.Path, err))
+		} else if written {
+			fmt.Fprintf(pulled, "%s\n", pol.Path)
+		}
 
-				err = os.Remove(fpath)
-				if err != nil {
-					return fmt.Errorf("failed to remove original file '%s': %v", fpath, err)
-				}
+		return nil
+	})
 
-				err = os.Rename(tmpfpath, fpath)
-				if err != nil {
-					return fmt.Errorf("failed to move '%s' to '%s': %v", tmpfpath, s.Text(), err)
-				}
+	if err != nil {
+		return err
+	}
 
-				fmt.Fprintf(w3, "%s\n", fpath)
-				return nil
-			}()
+	//the combined files are stat dirty, their content still cleans to the
+	//pointer in the index
+	err = repo.Git(context.Background(), pulled, nil, "update-index", "-q", "--refresh", "--stdin")
+	if err != nil {
+		return fmt.Errorf("failed to update index: %v", err)
+	}
 
-			if err != nil {
-				errCh <- fmt.Errorf("failed to check file '%s' for header content: %v", s.Text(), err)
-			}
+	if len(errs) > 0 {
+		return fmt.Errorf("there were pull errors: \n\t%s", strings.Join(errs, "\n\t"))
+	}
+
+	return nil
+}
+
+//pullFile fetches the chunks of pointer 'data' of the file that policy 'pol'
+//applies to and, if the working tree holds the same pointer, replaces the
+//file with the combined content. Nothing is written if combining fails
+func (repo *Repository) pullFile(pol *Policy, data []byte) (written bool, err error) {
+	fpath := filepath.Join(repo.rootDir, pol.Path)
+	if !holdsPointer(fpath, data) {
+		return false, repo.fetchFrom(pol.Remote, pol.Path, bytes.NewReader(data), io.Discard)
+	}
+
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat original file for permissions: %v", err)
+	}
+
+	//the content is combined next to the file such that it can be renamed
+	tmpf, err := os.CreateTemp(filepath.Dir(fpath), ".bits_tmp_")
+	if err != nil {
+		return false, err
+	}
+
+	tmpfpath := tmpf.Name()
+	err = func() error {
+		defer tmpf.Close()
+		err = os.Chmod(tmpfpath, fi.Mode())
+		if err != nil {
+			return fmt.Errorf("failed to modify temp file permissions: %v", err)
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(repo.fetchFrom(pol.Remote, pol.Path, bytes.NewReader(data), pw))
+		}()
+
+		err = repo.Combine(pr, tmpf)
+		pr.Close()
+		if err != nil {
+			return fmt.Errorf("failed to combine: %v", err)
 		}
+
+		return tmpf.Close()
 	}()
 
-	err = repo.Git(ctx, r3, nil, "update-index", "-q", "--refresh", "--stdin")
+	if err == nil {
+		err = os.Rename(tmpfpath, fpath)
+	}
+
 	if err != nil {
-		return fmt.Errorf("failed to update index: %v", err)
+		os.Remove(tmpfpath)
+		return false, err
 	}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("there were scanning errors: \n %s", strings.Join(errs, "\n\t"))
+	return true, nil
+}
+
+//pullBatchSize is the number of paths that PullChanged hands to a single
+//ls-tree such that the command line stays within limits
+const pullBatchSize = 512
+
+//PullChanged combines files like Pull but only those that differ between
+//commit 'from' and 'to', all files in 'to' are combined if 'from' is empty or
+//the null commit, e.g. for the checkout that follows a clone
+func (repo *Repository) PullChanged(from, to string, w io.Writer) (err error) {
+	if strings.Trim(from, "0") == "" {
+		return repo.Pull(to, w)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(context.Background(), nil, buf, "diff-tree", "-r", "-z", "--name-only", "--no-renames", "--diff-filter=d", from, to)
+	if err != nil {
+		return fmt.Errorf("failed to list changed files: %v", err)
+	}
+
+	filter, err := repo.FetchFilter()
+	if err != nil {
+		return err
+	}
+
+	paths := []string{}
+	for _, p := range strings.Split(buf.String(), "\x00") {
+		if p != "" {
+			paths = append(paths, ":(literal)"+p)
+		}
+	}
+
+	for i := 0; i < len(paths); i += pullBatchSize {
+		err = repo.PullWith(to, paths[i:min(i+pullBatchSize, len(paths))], filter, w)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+//ScanEach scans like Scan for each line on 'r', which is either a line of
+//pre-push hook input or one or two revisions. Keys are written once even if
+//several lines reach them, deleted refs are skipped
 func (repo *Repository) ScanEach(r io.Reader, w io.Writer) (err error) {
+	revs := []string{}
 	s := bufio.NewScanner(r)
 	for s.Scan() {
 		fields := bytes.Fields(s.Bytes())
@@ -722,10 +1180,16 @@ func (repo *Repository) ScanEach(r io.Reader, w io.Writer) (err error) {
 		right := ""
 
 		switch len(fields) {
-		case 4: //push hook format
+		case 0:
+			continue
+		case 4: //push hook format: <local ref> <local sha> <remote ref> <remote sha>
 			right = string(fields[1])
 			left = string(fields[3])
-			if left == "0000000000000000000000000000000000000000" {
+			if strings.Trim(right, "0") == "" {
+				continue //deleted ref, nothing to push
+			}
+
+			if strings.Trim(left, "0") == "" {
 				left = ""
 			}
 		case 1: //scan refs (left empty)
@@ -737,18 +1201,121 @@ func (repo *Repository) ScanEach(r io.Reader, w io.Writer) (err error) {
 			return fmt.Errorf("unexpected input for scanning: %s", s.Text())
 		}
 
-		return repo.Scan(left, right, w)
+		revs = append(revs, right)
+		if left != "" {
+			revs = append(revs, "^"+left)
+		}
+	}
+
+	if err = s.Err(); err != nil {
+		return err
+	}
+
+	if len(revs) == 0 {
+		return nil
 	}
 
-	return s.Err()
+	return repo.scanRevs(revs, w)
 }
 
 //Scan will traverse git objects between commit 'left' and 'right', it will
 //look for blobs larger then 32 bytes that are also in the clean log. These
 //blobs should contain keys that are written to writer 'w'
 func (repo *Repository) Scan(left, right string, w io.Writer) (err error) {
+	revs := []string{right}
+	if left != "" {
+		revs = append(revs, "^"+left)
+	}
+
+	return repo.scanRevs(revs, w)
+}
+
+//scanRevs writes the keys of the pointers in the objects listed by rev-list
+//for 'revs' to 'w', each key once
+func (repo *Repository) scanRevs(revs []string, w io.Writer) (err error) {
+	type found struct {
+		path string
+		keys []K
+	}
+
+	//the policies of all paths are determined at once after the scan
+	founds, paths := []found{}, []string{}
+	seen := map[string]bool{}
+	err = repo.scanPointers(revs, func(obj, path string, data []byte) error {
+		keys := []K{}
+		err := repo.ForEach(bytes.NewReader(data), func(k K) error {
+			keys = append(keys, k)
+			return nil
+		})
+
+		if err != nil {
+			fmt.Fprintf(repo.output, "skipping blob '%s' that is not a valid chunk listing: %v\n", obj, err)
+			return nil
+		}
+
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+
+		founds = append(founds, found{path, keys})
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	pols, err := repo.Policies(paths)
+	if err != nil {
+		return fmt.Errorf("failed to determine policies: %v", err)
+	}
+
+	//chunks of paths with a 'bits-remote' attribute are annotated with the
+	//name of the remote that stores them, each key is written once
+	scanned := map[string]struct{}{}
+	for _, f := range founds {
+		for _, k := range f.keys {
+			kname := fmt.Sprintf("%x", k)
+			if name := pols[f.path].Remote; name != "" {
+				kname = kname + " " + name
+			}
+
+			if _, ok := scanned[kname]; !ok {
+				fmt.Fprintf(w, "%s\n", kname)
+				scanned[kname] = struct{}{}
+			}
+		}
+	}
+
+	return nil
+}
+
+//scanPointers hands each blob that holds a pointer in the objects listed by
+//rev-list for 'revs' to 'fn' with its object name and the path it was
+//found at
+func (repo *Repository) scanPointers(revs []string, fn func(obj, path string, data []byte) error) (err error) {
+	return repo.scanBlobs(revs, pointer.MinSize, -1, func(obj, path string, r io.Reader) error {
+		bufr := bufio.NewReader(r)
+		if hdr, _ := bufr.Peek(len(pointer.Header)); !pointer.IsPointer(hdr) {
+			return nil
+		}
 
-	// rev-list --objects <right> ^<left> | f1 | cat-file --batch-check | f2 | cat-file --batch | f3
+		data, err := io.ReadAll(bufr)
+		if err != nil {
+			return fmt.Errorf("failed to read blob '%s': %v", obj, err)
+		}
+
+		return fn(obj, path, data)
+	})
+}
+
+//scanBlobs hands each blob of at least 'minSize' and at most 'maxSize' bytes,
+//any size if it is negative, in the objects listed by rev-list for 'revs' to
+//'fn' with its object name and the path it was found at. The content is
+//streamed, 'fn' doesn't need to read all of it
+func (repo *Repository) scanBlobs(revs []string, minSize, maxSize int64, fn func(obj, path string, r io.Reader) error) (err error) {
+	// rev-list --objects <revs> | f1 | cat-file --batch-check | f2 | cat-file --batch | f3
 	ctx := context.Background()
 	r1, w1 := io.Pipe()
 	r2, w2 := io.Pipe()
@@ -767,26 +1334,33 @@ func (repo *Repository) Scan(left, right string, w io.Writer) (err error) {
 
 	go func() {
 		defer w1.Close()
-		args := []string{"rev-list", "--objects", right}
-		if left != "" {
-			args = append(args, "^"+left)
-		}
-
-		err = repo.Git(ctx, nil, w1, args...)
+		err = repo.Git(ctx, nil, w1, append([]string{"rev-list", "--objects"}, revs...)...)
 		if err != nil {
 			errCh <- err
 		}
 	}()
 
+	//rev-list also outputs the path of each blob, we remember it so the
+	//remote that stores the chunks can be determined from its attributes
+	paths := map[string]string{}
+	var pathsMu sync.Mutex
 	go func() {
 		defer w2.Close()
 		s := bufio.NewScanner(r1)
 		for s.Scan() {
-			fields := bytes.Fields(s.Bytes())
-			if len(fields) < 1 {
+			fields := bytes.SplitN(s.Bytes(), []byte(" "), 2)
+			if len(fields[0]) < 1 {
 				continue
 			}
 
+			if len(fields) > 1 {
+				pathsMu.Lock()
+				if _, ok := paths[string(fields[0])]; !ok {
+					paths[string(fields[0])] = string(fields[1])
+				}
+				pathsMu.Unlock()
+			}
+
 			fmt.Fprintf(w2, "%s\n", fields[0])
 		}
 
@@ -821,9 +1395,7 @@ func (repo *Repository) Scan(left, right string, w io.Writer) (err error) {
 				continue
 			}
 
-			//all key files have a size that is the exact multiple of
-			//33 bytes: 32 bytes hex encoded hashes with a newline character
-			if objSize%int64(hex.EncodedLen(KeySize)+1) != 0 {
+			if objSize < minSize || (maxSize >= 0 && objSize > maxSize) {
 				continue
 			}
 
@@ -843,32 +1415,42 @@ func (repo *Repository) Scan(left, right string, w io.Writer) (err error) {
 		}
 	}()
 
-	scanned := map[string]struct{}{}
-	recording := false
-	s := bufio.NewScanner(r5)
-	for s.Scan() {
-		if bytes.Equal(s.Bytes(), repo.header[:len(repo.header)-1]) {
-			recording = true
-			continue
+	//cat-file outputs a '<object> <type> <size>' line followed by the content
+	bufr := bufio.NewReader(r5)
+	for {
+		line, err := bufr.ReadString('\n')
+		if err == io.EOF {
+			break
 		}
 
-		if bytes.Equal(s.Bytes(), repo.footer[:len(repo.footer)-1]) {
-			recording = false
-			continue
+		if err != nil {
+			return fmt.Errorf("failed to scan key blobs: %v", err)
 		}
 
-		//if we found keys, output each key on a new line
-		//but only if we didn't output it before
-		if recording {
-			if _, ok := scanned[s.Text()]; !ok {
-				fmt.Fprintf(w, "%s\n", s.Text())
-				scanned[s.Text()] = struct{}{}
-			}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue //missing object
 		}
-	}
 
-	if err = s.Err(); err != nil {
-		return fmt.Errorf("failed to scan key blobs: %v", err)
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected object size in '%s': %v", strings.TrimSpace(line), err)
+		}
+
+		pathsMu.Lock()
+		path := paths[fields[0]]
+		pathsMu.Unlock()
+		lr := &io.LimitedReader{R: bufr, N: size}
+		err = fn(fields[0], path, lr)
+		if err != nil {
+			return err
+		}
+
+		//content that wasn't read and the newline that follows it are skipped
+		_, err = io.Copy(io.Discard, io.LimitReader(bufr, lr.N+1))
+		if err != nil {
+			return fmt.Errorf("failed to read blob '%s': %v", fields[0], err)
+		}
 	}
 
 	if len(errs) > 0 {
@@ -882,16 +1464,33 @@ func (repo *Repository) Scan(left, right string, w io.Writer) (err error) {
 //while outputting keys for those chunks on writer 'w'. Chunks are written to a local chunk
 //space, pushing these to a remote store happens at a later time (pre-push hook)
 func (repo *Repository) Split(r io.Reader, w io.Writer) (err error) {
+	return repo.SplitWith(DefaultPolicy(), r, w)
+}
+
+//SplitWith splits content like Split but chunks and stores it as described
+//by policy 'pol', as returned for a path by Policy()
+func (repo *Repository) SplitWith(pol *Policy, r io.Reader, w io.Writer) (err error) {
 	if repo.conf.DeduplicationScope == 0 {
 		return fmt.Errorf("no deduplication scope configured, please run init")
 	}
 
 	//create a buffer that allows us to peek if this is a file that
-	//is already spit, if so: simply copy over the bytes, nothing to split
+	//is already spit, if so: simply copy over the bytes, nothing to split.
+	//Invalid pointers, e.g. with merge conflict markers, are never stored
 	bufr := bufio.NewReader(r)
-	hdr, _ := bufr.Peek(hex.EncodedLen(KeySize) + 1)
-	if bytes.Equal(hdr, repo.header) {
-		_, err := io.Copy(w, bufr)
+	hdr, _ := bufr.Peek(len(pointer.Header))
+	if pointer.IsPointer(hdr) {
+		data, err := io.ReadAll(bufr)
+		if err != nil {
+			return fmt.Errorf("failed to read already chunked file content: %v", err)
+		}
+
+		_, err = pointer.Parse(data)
+		if err != nil {
+			return fmt.Errorf("refusing to store an invalid pointer, resolve any merge conflict first: %v", err)
+		}
+
+		_, err = w.Write(data)
 		if err != nil {
 			return fmt.Errorf("failed to copy already chunked file content: %v", err)
 		}
@@ -899,14 +1498,33 @@ func (repo *Repository) Split(r io.Reader, w io.Writer) (err error) {
 		return nil
 	}
 
-	//it is a feel that needs splitting, start
-	//writing header and footer
-	w.Write(repo.header)
-	defer w.Write(repo.footer)
+	//it is a file that needs splitting, the pointer records the whole file
+	//hash and how it was chunked
+	err = pol.Validate(repo.conf)
+	if err != nil {
+		return err
+	}
+
+	conf := pol.Conf(repo.conf)
+	ptr := &pointer.Pointer{
+		Version: pointer.Version,
+		Chunking: pointer.Chunking{
+			Algorithm: conf.ChunkingAlgorithm,
+			Scope:     conf.DeduplicationScope,
+			MinSize:   conf.ChunkMinSize,
+			AvgSize:   conf.ChunkAvgSize,
+			MaxSize:   conf.ChunkMaxSize,
+		},
+	}
 
 	//write actual chunks
-	chunkr := chunker.New(bufr, chunker.Pol(repo.conf.DeduplicationScope))
-	buf := make([]byte, ChunkBufferSize)
+	fileh := sha256.New()
+	chunkr, err := NewChunker(io.TeeReader(bufr, fileh), conf)
+	if err != nil {
+		return fmt.Errorf("failed to setup chunker: %v", err)
+	}
+
+	buf := make([]byte, conf.ChunkMaxSize)
 	for {
 		chunk, err := chunkr.Next(buf)
 		if err == io.EOF {
@@ -914,19 +1532,13 @@ func (repo *Repository) Split(r io.Reader, w io.Writer) (err error) {
 		}
 
 		if err != nil {
-			return fmt.Errorf("Failed to write chunk (%d bytes) to buffer (size %d bytes): %v", chunk.Length, ChunkBufferSize, err)
+			return fmt.Errorf("Failed to write chunk (%d bytes) to buffer (size %d bytes): %v", len(chunk), len(buf), err)
 		}
 
 		//@TODO use hmac(SHA256) with the deduplication scope as a key
-		k := sha256.Sum256(chunk.Data)
-		printk := func(k K) error {
-			_, err = fmt.Fprintf(w, "%x\n", k)
-			if err != nil {
-				return fmt.Errorf("failed to write key to output: %v", err)
-			}
-
-			return nil
-		}
+		k := sha256.Sum256(chunk)
+		ptr.Size += int64(len(chunk))
+		ptr.Chunks = append(ptr.Chunks, pointer.Chunk{Key: pointer.Key(k), Size: int64(len(chunk))})
 
 		err = func() error {
 
@@ -942,36 +1554,23 @@ func (repo *Repository) Split(r io.Reader, w io.Writer) (err error) {
 
 				//if its already written, all good; output key
 				if os.IsExist(err) {
-					repo.keyProgressCh <- KeyOp{StageOp, k, true, 0}
-					return printk(k)
+					repo.keyProgressCh <- KeyOp{StageOp, k, true, 0, pol.Path}
+					return nil
 				}
 
 				return fmt.Errorf("Failed to open chunk file '%s' for writing: %v", p, err)
 			}
 
-			//aes encryption with
-			block, err := aes.NewCipher(k[:])
-			if err != nil {
-				return fmt.Errorf("failed to create cipher for key '%x': %v", k, err)
-			}
-
-			//create encrypt writer
-			//@TODO use GCM cipher mode
-			//@TODO	If the key is unique for each ciphertext, then it's ok to use a zero IV.
+			//encode (compress, encrypt) and write to file
 			defer f.Close()
-			var iv [aes.BlockSize]byte
-			stream := cipher.NewOFB(block, iv[:])
-			encryptw := &cipher.StreamWriter{S: stream, W: f}
-
-			//encrypt and write to file
-			n, err := encryptw.Write(chunk.Data)
+			err = writeChunk(k, chunk, pol, f)
 			if err != nil {
-				return fmt.Errorf("Failed to write chunk '%x' (wrote %d bytes): %v", k, n, err)
+				return fmt.Errorf("Failed to write chunk '%x': %v", k, err)
 			}
 
-			//report staging and output key
-			repo.keyProgressCh <- KeyOp{StageOp, k, false, int64(n)}
-			return printk(k)
+			//report staging
+			repo.keyProgressCh <- KeyOp{StageOp, k, false, int64(len(chunk)), pol.Path}
+			return nil
 		}()
 
 		if err != nil {
@@ -979,48 +1578,79 @@ func (repo *Repository) Split(r io.Reader, w io.Writer) (err error) {
 		}
 	}
 
+	copy(ptr.Hash[:], fileh.Sum(nil))
+	err = ptr.Encode(w)
+	if err != nil {
+		return fmt.Errorf("failed to write pointer: %v", err)
+	}
+
 	return nil
 }
 
-//Combine turns a newline seperated list of chunk keys from 'r' by reading the the
-//projects local store. Chunks are then decrypted and combined in the original
-//file and written to writer 'w'
+//Combine reads a pointer or a newline seperated list of chunk keys from 'r' and
+//reads each chunk from the projects local store. Chunks are then decrypted and
+//combined in the original file and written to writer 'w'. The content of
+//pointers that record chunk sizes and a whole file hash is verified while it
+//is written, if an error is returned 'w' may hold part of it and the output
+//is invalid
 func (repo *Repository) Combine(r io.Reader, w io.Writer) (err error) {
-	err = repo.ForEach(r, func(k K) error {
+	bufr := bufio.NewReader(r)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); !pointer.IsPointer(hdr) {
+		err = repo.ForEach(bufr, func(k K) error {
+			_, err := repo.combineChunk(k, w)
+			return err
+		})
 
-		//open chunk file
-		p, _ := repo.Path(k, false)
-		f, err := os.OpenFile(p, os.O_RDONLY, 0666)
 		if err != nil {
-			return fmt.Errorf("failed to open chunk '%x' locally at '%s': %v", k, p, err)
+			return fmt.Errorf("failed to loop over keys: %v", err)
 		}
 
-		//setup aes cipher
-		block, err := aes.NewCipher(k[:])
-		if err != nil {
-			return fmt.Errorf("failed to create cipher: %v", err)
-		}
+		return nil
+	}
 
-		//setup the read stream
-		//@TODO use GCM cipher mode
-		//@TODO	If the key is unique for each ciphertext, then it's ok to use a zero IV.
-		var iv [aes.BlockSize]byte
-		stream := cipher.NewOFB(block, iv[:])
-		decryptr := &cipher.StreamReader{S: stream, R: f}
+	ptr, err := pointer.Decode(bufr)
+	if err != nil {
+		return fmt.Errorf("failed to decode pointer: %v", err)
+	}
 
-		//copy chunk bytes to output
-		defer f.Close()
-		n, err := io.Copy(w, decryptr)
+	fileh := sha256.New()
+	w = io.MultiWriter(w, fileh)
+	for _, c := range ptr.Chunks {
+		n, err := repo.combineChunk(K(c.Key), w)
 		if err != nil {
-			return fmt.Errorf("failed to copy chunk '%x' content after %d bytes: %v", k, n, err)
+			return fmt.Errorf("failed to loop over keys: %v", err)
 		}
 
-		return nil
-	})
+		if c.Size >= 0 && n != c.Size {
+			return fmt.Errorf("chunk '%x' holds %d bytes, the pointer expects %d", c.Key, n, c.Size)
+		}
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to loop over keys: %v", err)
+	if ptr.Version > 1 && !bytes.Equal(fileh.Sum(nil), ptr.Hash[:]) {
+		return fmt.Errorf("combined content doesn't match the hash '%x' recorded in the pointer", ptr.Hash)
 	}
 
 	return nil
 }
+
+//combineChunk decodes the chunk with key 'k' from the local store and writes
+//its plain content to 'w'
+func (repo *Repository) combineChunk(k K, w io.Writer) (n int64, err error) {
+
+	//open chunk file
+	p, _ := repo.Path(k, false)
+	f, err := os.OpenFile(p, os.O_RDONLY, 0666)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open chunk '%x' locally at '%s': %v", k, p, err)
+	}
+
+	//decode (decrypt, decompress) and copy chunk bytes to output
+	defer f.Close()
+	n, err = readChunk(k, f, w)
+	if err != nil {
+		return n, fmt.Errorf("failed to copy chunk '%x' content after %d bytes: %v", k, n, err)
+	}
+
+	repo.keyProgressCh <- KeyOp{CombineOp, k, false, n, ""}
+	return n, nil
+}
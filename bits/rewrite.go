@@ -0,0 +1,751 @@
+package bits
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+//Rewrite describes how the trees of commits change when history is rewritten
+//by RewriteHistory
+type Rewrite struct {
+
+	//Match returns whether the blob at 'path' with 'size' bytes should be
+	//handed to Blob, it allows large blobs to be skipped without reading them
+	Match func(path string, size int64) bool
+
+	//Blob writes the new content of a matched blob to 'w', 'pol' is read
+	//from the attributes of the commit that is rewritten and holds the path
+	Blob func(pol *Policy, r io.Reader, w io.Writer) error
+
+	//Changes returns whether Blob would give a matched blob new content, it
+	//is used by PlanHistory instead of Blob such that nothing is written
+	Changes func(r io.Reader) (bool, error)
+
+	//Attributes returns the new content of the .gitattributes file at 'path',
+	//it is called with nil data for the root when the tree doesn't have one.
+	//Returning empty data removes the file
+	Attributes func(path string, data []byte) ([]byte, error)
+
+	//Remove holds the paths of files that are removed from every commit
+	Remove []string
+
+	//Candidates returns the object names of the blobs in the history of
+	//'refs' that Blob may change. When set only those blobs are matched, it
+	//allows all blobs to be checked in a single pass instead of per commit
+	Candidates func(refs []string) (map[string]bool, error)
+
+	//candidates holds the result of Candidates for the refs being rewritten
+	candidates map[string]bool
+}
+
+//matches returns whether the blob of tree entry 'e' is handed to Blob
+func (rw *Rewrite) matches(e treeEntry) bool {
+	if rw.Match == nil || !rw.Match(e.path, e.size) {
+		return false
+	}
+
+	return rw.candidates == nil || rw.candidates[e.obj]
+}
+
+//findCandidates asks the rewrite for the blobs in the history of 'refs' that
+//may change, if it can tell
+func (rw *Rewrite) findCandidates(refs []string) (err error) {
+	if rw.Candidates == nil {
+		return nil
+	}
+
+	rw.candidates, err = rw.Candidates(refs)
+	if err != nil {
+		return fmt.Errorf("failed to find the blobs to rewrite: %v", err)
+	}
+
+	return nil
+}
+
+//treeEntry is a line of `git ls-tree -r -l`
+type treeEntry struct {
+	mode string
+	typ  string
+	obj  string
+	size int64
+	path string
+}
+
+//RewriteHistory rewrites all commits reachable from 'refs' as described by
+//'rw' and moves the refs to the rewritten commits. Annotated tags are copied
+//to point to the rewritten commit. Original refs are kept under
+//'refs/original/' and each rewritten commit is written to 'w' as an
+//'<old> <new>' line. Commits that don't change keep their hash, the
+//signatures of rewritten commits and tags are dropped as they no longer
+//match. If the checked out branch is rewritten the working tree, which must
+//be clean, is reset to the new commit
+func (repo *Repository) RewriteHistory(refs []string, rw *Rewrite, w io.Writer) (err error) {
+	ctx := context.Background()
+	head := repo.gitOutput(ctx, "symbolic-ref", "-q", "HEAD")
+	names, tips, tags, err := repo.rewriteRefs(ctx, refs)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := tips[head]; ok {
+		if status := repo.gitOutput(ctx, "status", "--porcelain", "--untracked-files=no"); status != "" {
+			return fmt.Errorf("the working tree has uncommitted changes, commit or stash them before rewriting '%s'", head)
+		}
+	}
+
+	if err = rw.findCandidates(names); err != nil {
+		return err
+	}
+
+	//commits are rewritten parents first
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(ctx, nil, buf, append([]string{"rev-list", "--topo-order", "--reverse", "--parents"}, names...)...)
+	if err != nil {
+		return fmt.Errorf("failed to list commits: %v", err)
+	}
+
+	st := &rewriteState{
+		cat:   repo.catFile(ctx),
+		idx:   filepath.Join(repo.gitDir, "bits-rewrite-index"),
+		blobs: map[string]string{},
+		trees: map[string]string{},
+		pols:  map[string]map[string]*Policy{},
+	}
+
+	defer os.Remove(st.idx)
+	defer os.Remove(st.idx + ".attr")
+	defer st.cat.Close()
+
+	mapped := map[string]string{}
+	s := bufio.NewScanner(buf)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 1 {
+			continue
+		}
+
+		commit, parents := fields[0], []string{}
+		for _, p := range fields[1:] {
+			if np, ok := mapped[p]; ok {
+				p = np
+			}
+
+			parents = append(parents, p)
+		}
+
+		mapped[commit], err = repo.rewriteCommit(ctx, st, commit, fields[1:], parents, rw)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite commit '%s': %v", commit, err)
+		}
+
+		if mapped[commit] != commit {
+			fmt.Fprintf(w, "%s %s\n", commit, mapped[commit])
+		}
+	}
+
+	if err = s.Err(); err != nil {
+		return fmt.Errorf("failed to read commits: %v", err)
+	}
+
+	for _, name := range names {
+		old, nobj := tips[name], mapped[tips[name]]
+		if nobj == old {
+			continue
+		}
+
+		if tag, ok := tags[name]; ok {
+			old = tag
+			nobj, err = repo.retag(ctx, st, tag, nobj)
+			if err != nil {
+				return fmt.Errorf("failed to rewrite tag '%s': %v", name, err)
+			}
+		}
+
+		err = repo.Git(ctx, nil, nil, "update-ref", "refs/original/"+name, old)
+		if err != nil {
+			return fmt.Errorf("failed to keep original ref '%s': %v", name, err)
+		}
+
+		err = repo.Git(ctx, nil, nil, "update-ref", name, nobj, old)
+		if err != nil {
+			return fmt.Errorf("failed to move ref '%s': %v", name, err)
+		}
+
+		if name == head {
+			err = repo.Git(ctx, nil, nil, "reset", "-q", "--hard", nobj)
+			if err != nil {
+				return fmt.Errorf("failed to reset working tree to '%s': %v", nobj, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+//rewriteState holds what is shared by the commits of a single rewrite
+type rewriteState struct {
+
+	//reads the commits, tags and blobs that are rewritten
+	cat *catFile
+
+	//path of the index file the new trees are built in
+	idx string
+
+	//the rewritten blobs and trees by their original object
+	blobs map[string]string
+	trees map[string]string
+
+	//the policies of paths per set of .gitattributes files
+	pols map[string]map[string]*Policy
+}
+
+//rewriteRefs returns the full names of 'refs', the current branch if there
+//are none, and the commit each of them points to. For annotated tags 'tags'
+//holds the tag object, refs that don't point to a commit or a tag of a commit
+//can't be rewritten
+func (repo *Repository) rewriteRefs(ctx context.Context, refs []string) (names []string, tips, tags map[string]string, err error) {
+	if len(refs) == 0 {
+		refs = []string{"HEAD"}
+	}
+
+	tips, tags = map[string]string{}, map[string]string{}
+	for _, ref := range refs {
+		name := repo.gitOutput(ctx, "rev-parse", "--symbolic-full-name", ref)
+		if name == "" || !strings.HasPrefix(name, "refs/") {
+			return nil, nil, nil, fmt.Errorf("'%s' is not a branch or tag that can be rewritten", ref)
+		}
+
+		if _, ok := tips[name]; ok {
+			continue
+		}
+
+		switch typ := repo.gitOutput(ctx, "cat-file", "-t", name); typ {
+		case "commit":
+		case "tag":
+			//tags of tags, trees or blobs are not copied
+			hdr, _, _ := strings.Cut(repo.gitOutput(ctx, "cat-file", "tag", name), "\n\n")
+			if target := headerValue(hdr, "type"); target != "commit" {
+				return nil, nil, nil, fmt.Errorf("tag '%s' can't be rewritten, it points to a %s instead of a commit", name, target)
+			}
+
+			tags[name] = repo.gitOutput(ctx, "rev-parse", name)
+		default:
+			return nil, nil, nil, fmt.Errorf("'%s' can't be rewritten, it points to a %s instead of a commit", name, typ)
+		}
+
+		names = append(names, name)
+		tips[name] = repo.gitOutput(ctx, "rev-parse", name+"^{commit}")
+	}
+
+	return names, tips, tags, nil
+}
+
+//PlanHistory writes what RewriteHistory would do with 'rw' to 'w' without
+//writing objects or moving refs: each distinct blob that Changes reports
+//would get new content, with its path and size, the files that would be
+//removed and a summary
+func (repo *Repository) PlanHistory(refs []string, rw *Rewrite, w io.Writer) (err error) {
+	ctx := context.Background()
+	names, _, _, err := repo.rewriteRefs(ctx, refs)
+	if err != nil {
+		return err
+	}
+
+	if err = rw.findCandidates(names); err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(ctx, nil, buf, append([]string{"rev-list", "--topo-order", "--reverse"}, names...)...)
+	if err != nil {
+		return fmt.Errorf("failed to list commits: %v", err)
+	}
+
+	seen := map[string]bool{}
+	blobs, commits, size := 0, 0, int64(0)
+	for _, commit := range strings.Fields(buf.String()) {
+		entries, err := repo.treeEntries(ctx, commit)
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		for _, e := range entries {
+			if contains(rw.Remove, e.path) {
+				if !seen[e.path] {
+					seen[e.path] = true
+					fmt.Fprintf(w, "would remove '%s'\n", e.path)
+				}
+
+				changed = true
+				continue
+			}
+
+			if e.typ != "blob" || e.mode == "120000" || path.Base(e.path) == ".gitattributes" || !rw.matches(e) {
+				continue
+			}
+
+			changes, ok := seen[e.obj]
+			if !ok {
+				changes, err = repo.blobChanges(ctx, e.obj, rw.Changes)
+				if err != nil {
+					return fmt.Errorf("failed to read '%s' in commit '%s': %v", e.path, commit, err)
+				}
+
+				seen[e.obj] = changes
+				if changes {
+					blobs, size = blobs+1, size+e.size
+					fmt.Fprintf(w, "would rewrite '%s' (%s)\n", e.path, humanize.Bytes(uint64(e.size)))
+				}
+			}
+
+			changed = changed || changes
+		}
+
+		if changed {
+			commits++
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "would rewrite %d blobs (%s) in %d commits, and the .gitattributes files\n", blobs, humanize.Bytes(uint64(size)), commits)
+	return err
+}
+
+//blobChanges hands the content of blob 'obj' to 'fn', which may stop
+//reading once it knows whether the blob would change
+func (repo *Repository) blobChanges(ctx context.Context, obj string, fn func(r io.Reader) (bool, error)) (bool, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(repo.Git(ctx, nil, pw, "cat-file", "blob", obj))
+	}()
+
+	changes, err := fn(pr)
+	pr.Close()
+	return changes, err
+}
+
+//treeEntries lists the entries of the tree of 'commit' recursively
+func (repo *Repository) treeEntries(ctx context.Context, commit string) (entries []treeEntry, err error) {
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(ctx, nil, buf, "ls-tree", "-r", "-l", "-z", commit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tree: %v", err)
+	}
+
+	for _, line := range strings.Split(buf.String(), "\x00") {
+		tfields := strings.SplitN(line, "\t", 2)
+		fields := strings.Fields(tfields[0])
+		if len(tfields) != 2 || len(fields) != 4 {
+			continue
+		}
+
+		size, _ := strconv.ParseInt(fields[3], 10, 64)
+		entries = append(entries, treeEntry{fields[0], fields[1], fields[2], size, tfields[1]})
+	}
+
+	return entries, nil
+}
+
+//rewriteCommit writes the rewritten tree of 'commit' as a new commit with
+//'parents', if nothing changed the original commit is returned. The other
+//headers and the message are copied as is, such as the encoding, but the
+//signature is dropped
+func (repo *Repository) rewriteCommit(ctx context.Context, st *rewriteState, commit string, oldParents, parents []string, rw *Rewrite) (string, error) {
+	raw, err := st.cat.Object(commit)
+	if err != nil {
+		return "", err
+	}
+
+	hdr, msg, _ := strings.Cut(string(raw), "\n\n")
+	tree := headerValue(hdr, "tree")
+
+	//commits that share a tree, e.g. after a revert, rewrite it once
+	ntree, ok := st.trees[tree]
+	if !ok {
+		ntree, err = repo.rewriteTree(ctx, st, tree, rw)
+		if err != nil {
+			return "", err
+		}
+
+		st.trees[tree] = ntree
+	}
+
+	if ntree == tree && strings.Join(oldParents, " ") == strings.Join(parents, " ") {
+		return commit, nil
+	}
+
+	lines := []string{"tree " + ntree}
+	for _, p := range parents {
+		lines = append(lines, "parent "+p)
+	}
+
+	lines = append(lines, dropHeaders(hdr, "tree", "parent", "gpgsig", "gpgsig-sha256", "mergetag")...)
+	return repo.writeObject(ctx, "commit", strings.NewReader(strings.Join(lines, "\n")+"\n\n"+msg))
+}
+
+//rewriteTree writes the rewritten version of 'tree' and returns it, if
+//nothing changed the original tree is returned
+func (repo *Repository) rewriteTree(ctx context.Context, st *rewriteState, tree string, rw *Rewrite) (string, error) {
+	entries, err := repo.treeEntries(ctx, tree)
+	if err != nil {
+		return "", err
+	}
+
+	pols, err := repo.treePolicies(ctx, st, entries, rw)
+	if err != nil {
+		return "", err
+	}
+
+	changed := false
+	hasRootAttr := false
+	newEntries := []treeEntry{}
+	for _, e := range entries {
+		//submodules and symlinks are kept as they are
+		if e.typ != "blob" || e.mode == "120000" {
+			newEntries = append(newEntries, e)
+			continue
+		}
+
+		if e.path == ".gitattributes" {
+			hasRootAttr = true
+		}
+
+		if contains(rw.Remove, e.path) {
+			changed = true
+			continue
+		}
+
+		isAttr := rw.Attributes != nil && path.Base(e.path) == ".gitattributes"
+		if !isAttr && !rw.matches(e) {
+			newEntries = append(newEntries, e)
+			continue
+		}
+
+		//blobs are only rewritten once for the same attributes
+		pol, cachek := pols[e.path], e.path+"\x00"+e.obj
+		if !isAttr {
+			cachek += fmt.Sprintf("\x00%v", *pol)
+		}
+
+		obj, ok := st.blobs[cachek]
+		if !ok {
+			obj, err = repo.rewriteBlob(ctx, st, e, isAttr, pol, rw)
+			if err != nil {
+				return "", fmt.Errorf("failed to rewrite '%s': %v", e.path, err)
+			}
+
+			st.blobs[cachek] = obj
+		}
+
+		if obj != e.obj {
+			changed = true
+		}
+
+		if obj == "" {
+			continue //removed
+		}
+
+		e.obj = obj
+		newEntries = append(newEntries, e)
+	}
+
+	if !hasRootAttr && rw.Attributes != nil {
+		data, err := rw.Attributes(".gitattributes", nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create .gitattributes: %v", err)
+		}
+
+		if len(data) > 0 {
+			obj, err := repo.writeBlob(ctx, bytes.NewReader(data))
+			if err != nil {
+				return "", err
+			}
+
+			changed = true
+			newEntries = append(newEntries, treeEntry{"100644", "blob", obj, int64(len(data)), ".gitattributes"})
+		}
+	}
+
+	if !changed {
+		return tree, nil
+	}
+
+	//build the new tree in a separate index
+	env := []string{"GIT_INDEX_FILE=" + st.idx}
+	os.Remove(st.idx)
+	info := bytes.NewBuffer(nil)
+	for _, e := range newEntries {
+		fmt.Fprintf(info, "%s %s\t%s\x00", e.mode, e.obj, e.path)
+	}
+
+	err = repo.GitEnv(ctx, env, info, nil, "update-index", "--add", "-z", "--index-info")
+	if err != nil {
+		return "", fmt.Errorf("failed to build index: %v", err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = repo.GitEnv(ctx, env, nil, out, "write-tree")
+	if err != nil {
+		return "", fmt.Errorf("failed to write tree: %v", err)
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
+
+//treePolicies returns the policy of each entry that 'rw' matches. They are
+//read from the .gitattributes files of the tree itself, the policies of a
+//path are only checked once for the same .gitattributes files
+func (repo *Repository) treePolicies(ctx context.Context, st *rewriteState, entries []treeEntry, rw *Rewrite) (map[string]*Policy, error) {
+	attrs, matched := []treeEntry{}, []string{}
+	for _, e := range entries {
+		isAttr := path.Base(e.path) == ".gitattributes"
+		if isAttr && e.typ == "blob" {
+			attrs = append(attrs, e)
+		}
+
+		if e.typ == "blob" && e.mode != "120000" && !(isAttr && rw.Attributes != nil) && rw.matches(e) {
+			matched = append(matched, e.path)
+		}
+	}
+
+	sig := bytes.NewBuffer(nil)
+	for _, e := range attrs {
+		fmt.Fprintf(sig, "%s %s\t%s\x00", e.mode, e.obj, e.path)
+	}
+
+	pols, ok := st.pols[sig.String()]
+	if !ok {
+		pols = map[string]*Policy{}
+		st.pols[sig.String()] = pols
+	}
+
+	missing := []string{}
+	for _, p := range matched {
+		if _, ok := pols[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+
+	if len(missing) == 0 {
+		return pols, nil
+	}
+
+	//check-attr reads the .gitattributes files from an index that only
+	//holds those of the tree
+	env := []string{"GIT_INDEX_FILE=" + st.idx + ".attr"}
+	os.Remove(st.idx + ".attr")
+	err := repo.GitEnv(ctx, env, bytes.NewReader(sig.Bytes()), nil, "update-index", "--add", "-z", "--index-info")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attributes: %v", err)
+	}
+
+	checked, err := repo.checkPolicies(env, true, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	for p, pol := range checked {
+		pols[p] = pol
+	}
+
+	return pols, nil
+}
+
+//rewriteBlob writes the rewritten content of a blob entry and returns its
+//object name, empty if it should be removed. Blobs are rewritten with 'pol'
+func (repo *Repository) rewriteBlob(ctx context.Context, st *rewriteState, e treeEntry, isAttr bool, pol *Policy, rw *Rewrite) (string, error) {
+	if isAttr {
+		data, err := st.cat.Object(e.obj)
+		if err != nil {
+			return "", err
+		}
+
+		ndata, err := rw.Attributes(e.path, data)
+		if err != nil {
+			return "", err
+		}
+
+		if len(ndata) == 0 {
+			return "", nil
+		}
+
+		return repo.writeBlob(ctx, bytes.NewReader(ndata))
+	}
+
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.CloseWithError(st.cat.Stream(e.obj, func(cr io.Reader) error {
+			return rw.Blob(pol, cr, w)
+		}))
+	}()
+
+	obj, err := repo.writeBlob(ctx, r)
+	r.Close()
+	<-done
+	return obj, err
+}
+
+//retag writes a copy of annotated 'tag' that points to 'commit', the
+//signature of a signed tag is dropped
+func (repo *Repository) retag(ctx context.Context, st *rewriteState, tag, commit string) (string, error) {
+	raw, err := st.cat.Object(tag)
+	if err != nil {
+		return "", err
+	}
+
+	hdr, msg, _ := strings.Cut(string(raw), "\n\n")
+	for _, marker := range []string{"-----BEGIN PGP SIGNATURE-----", "-----BEGIN SSH SIGNATURE-----", "-----BEGIN SIGNED MESSAGE-----"} {
+		if i := strings.Index("\n"+msg, "\n"+marker); i >= 0 {
+			msg = msg[:i]
+		}
+	}
+
+	lines := append([]string{"object " + commit}, dropHeaders(hdr, "object", "gpgsig", "gpgsig-sha256")...)
+	return repo.writeObject(ctx, "tag", strings.NewReader(strings.Join(lines, "\n")+"\n\n"+msg))
+}
+
+//headerValue returns the value of the first header 'name' in the headers
+//'hdr' of a raw commit or tag
+func headerValue(hdr, name string) string {
+	for _, line := range strings.Split(hdr, "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			return strings.TrimPrefix(line, name+" ")
+		}
+	}
+
+	return ""
+}
+
+//dropHeaders returns the lines of the headers 'hdr' of a raw commit or tag
+//without the headers in 'names' and the lines that continue them
+func dropHeaders(hdr string, names ...string) (lines []string) {
+	drop := false
+	for _, line := range strings.Split(hdr, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			name, _, _ := strings.Cut(line, " ")
+			drop = contains(names, name)
+		}
+
+		if !drop {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+//writeBlob writes the content of 'r' to the object database as is, without
+//running any filters
+func (repo *Repository) writeBlob(ctx context.Context, r io.Reader) (string, error) {
+	return repo.writeObject(ctx, "blob", r)
+}
+
+//writeObject writes the content of 'r' to the object database as an object
+//of type 'typ' and returns its name
+func (repo *Repository) writeObject(ctx context.Context, typ string, r io.Reader) (string, error) {
+	out := bytes.NewBuffer(nil)
+	err := repo.Git(ctx, r, out, "hash-object", "-t", typ, "-w", "--no-filters", "--stdin")
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", typ, err)
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
+
+//catFile reads objects through a single `git cat-file --batch` process
+type catFile struct {
+	w    *io.PipeWriter
+	bufr *bufio.Reader
+	done chan error
+}
+
+//catFile starts reading objects, the reader must be closed
+func (repo *Repository) catFile(ctx context.Context) *catFile {
+	ir, iw := io.Pipe()
+	or, ow := io.Pipe()
+	c := &catFile{w: iw, bufr: bufio.NewReader(or), done: make(chan error, 1)}
+	go func() {
+		err := repo.Git(ctx, ir, ow, "cat-file", "--batch")
+		ir.CloseWithError(err)
+		ow.CloseWithError(err)
+		c.done <- err
+	}()
+
+	return c
+}
+
+//Object returns the content of object 'obj'
+func (c *catFile) Object(obj string) (data []byte, err error) {
+	err = c.Stream(obj, func(r io.Reader) (err error) {
+		data, err = io.ReadAll(r)
+		return err
+	})
+
+	return data, err
+}
+
+//Stream hands the content of object 'obj' to 'fn', content that it doesn't
+//read is skipped
+func (c *catFile) Stream(obj string, fn func(r io.Reader) error) (err error) {
+	_, err = fmt.Fprintf(c.w, "%s\n", obj)
+	if err != nil {
+		return fmt.Errorf("failed to request object '%s': %v", obj, err)
+	}
+
+	//cat-file outputs a '<object> <type> <size>' line followed by the content
+	hdr, err := c.bufr.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read object '%s': %v", obj, err)
+	}
+
+	fields := strings.Fields(hdr)
+	if len(fields) != 3 {
+		return fmt.Errorf("failed to read object '%s': %s", obj, strings.TrimSpace(hdr))
+	}
+
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("unexpected object size in '%s': %v", strings.TrimSpace(hdr), err)
+	}
+
+	lr := &io.LimitedReader{R: c.bufr, N: size}
+	err = fn(lr)
+
+	//content that wasn't read and the newline that follows it are skipped
+	_, derr := io.Copy(io.Discard, io.LimitReader(c.bufr, lr.N+1))
+	if err == nil && derr != nil {
+		err = fmt.Errorf("failed to read object '%s': %v", obj, derr)
+	}
+
+	return err
+}
+
+//Close stops the cat-file process
+func (c *catFile) Close() error {
+	c.w.Close()
+	io.Copy(io.Discard, c.bufr)
+	return <-c.done
+}
+
+//gitOutput runs git and returns its trimmed output, empty if it failed
+func (repo *Repository) gitOutput(ctx context.Context, args ...string) string {
+	buf := bytes.NewBuffer(nil)
+	err := repo.Git(ctx, nil, buf, args...)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(buf.String())
+}
@@ -6,12 +6,23 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
+//PackPrefix is the key prefix under which packs and their index are stored
+var PackPrefix = "packs/"
+
+//ProbePrefix is the key prefix under which probes are stored, it keeps them
+//out of the chunk listing
+var ProbePrefix = "probes/"
+
 type S3Remote struct {
 	gitRemote  string
 	bucketName string
@@ -21,21 +32,25 @@ type S3Remote struct {
 
 func NewS3Remote(repo *Repository, remote, bucket string) (s3Remote *S3Remote, err error) {
 	ctx := context.Background()
-	
-	// Load AWS configuration using standard AWS SDK configuration chain:
-	// 1. Environment variables (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, etc.)
-	// 2. Shared credentials file (~/.aws/credentials)
-	// 3. Shared config file (~/.aws/config)
-	// 4. IAM roles for EC2/ECS/Lambda
-	cfg, err := config.LoadDefaultConfig(ctx)
+	conf := DefaultConf()
+	if repo != nil && repo.conf != nil {
+		conf = repo.conf
+	}
+
+	cfg, err := awsConfig(ctx, conf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+		return nil, err
 	}
 
 	// Create S3 client - AWS SDK automatically handles AWS_ENDPOINT_URL
 	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
 		// Always use path-style addressing for compatibility with LocalStack and AWS S3
 		o.UsePathStyle = true
+
+		//an endpoint configured for the repository takes precedence
+		if conf.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(conf.S3Endpoint)
+		}
 	})
 
 	return &S3Remote{
@@ -46,6 +61,42 @@ func NewS3Remote(repo *Repository, remote, bucket string) (s3Remote *S3Remote, e
 	}, nil
 }
 
+//awsConfig loads the AWS configuration using the standard AWS SDK
+//configuration chain: environment variables, the shared configuration files
+//and IAM roles for EC2/ECS/Lambda. The profile, region and static keys of
+//'conf' take precedence, if a role is configured it is assumed with the
+//credentials that the chain provides
+func awsConfig(ctx context.Context, conf *Conf) (cfg aws.Config, err error) {
+	opts := []func(*config.LoadOptions) error{}
+	if conf.AWSProfile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(conf.AWSProfile))
+	}
+
+	if conf.AWSRegion != "" {
+		opts = append(opts, config.WithRegion(conf.AWSRegion))
+	}
+
+	//a key without its secret is not usable, the chain is used instead
+	if conf.AWSAccessKeyID != "" && conf.AWSSecretAccessKey != "" {
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(conf.AWSAccessKeyID, conf.AWSSecretAccessKey, ""),
+		))
+	}
+
+	cfg, err = config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+
+	if conf.AWSRoleARN != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), conf.AWSRoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "git-bits"
+		}))
+	}
+
+	return cfg, nil
+}
+
 func (s3 *S3Remote) Name() string {
 	return s3.gitRemote
 }
@@ -54,9 +105,11 @@ func (s3 *S3Remote) Name() string {
 func (s *S3Remote) ListChunks(w io.Writer) (err error) {
 	ctx := context.Background()
 	
+	//the delimiter keeps pack objects out of the listing
 	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
-		Bucket:  aws.String(s.bucketName),
-		MaxKeys: aws.Int32(500),
+		Bucket:    aws.String(s.bucketName),
+		MaxKeys:   aws.Int32(500),
+		Delimiter: aws.String("/"),
 	})
 
 	for paginator.HasMorePages() {
@@ -77,19 +130,100 @@ func (s *S3Remote) ListChunks(w io.Writer) (err error) {
 	return nil
 }
 
+//ListPacks will write the key of each pack with an index in the bucket to writer w
+func (s *S3Remote) ListPacks(w io.Writer) (err error) {
+	ctx := context.Background()
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucketName),
+		Prefix:  aws.String(PackPrefix),
+		MaxKeys: aws.Int32(500),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %v", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := strings.TrimPrefix(aws.ToString(obj.Key), PackPrefix)
+
+			//a pack is only complete once its index is written
+			if strings.HasSuffix(key, ".idx") && len(key) == hex.EncodedLen(KeySize)+len(".idx") {
+				fmt.Fprintf(w, "%s\n", strings.TrimSuffix(key, ".idx"))
+			}
+		}
+	}
+
+	return nil
+}
+
+//ListProbes will write the name of each probe in the bucket to writer w
+func (s *S3Remote) ListProbes(w io.Writer) (err error) {
+	ctx := context.Background()
+
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucketName),
+		Prefix:  aws.String(ProbePrefix),
+		MaxKeys: aws.Int32(500),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %v", err)
+		}
+
+		for _, obj := range page.Contents {
+			fmt.Fprintf(w, "%s\n", strings.TrimPrefix(aws.ToString(obj.Key), ProbePrefix))
+		}
+	}
+
+	return nil
+}
+
 //ChunkReader returns a file handle that the chunk with the given
 //key can be read from, the user is expected to close it when finished
 func (s *S3Remote) ChunkReader(k K) (rc io.ReadCloser, err error) {
+	return s.objectReader(fmt.Sprintf("%x", k), "")
+}
+
+//PackReader returns a file handle that reads 'n' bytes from the pack with
+//the given key, starting at offset 'off'
+func (s *S3Remote) PackReader(p K, off, n int64) (rc io.ReadCloser, err error) {
+	return s.objectReader(fmt.Sprintf("%s%x.pack", PackPrefix, p), fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+}
+
+//PackIndexReader returns a file handle that the index of pack 'p' can be read from
+func (s *S3Remote) PackIndexReader(p K) (rc io.ReadCloser, err error) {
+	return s.objectReader(fmt.Sprintf("%s%x.idx", PackPrefix, p), "")
+}
+
+//ProbeReader returns a file handle that the probe with 'name' can be read from
+func (s *S3Remote) ProbeReader(name string) (rc io.ReadCloser, err error) {
+	return s.objectReader(ProbePrefix+name, "")
+}
+
+//objectReader gets an object from the bucket, optionally limited to the
+//http byte range 'rng'
+func (s *S3Remote) objectReader(key, rng string) (rc io.ReadCloser, err error) {
 	ctx := context.Background()
-	
-	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(s.bucketName),
-		Key:    aws.String(fmt.Sprintf("%x", k)),
-	})
+		Key:    aws.String(key),
+	}
+
+	if rng != "" {
+		input.Range = aws.String(rng)
+	}
+
+	resp, err := s.client.GetObject(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object: %v", err)
 	}
-	
+
 	return resp.Body, nil
 }
 
@@ -126,3 +260,70 @@ func (s *S3Remote) ChunkWriter(k K) (wc io.WriteCloser, err error) {
 		buffer:     make([]byte, 0),
 	}, nil
 }
+
+//PackWriter returns a file handle to which the pack with key 'p' can be
+//written, the user is expected to close it when finished.
+func (s *S3Remote) PackWriter(p K) (wc io.WriteCloser, err error) {
+	return &chunkWriter{
+		client:     s.client,
+		bucketName: s.bucketName,
+		key:        fmt.Sprintf("%s%x.pack", PackPrefix, p),
+		buffer:     make([]byte, 0),
+	}, nil
+}
+
+//PackIndexWriter returns a file handle to which the index of pack 'p' can
+//be written, the user is expected to close it when finished.
+func (s *S3Remote) PackIndexWriter(p K) (wc io.WriteCloser, err error) {
+	return &chunkWriter{
+		client:     s.client,
+		bucketName: s.bucketName,
+		key:        fmt.Sprintf("%s%x.idx", PackPrefix, p),
+		buffer:     make([]byte, 0),
+	}, nil
+}
+
+//ProbeWriter returns a file handle to which the probe with 'name' can be
+//written, the user is expected to close it when finished.
+func (s *S3Remote) ProbeWriter(name string) (wc io.WriteCloser, err error) {
+	return &chunkWriter{
+		client:     s.client,
+		bucketName: s.bucketName,
+		key:        ProbePrefix + name,
+		buffer:     make([]byte, 0),
+	}, nil
+}
+
+//DeleteProbe removes the probe with 'name' from the bucket
+func (s *S3Remote) DeleteProbe(name string) (err error) {
+	_, err = s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucketName),
+		Key:    aws.String(ProbePrefix + name),
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %v", err)
+	}
+
+	return nil
+}
+
+//checkCredentials checks that AWS credentials are available to the remote
+func (s *S3Remote) checkCredentials(ctx context.Context) Check {
+	fix := "set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, configure ~/.aws/credentials or select a profile with 'git bits config set aws-profile <profile>'"
+	if conf := s.repo.conf; (conf.AWSAccessKeyID == "") != (conf.AWSSecretAccessKey == "") {
+		return Check{"credentials", CheckWarning, "only one of bits.aws-access-key-id and bits.aws-secret-access-key is configured, both are ignored", "configure both with 'git bits config set' or unset the other"}
+	}
+
+	creds := s.client.Options().Credentials
+	if creds == nil {
+		return Check{"credentials", CheckFailed, "no AWS credentials are configured", fix}
+	}
+
+	c, err := creds.Retrieve(ctx)
+	if err != nil {
+		return Check{"credentials", CheckFailed, fmt.Sprintf("failed to retrieve AWS credentials: %v", err), fix}
+	}
+
+	return Check{"credentials", CheckOK, fmt.Sprintf("AWS credentials are provided by %s", c.Source), ""}
+}
@@ -2,7 +2,13 @@ package bits
 
 import (
 	"bytes"
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 )
 
 func TestS3RemoteName(t *testing.T) {
@@ -53,4 +59,73 @@ func TestNewS3RemoteValidation(t *testing.T) {
 	if err != nil {
 		t.Errorf("NewS3Remote should not fail: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+func TestAWSConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config")
+	err := os.WriteFile(cfgFile, []byte("[profile other]\nregion = eu-west-1\naws_access_key_id = PROFILEKEY\naws_secret_access_key = profilesecret\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AWS_CONFIG_FILE", cfgFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_REGION", "")
+	ctx := context.Background()
+
+	conf := DefaultConf()
+	conf.AWSProfile = "other"
+	cfg, err := awsConfig(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil || creds.AccessKeyID != "PROFILEKEY" || cfg.Region != "eu-west-1" {
+		t.Errorf("expected the credentials and region of the profile, got: %s, %s, %v", creds.AccessKeyID, cfg.Region, err)
+	}
+
+	//static keys and the region take precedence over the profile
+	conf.AWSAccessKeyID, conf.AWSSecretAccessKey, conf.AWSRegion = "STATICKEY", "staticsecret", "us-east-2"
+	cfg, err = awsConfig(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err = cfg.Credentials.Retrieve(ctx)
+	if err != nil || creds.AccessKeyID != "STATICKEY" || cfg.Region != "us-east-2" {
+		t.Errorf("expected the configured keys and region, got: %s, %s, %v", creds.AccessKeyID, cfg.Region, err)
+	}
+
+	conf.AWSRoleARN = "arn:aws:iam::123456789012:role/bits"
+	cfg, err = awsConfig(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !aws.IsCredentialsProvider(cfg.Credentials, (*stscreds.AssumeRoleProvider)(nil)) {
+		t.Errorf("expected the role to be assumed, got: %T", cfg.Credentials)
+	}
+
+	conf.AWSProfile = "missing"
+	if _, err = awsConfig(ctx, conf); err == nil {
+		t.Errorf("expected a profile that doesn't exist to fail")
+	}
+}
+
+func TestS3RemoteEndpoint(t *testing.T) {
+	repo := &Repository{conf: DefaultConf()}
+	repo.conf.S3Endpoint = "http://localhost:4566"
+	s3r, err := NewS3Remote(repo, "origin", "test-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ep := aws.ToString(s3r.client.Options().BaseEndpoint); ep != "http://localhost:4566" {
+		t.Errorf("expected the configured endpoint, got: %s", ep)
+	}
+}
@@ -0,0 +1,85 @@
+package bits
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//SkipSmudgeEnv is the environment variable that makes the smudge filter write
+//pointers as is when set to a true value, like the 'bits.skip-smudge' config
+const SkipSmudgeEnv = "GIT_BITS_SKIP_SMUDGE"
+
+//SkipSmudge returns whether the smudge filter writes pointers as is, the
+//environment variable takes precedence over the configuration. Like the
+//configuration, a value that isn't a boolean is an error
+func (repo *Repository) SkipSmudge() (bool, error) {
+	if v := os.Getenv(SkipSmudgeEnv); v != "" {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("unexpected format for %s '%v', expected a boolean", SkipSmudgeEnv, v)
+		}
+
+		return skip, nil
+	}
+
+	return repo.conf.SkipSmudge, nil
+}
+
+//Smudge writes the content of the pointer read from 'r' for the file at path
+//'p' to 'w', chunks are fetched as needed. Paths that are not selected by the
+//fetch filter and content that isn't a pointer are written as is, as is any
+//pointer if smudging is skipped. Content is combined into a temporary file
+//first, such that nothing is written to 'w' if it can't be verified
+func (repo *Repository) Smudge(p string, r io.Reader, w io.Writer) (err error) {
+	filter, err := repo.FetchFilter()
+	if err != nil {
+		return err
+	}
+
+	skip, err := repo.SkipSmudge()
+	if err != nil {
+		return err
+	}
+
+	bufr := bufio.NewReader(r)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); !pointer.IsPointer(hdr) || !filter.Match(p) || skip {
+		_, err = io.Copy(w, bufr)
+		return err
+	}
+
+	pol, err := repo.Policy(p)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(repo.fetchFrom(pol.Remote, pol.Path, bufr, pw))
+	}()
+
+	defer pr.Close()
+	tmpf, err := os.CreateTemp(repo.gitDir, ".bits_tmp_")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmpf.Name())
+	defer tmpf.Close()
+	err = repo.Combine(pr, tmpf)
+	if err != nil {
+		return fmt.Errorf("failed to combine '%s': %v", p, err)
+	}
+
+	_, err = tmpf.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, tmpf)
+	return err
+}
@@ -0,0 +1,121 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSmudgeFetchFilter(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	repo.conf.FetchExclude = []string{"*.psd"}
+
+	data := make([]byte, 16*1024)
+	rand.Read(data)
+	ptr := bytes.NewBuffer(nil)
+	err := repo.Split(bytes.NewReader(data), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, exp := range map[string][]byte{"a.bin": data, "art/a.psd": ptr.Bytes()} {
+		out := bytes.NewBuffer(nil)
+		err = repo.Smudge(p, bytes.NewReader(ptr.Bytes()), out)
+		if err != nil || !bytes.Equal(out.Bytes(), exp) {
+			t.Errorf("unexpected smudge output for '%s' (%d bytes): %v", p, out.Len(), err)
+		}
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = repo.Smudge("a.bin", bytes.NewReader([]byte("not a pointer")), out)
+	if err != nil || out.String() != "not a pointer" {
+		t.Errorf("expected content to be written as is, got '%s': %v", out.String(), err)
+	}
+}
+
+func TestPullWith(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	writeAttributes(t, repo, "*.bin filter=bits")
+
+	contents := map[string][]byte{}
+	for _, name := range []string{"a.bin", "models/b.bin", "models/latest/c.bin"} {
+		contents[name] = make([]byte, 16*1024)
+		rand.Read(contents[name])
+		os.MkdirAll(filepath.Dir(filepath.Join(repo.rootDir, name)), 0777)
+		os.WriteFile(filepath.Join(repo.rootDir, name), contents[name], 0666)
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+	for name := range contents {
+		os.WriteFile(filepath.Join(repo.rootDir, name), []byte(testGit(t, repo, "show", ":"+name)), 0666)
+	}
+
+	filter, err := NewPathFilter(nil, []string{"latest/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.PullWith("HEAD", []string{"models"}, filter, bytes.NewBuffer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, exp := range map[string]bool{"a.bin": false, "models/b.bin": true, "models/latest/c.bin": false} {
+		if data, _ := os.ReadFile(filepath.Join(repo.rootDir, name)); bytes.Equal(data, contents[name]) != exp {
+			t.Errorf("expected '%s' to be combined: %v", name, exp)
+		}
+	}
+}
+
+func TestPullWithRef(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	writeAttributes(t, repo, "*.bin filter=bits")
+
+	data := make([]byte, 16*1024)
+	rand.Read(data)
+	os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), data, 0666)
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+	testGit(t, repo, "branch", "other")
+
+	//the other branch changes a.bin and adds b.bin
+	testGit(t, repo, "checkout", "-q", "other")
+	for _, name := range []string{"a.bin", "b.bin"} {
+		other := make([]byte, 16*1024)
+		rand.Read(other)
+		os.WriteFile(filepath.Join(repo.rootDir, name), other, 0666)
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c1")
+	testGit(t, repo, "checkout", "-q", "-")
+	ptr := testGit(t, repo, "show", ":a.bin")
+	os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), []byte(ptr), 0666)
+
+	err := repo.PullWith("other", nil, nil, bytes.NewBuffer(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cur, _ := os.ReadFile(filepath.Join(repo.rootDir, "a.bin")); string(cur) != ptr {
+		t.Errorf("expected a.bin to keep the pointer of the checked out commit")
+	}
+
+	if _, err = os.Stat(filepath.Join(repo.rootDir, "b.bin")); !os.IsNotExist(err) {
+		t.Errorf("expected b.bin that only exists in the other branch not to be created: %v", err)
+	}
+
+	err = repo.PullWith("HEAD", nil, nil, bytes.NewBuffer(nil))
+	if cur, _ := os.ReadFile(filepath.Join(repo.rootDir, "a.bin")); err != nil || !bytes.Equal(cur, data) {
+		t.Errorf("expected a.bin to be combined from HEAD: %v", err)
+	}
+
+	if err = repo.PullWith("no-such-ref", nil, nil, bytes.NewBuffer(nil)); err == nil {
+		t.Errorf("expected an unknown ref to fail")
+	}
+}
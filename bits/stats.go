@@ -0,0 +1,375 @@
+package bits
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/nerdalize/git-bits/pointer"
+	bolt "go.etcd.io/bbolt"
+)
+
+//OtherPattern is the pattern under which Stats counts files that no tracked
+//pattern matches, e.g. because the pattern was removed
+const OtherPattern = "(other)"
+
+//SizeBucket counts the chunks with a size up to 'Max' bytes that are larger
+//than the previous bucket
+type SizeBucket struct {
+	Max    int64
+	Chunks int
+}
+
+//PatternStats are the statistics of the files that a tracked pattern matches
+type PatternStats struct {
+	Pattern      string
+	Versions     int
+	LogicalBytes int64
+	UniqueChunks int
+	UniqueBytes  int64
+}
+
+//Stats describe how well the content of tracked files is deduplicated
+type Stats struct {
+
+	//distinct pointers, i.e. versions of tracked files, and the size of
+	//their content
+	Versions     int
+	LogicalBytes int64
+
+	//distinct chunks of all versions and their size, the size of some
+	//chunks may not be known
+	UniqueChunks  int
+	UniqueBytes   int64
+	UnknownChunks int
+
+	//chunks whose size is estimated by their stored size in a pack, which
+	//is larger or smaller than their content when encoded
+	EstimatedChunks int
+
+	//chunk sizes in power of two buckets
+	Histogram []SizeBucket
+
+	//statistics per tracked pattern
+	Patterns []*PatternStats
+
+	//chunks in the local store and the bytes they take up
+	LocalChunks int
+	LocalBytes  int64
+
+	//objects stored on the default and named remotes, -1 if they weren't
+	//counted
+	RemoteChunks int
+	RemotePacks  int
+}
+
+//DedupRatio returns how many bytes of content are stored per byte of unique
+//chunks, zero if nothing is stored
+func (s *Stats) DedupRatio() float64 {
+	if s.UniqueBytes == 0 {
+		return 0
+	}
+
+	return float64(s.LogicalBytes) / float64(s.UniqueBytes)
+}
+
+//Stats computes the deduplication statistics of the pointers in the commits
+//listed by rev-list for 'revs'. Chunk sizes come from the pointer, the
+//decoded chunk in the local store or, as an estimate, the pack location in
+//the local index of a remote. If 'remote' is set the objects on the default
+//and named remotes are counted
+func (repo *Repository) Stats(revs []string, remote bool) (stats *Stats, err error) {
+	tracked, err := repo.TrackedPatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	//the last pattern that matches a path determines its attributes
+	matchers := []func(string) bool{}
+	stats = &Stats{RemoteChunks: -1, RemotePacks: -1}
+	for _, tp := range tracked {
+		f, err := NewPathFilter([]string{tp.Pattern}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		dir := path.Dir(tp.File)
+		matchers = append(matchers, func(p string) bool {
+			if dir != "." {
+				if !strings.HasPrefix(p, dir+"/") {
+					return false
+				}
+
+				p = strings.TrimPrefix(p, dir+"/")
+			}
+
+			return f.Match(p)
+		})
+
+		stats.Patterns = append(stats.Patterns, &PatternStats{Pattern: path.Join(dir, tp.Pattern)})
+	}
+
+	//v1 pointers don't record sizes, the size of their versions is known
+	//once the sizes of their chunks are resolved
+	type version struct {
+		ps   *PatternStats
+		size int64
+		keys []K
+	}
+
+	other := &PatternStats{Pattern: OtherPattern}
+	sizes := map[K]int64{}
+	versions := []version{}
+	patternKeys := map[*PatternStats]map[K]bool{}
+	err = repo.scanPointers(revs, func(obj, p string, data []byte) error {
+		ptr, err := pointer.Decode(bytes.NewReader(data))
+		if err != nil {
+			fmt.Fprintf(repo.output, "skipping blob '%s' that is not a valid pointer: %v\n", obj, err)
+			return nil
+		}
+
+		ps := other
+		for i := len(matchers) - 1; i >= 0; i-- {
+			if matchers[i](p) {
+				ps = stats.Patterns[i]
+				break
+			}
+		}
+
+		if patternKeys[ps] == nil {
+			patternKeys[ps] = map[K]bool{}
+		}
+
+		v := version{ps: ps, size: ptr.Size}
+		for _, c := range ptr.Chunks {
+			k := K(c.Key)
+			if _, ok := sizes[k]; !ok || sizes[k] < 0 {
+				sizes[k] = c.Size
+			}
+
+			patternKeys[ps][k] = true
+			v.keys = append(v.keys, k)
+		}
+
+		versions = append(versions, v)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	//sizes that the pointers don't record are looked up locally
+	var store *bolt.DB
+	names := append([]string{""}, repo.namedRemotes()...)
+	for k, size := range sizes {
+		if size >= 0 {
+			continue
+		}
+
+		if store == nil {
+			store, err = repo.LocalStore()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open local store: %v", err)
+			}
+
+			defer store.Close()
+		}
+
+		estimated := false
+		sizes[k], estimated = repo.storedSize(store, names, k)
+		if estimated {
+			stats.EstimatedChunks++
+		}
+	}
+
+	for _, v := range versions {
+		if v.size < 0 {
+			v.size = 0
+			for _, k := range v.keys {
+				v.size += max(sizes[k], 0)
+			}
+		}
+
+		stats.Versions++
+		stats.LogicalBytes += v.size
+		v.ps.Versions++
+		v.ps.LogicalBytes += v.size
+	}
+
+	for _, size := range sizes {
+		stats.UniqueChunks++
+		if size < 0 {
+			stats.UnknownChunks++
+			continue
+		}
+
+		stats.UniqueBytes += size
+		stats.Histogram = addToHistogram(stats.Histogram, size)
+	}
+
+	if len(patternKeys[other]) > 0 {
+		stats.Patterns = append(stats.Patterns, other)
+	}
+
+	for ps, keys := range patternKeys {
+		ps.UniqueChunks = len(keys)
+		for k := range keys {
+			ps.UniqueBytes += max(sizes[k], 0)
+		}
+	}
+
+	err = filepath.Walk(repo.chunkDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() && fi.Name() == "packs" {
+			return filepath.SkipDir
+		}
+
+		if !fi.IsDir() && len(fi.Name()) == 2*(KeySize-2) {
+			stats.LocalChunks++
+			stats.LocalBytes += fi.Size()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk local chunks: %v", err)
+	}
+
+	if !remote || (repo.remote == nil && len(names) == 1) {
+		return stats, nil
+	}
+
+	stats.RemoteChunks, stats.RemotePacks = 0, 0
+	for _, name := range names {
+		if name == "" && repo.remote == nil {
+			continue
+		}
+
+		r, err := repo.chunkRemote(name)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := countLines(r.ListChunks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list remote chunks: %v", err)
+		}
+
+		stats.RemoteChunks += n
+		n, err = countLines(r.ListPacks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list remote packs: %v", err)
+		}
+
+		stats.RemotePacks += n
+	}
+
+	return stats, nil
+}
+
+//storedSize returns the size of the content of chunk 'k' by decoding it from
+//the local store. Otherwise the length of its location in a pack on one of
+//the remotes with 'names', as recorded in 'store', is returned as an
+//estimate. The size is -1 if unknown
+func (repo *Repository) storedSize(store *bolt.DB, names []string, k K) (size int64, estimated bool) {
+	p, _ := repo.Path(k, false)
+	if f, err := os.Open(p); err == nil {
+		defer f.Close()
+		if size, err = readChunk(k, f, io.Discard); err == nil {
+			return size, false
+		}
+	}
+
+	size = -1
+	store.View(func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if b := tx.Bucket(indexBucket(name)); b != nil {
+				if loc, ok := decodePackLoc(b.Get(k[:])); ok {
+					size, estimated = loc.Length, true
+					return nil
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return size, estimated
+}
+
+//addToHistogram counts a chunk of 'size' bytes in the power of two bucket
+//that holds it, buckets are added as needed
+func addToHistogram(hist []SizeBucket, size int64) []SizeBucket {
+	max := int64(1)
+	for max < size {
+		max *= 2
+	}
+
+	i := sort.Search(len(hist), func(i int) bool { return hist[i].Max >= max })
+	if i == len(hist) || hist[i].Max != max {
+		hist = append(hist, SizeBucket{})
+		copy(hist[i+1:], hist[i:])
+		hist[i] = SizeBucket{Max: max}
+	}
+
+	hist[i].Chunks++
+	return hist
+}
+
+//countLines returns the number of lines that 'fn' writes
+func countLines(fn func(io.Writer) error) (n int, err error) {
+	buf := bytes.NewBuffer(nil)
+	err = fn(buf)
+	return bytes.Count(buf.Bytes(), []byte("\n")), err
+}
+
+//Write writes the statistics in a human readable form
+func (s *Stats) Write(w io.Writer) (err error) {
+	fmt.Fprintf(w, "file versions: %d (%s)\n", s.Versions, humanize.IBytes(uint64(s.LogicalBytes)))
+	fmt.Fprintf(w, "unique chunks: %d (%s)", s.UniqueChunks, humanize.IBytes(uint64(s.UniqueBytes)))
+	if s.UnknownChunks > 0 {
+		fmt.Fprintf(w, ", the size of %d chunks is unknown", s.UnknownChunks)
+	}
+
+	if s.EstimatedChunks > 0 {
+		fmt.Fprintf(w, ", the size of %d chunks is estimated by their size in a pack", s.EstimatedChunks)
+	}
+
+	fmt.Fprintf(w, "\ndeduplication ratio: %.2f", s.DedupRatio())
+	if s.EstimatedChunks > 0 {
+		fmt.Fprintf(w, " (estimated)")
+	}
+
+	fmt.Fprintf(w, "\n")
+	if len(s.Histogram) > 0 {
+		fmt.Fprintf(w, "\nchunk sizes:\n")
+		for _, b := range s.Histogram {
+			fmt.Fprintf(w, "  <= %-9s %d\n", humanize.IBytes(uint64(b.Max)), b.Chunks)
+		}
+	}
+
+	if len(s.Patterns) > 0 {
+		fmt.Fprintf(w, "\npatterns:\n")
+		for _, ps := range s.Patterns {
+			fmt.Fprintf(w, "  %s: %d versions (%s), %d unique chunks (%s)\n", ps.Pattern, ps.Versions, humanize.IBytes(uint64(ps.LogicalBytes)), ps.UniqueChunks, humanize.IBytes(uint64(ps.UniqueBytes)))
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "\nlocal store: %d chunks (%s)\n", s.LocalChunks, humanize.IBytes(uint64(s.LocalBytes)))
+	if s.RemoteChunks >= 0 {
+		_, err = fmt.Fprintf(w, "remote: %d chunk objects, %d packs\n", s.RemoteChunks, s.RemotePacks)
+	}
+
+	return err
+}
@@ -0,0 +1,157 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nerdalize/git-bits/pointer"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStats(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	writeAttributes(t, repo, "*.bin filter=bits\n*.dat filter=bits")
+
+	data := make([]byte, 16*1024)
+	rand.Read(data)
+	for i, name := range []string{"a.bin", "b.bin", "c.dat"} {
+		err := os.WriteFile(filepath.Join(repo.rootDir, name), data[:len(data)-i*1024], 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+
+	//a second version of a file that is identical to another file
+	os.WriteFile(filepath.Join(repo.rootDir, "b.bin"), data, 0666)
+	testGit(t, repo, "commit", "-am", "c1")
+
+	stats, err := repo.Stats([]string{"--all"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Versions != 3 || stats.LogicalBytes != 16*1024+15*1024+14*1024 {
+		t.Errorf("unexpected versions: %d (%d bytes)", stats.Versions, stats.LogicalBytes)
+	}
+
+	if stats.UniqueChunks != 3 || stats.UniqueBytes != stats.LogicalBytes || stats.UnknownChunks != 0 {
+		t.Errorf("unexpected unique chunks: %d (%d bytes)", stats.UniqueChunks, stats.UniqueBytes)
+	}
+
+	if len(stats.Histogram) != 1 || stats.Histogram[0].Max != 16*1024 || stats.Histogram[0].Chunks != 3 {
+		t.Errorf("unexpected histogram: %v", stats.Histogram)
+	}
+
+	if len(stats.Patterns) != 2 || stats.Patterns[0].Versions != 2 || stats.Patterns[1].Versions != 1 {
+		t.Errorf("unexpected patterns: %+v %+v", stats.Patterns[0], stats.Patterns[1])
+	}
+
+	if stats.LocalChunks != 3 || stats.RemoteChunks != 0 || stats.RemotePacks != 0 {
+		t.Errorf("unexpected store counts: %d local, %d remote chunks, %d packs", stats.LocalChunks, stats.RemoteChunks, stats.RemotePacks)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = stats.Write(out)
+	if err != nil || !strings.Contains(out.String(), "deduplication ratio: 1.00") {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+}
+
+func TestStatsV1Pointers(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	data := bytes.Repeat([]byte("compressible content "), 1024)
+	ptr := bytes.NewBuffer(nil)
+	err := repo.SplitWith(&Policy{Compress: true}, bytes.NewReader(data), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//version 1 pointers only list keys, sizes come from the local store
+	decoded, err := pointer.Decode(ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1 := bytes.NewBuffer(nil)
+	decoded.Version = 1
+	err = repo.writePointer(decoded, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{"a.bin", "b.bin"} {
+		os.WriteFile(filepath.Join(repo.rootDir, name), v1.Bytes(), 0666)
+		testGit(t, repo, "add", name)
+		testGit(t, repo, "commit", "-m", fmt.Sprintf("c%d", i))
+	}
+
+	stats, err := repo.Stats([]string{"--all"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Versions != 1 || stats.UnknownChunks != 0 || stats.EstimatedChunks != 0 || stats.LogicalBytes != stats.UniqueBytes || stats.LogicalBytes != int64(len(data)) {
+		t.Errorf("expected the size of the compressed chunks to be decoded, got %d versions of %d bytes, %d unique bytes", stats.Versions, stats.LogicalBytes, stats.UniqueBytes)
+	}
+
+	if stats.DedupRatio() != 1 {
+		t.Errorf("expected a deduplication ratio of 1, got: %.2f", stats.DedupRatio())
+	}
+
+	//chunks that are only in a pack on a named remote have an estimated size
+	public := newMemRemote()
+	repo.remotes = map[string]Remote{"public": public}
+	store, err := repo.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range decoded.Chunks {
+		k := K(c.Key)
+		public.objects[fmt.Sprintf("chunk/%x", k)] = []byte{}
+		err = store.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(indexBucket("public"))
+			if err != nil {
+				return err
+			}
+
+			return b.Put(k[:], PackLoc{Length: 100}.encode())
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, _ := repo.Path(k, false)
+		os.Remove(p)
+	}
+
+	store.Close()
+	stats, err = repo.Stats([]string{"--all"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.EstimatedChunks != len(decoded.Chunks) || stats.UniqueBytes != int64(100*len(decoded.Chunks)) {
+		t.Errorf("expected the pack sizes to be used as estimates, got %d estimated chunks of %d bytes", stats.EstimatedChunks, stats.UniqueBytes)
+	}
+
+	if stats.RemoteChunks != len(decoded.Chunks) {
+		t.Errorf("expected the objects on the named remote to be counted, got %d", stats.RemoteChunks)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = stats.Write(out)
+	if err != nil || !strings.Contains(out.String(), "(estimated)") {
+		t.Errorf("expected the deduplication ratio to be labeled as an estimate, got: %s", out.String())
+	}
+}
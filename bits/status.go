@@ -0,0 +1,296 @@
+package bits
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nerdalize/git-bits/pointer"
+	bolt "go.etcd.io/bbolt"
+)
+
+//FileState tells whether the working tree holds the content of a file
+type FileState string
+
+var (
+	//PointerState tells the working tree holds the pointer
+	PointerState = FileState("pointer")
+
+	//MaterializedState tells the working tree holds the content
+	MaterializedState = FileState("materialized")
+
+	//ModifiedState tells the working tree holds changes that are not staged
+	ModifiedState = FileState("modified")
+
+	//MissingState tells the file is not in the working tree
+	MissingState = FileState("missing")
+
+	//UnsplitState tells the index holds content instead of a pointer, e.g.
+	//because it was committed before the path was tracked
+	UnsplitState = FileState("unsplit")
+)
+
+//FileStatus describes a file that is tracked with the bits filter
+type FileStatus struct {
+	Path  string
+	State FileState
+
+	//size of the content, -1 if the pointer doesn't record it
+	Size int64
+
+	//number of chunks and how many of those are stored locally or are known
+	//to be stored on the remote by the local index
+	Chunks       int
+	LocalChunks  int
+	RemoteChunks int
+}
+
+//StatusSummary describes all tracked files
+type StatusSummary struct {
+	Files map[FileState]int
+
+	//unique chunks that are stored locally but not on the remote
+	UnpushedChunks int
+	UnpushedBytes  int64
+}
+
+//indexEntry is a file in the index
+type indexEntry struct {
+	mode string
+	obj  string
+	path string
+
+	//whether the file uses the bits filter
+	tracked bool
+}
+
+//trackedFiles returns the files in the index that use the bits filter
+func (repo *Repository) trackedFiles(ctx context.Context) (entries []indexEntry, err error) {
+	all, err := repo.indexFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range all {
+		if e.tracked {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+//indexFiles returns the regular files in the index sorted by path
+func (repo *Repository) indexFiles(ctx context.Context) (entries []indexEntry, err error) {
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(ctx, nil, buf, "ls-files", "-s", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list index: %v", err)
+	}
+
+	all := map[string]indexEntry{}
+	paths := bytes.NewBuffer(nil)
+	for _, line := range strings.Split(buf.String(), "\x00") {
+		tfields := strings.SplitN(line, "\t", 2)
+		fields := strings.Fields(tfields[0])
+		if len(tfields) != 2 || len(fields) != 3 || fields[0] == "160000" || fields[0] == "120000" {
+			continue
+		}
+
+		if _, ok := all[tfields[1]]; !ok {
+			all[tfields[1]] = indexEntry{mode: fields[0], obj: fields[1], path: tfields[1]}
+			fmt.Fprintf(paths, "%s\x00", tfields[1])
+		}
+	}
+
+	if len(all) == 0 {
+		return nil, nil
+	}
+
+	//output is: <path> NUL <attribute> NUL <info> NUL
+	buf.Reset()
+	err = repo.Git(ctx, paths, buf, "check-attr", "-z", "--stdin", "filter")
+	if err != nil {
+		return nil, fmt.Errorf("failed to check attributes: %v", err)
+	}
+
+	fields := strings.Split(buf.String(), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		e, ok := all[fields[i]]
+		if !ok {
+			continue
+		}
+
+		e.tracked = fields[i+2] == "bits"
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries, nil
+}
+
+//Status reports the state of each file that is tracked with the bits filter
+//to 'fn' and returns a summary. Whether chunks are stored remotely is read from
+//the local index that is updated when pushing
+func (repo *Repository) Status(store *bolt.DB, fn func(FileStatus) error) (sum *StatusSummary, err error) {
+	ctx := context.Background()
+	entries, err := repo.trackedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, e := range entries {
+		paths = append(paths, e.path)
+	}
+
+	pols, err := repo.Policies(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	//paths with changes that are not staged
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(ctx, nil, buf, "diff", "--name-only", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list modified files: %v", err)
+	}
+
+	modified := map[string]bool{}
+	for _, p := range strings.Split(buf.String(), "\x00") {
+		modified[p] = true
+	}
+
+	ptrs, err := repo.indexPointers(ctx, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	sum = &StatusSummary{Files: map[FileState]int{}}
+	unpushed := map[string]bool{}
+	for _, e := range entries {
+		st := FileStatus{Path: e.path, Size: -1}
+		ptr := ptrs[e.path]
+		pol := pols[e.path]
+		st.State = repo.workingState(e.path, modified[e.path])
+		if ptr == nil {
+			st.State = UnsplitState
+		} else {
+			st.Size = ptr.Size
+			st.Chunks = len(ptr.Chunks)
+			err = store.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket(indexBucket(pol.Remote))
+				for _, c := range ptr.Chunks {
+					k := K(c.Key)
+					remote := b != nil && b.Get(k[:]) != nil
+					if remote {
+						st.RemoteChunks++
+					}
+
+					p, _ := repo.Path(k, false)
+					fi, err := os.Stat(p)
+					if err != nil {
+						continue
+					}
+
+					st.LocalChunks++
+					ukey := fmt.Sprintf("%x %s", k, pol.Remote)
+					if !remote && !unpushed[ukey] {
+						unpushed[ukey] = true
+						sum.UnpushedChunks++
+						if c.Size >= 0 {
+							sum.UnpushedBytes += c.Size
+						} else {
+							sum.UnpushedBytes += fi.Size()
+						}
+					}
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to read index: %v", err)
+			}
+		}
+
+		sum.Files[st.State]++
+		err = fn(st)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sum, nil
+}
+
+//indexPointer returns the pointer that the index holds for entry 'e', nil if
+//it holds content instead
+func (repo *Repository) indexPointer(ctx context.Context, e indexEntry) (ptr *pointer.Pointer, err error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(repo.Git(ctx, nil, pw, "cat-file", "blob", e.obj))
+	}()
+
+	defer pr.Close()
+	bufr := bufio.NewReader(pr)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); !pointer.IsPointer(hdr) {
+		return nil, nil
+	}
+
+	return pointer.Decode(bufr)
+}
+
+//indexPointers returns the pointers that the index holds for 'entries' by
+//path, entries that hold content instead are left out. All objects are read
+//by a single cat-file process
+func (repo *Repository) indexPointers(ctx context.Context, entries []indexEntry) (ptrs map[string]*pointer.Pointer, err error) {
+	cat := repo.catFile(ctx)
+	defer cat.Close()
+
+	ptrs = map[string]*pointer.Pointer{}
+	for _, e := range entries {
+		err = cat.Stream(e.obj, func(r io.Reader) (err error) {
+			bufr := bufio.NewReader(r)
+			if hdr, _ := bufr.Peek(len(pointer.Header)); !pointer.IsPointer(hdr) {
+				return nil
+			}
+
+			ptrs[e.path], err = pointer.Decode(bufr)
+			return err
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %v", e.path, err)
+		}
+	}
+
+	return ptrs, nil
+}
+
+//workingState returns whether the working tree holds the pointer or the
+//content of the file at path 'p'
+func (repo *Repository) workingState(p string, modified bool) FileState {
+	f, err := os.Open(filepath.Join(repo.rootDir, p))
+	if err != nil {
+		return MissingState
+	}
+
+	defer f.Close()
+	hdr := make([]byte, len(pointer.Header))
+	n, _ := io.ReadFull(f, hdr)
+	switch {
+	case pointer.IsPointer(hdr[:n]):
+		return PointerState
+	case modified:
+		return ModifiedState
+	default:
+		return MaterializedState
+	}
+}
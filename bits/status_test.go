@@ -0,0 +1,96 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//installCleanFilter configures the clean filter like install does, it
+//requires git-bits in the PATH
+func installCleanFilter(t *testing.T, repo *Repository) {
+	testGit(t, repo, "config", "filter.bits.clean", "git bits split -- %f")
+}
+
+func TestStatus(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	writeAttributes(t, repo, "*.bin filter=bits")
+
+	contents := map[string][]byte{}
+	for _, name := range []string{"a.bin", "b.bin", "c.bin", "d.txt"} {
+		data := make([]byte, 16*1024)
+		rand.Read(data)
+		contents[name] = data
+		err := os.WriteFile(filepath.Join(repo.rootDir, name), data, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+
+	//only a.bin is pushed
+	keys := bytes.NewBuffer(nil)
+	err := repo.Split(bytes.NewReader(contents["a.bin"]), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := repo.LocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+	err = repo.Push(store, keys, "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//b.bin holds its pointer, c.bin is modified and e.bin was committed as is
+	ptr := bytes.NewBuffer(nil)
+	err = repo.Split(bytes.NewReader(contents["b.bin"]), ptr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(filepath.Join(repo.rootDir, "b.bin"), ptr.Bytes(), 0666)
+	os.WriteFile(filepath.Join(repo.rootDir, "c.bin"), []byte("changed"), 0666)
+	obj := strings.TrimSpace(testGit(t, repo, "hash-object", "-w", "--no-filters", "--stdin"))
+	testGit(t, repo, "update-index", "--add", "--cacheinfo", "100644,"+obj+",e.bin")
+	os.WriteFile(filepath.Join(repo.rootDir, "e.bin"), nil, 0666)
+
+	states := map[string]FileStatus{}
+	sum, err := repo.Status(store, func(st FileStatus) error {
+		states[st.Path] = st
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, exp := range map[string]FileStatus{
+		"a.bin": {"a.bin", MaterializedState, 16 * 1024, 1, 1, 1},
+		"b.bin": {"b.bin", PointerState, 16 * 1024, 1, 1, 0},
+		"c.bin": {"c.bin", ModifiedState, 16 * 1024, 1, 1, 0},
+		"e.bin": {"e.bin", UnsplitState, -1, 0, 0, 0},
+	} {
+		if states[p] != exp {
+			t.Errorf("expected status %+v, got %+v", exp, states[p])
+		}
+	}
+
+	if _, ok := states["d.txt"]; ok {
+		t.Error("expected files without the bits filter not to be listed")
+	}
+
+	if sum.UnpushedChunks != 2 || sum.UnpushedBytes != 32*1024 || sum.Files[MaterializedState] != 1 {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+}
@@ -0,0 +1,351 @@
+package bits
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+//TrackedPattern is a .gitattributes pattern that uses the bits filter
+type TrackedPattern struct {
+	Pattern string
+
+	//the .gitattributes file that holds the pattern
+	File string
+}
+
+//attributesPath returns the path of the .gitattributes file in the root of
+//the working tree
+func (repo *Repository) attributesPath() string {
+	return filepath.Join(repo.rootDir, ".gitattributes")
+}
+
+//Track adds the bits filter and drivers for each pattern to the root
+//.gitattributes, other attributes of a pattern are kept. Each pattern is written to 'w' with
+//whether it was already tracked
+func (repo *Repository) Track(patterns []string, w io.Writer) (err error) {
+	return repo.editAttributes(patterns, w, func(data []byte) ([]byte, []string) {
+		return trackAttributes(data, patterns)
+	}, "tracking '%s'\n", "'%s' is already tracked\n")
+}
+
+//Untrack removes the bits filter and bits attributes for each pattern from
+//the root .gitattributes, lines without other attributes are removed
+func (repo *Repository) Untrack(patterns []string, w io.Writer) (err error) {
+	return repo.editAttributes(patterns, w, func(data []byte) ([]byte, []string) {
+		return untrackAttributes(data, patterns)
+	}, "untracking '%s'\n", "'%s' is not tracked in .gitattributes\n")
+}
+
+//editAttributes changes the root .gitattributes with 'fn' that returns the
+//new content and the patterns it changed
+func (repo *Repository) editAttributes(patterns []string, w io.Writer, fn func([]byte) ([]byte, []string), changedMsg, unchangedMsg string) (err error) {
+	for _, p := range patterns {
+		if p == "" || strings.ContainsAny(p, " \t\n") || strings.HasPrefix(p, "#") {
+			return fmt.Errorf("invalid pattern '%s', patterns can't be empty, contain whitespace or start with a '#'", p)
+		}
+	}
+
+	data, err := os.ReadFile(repo.attributesPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read .gitattributes: %v", err)
+	}
+
+	ndata, changed := fn(data)
+	if !bytes.Equal(ndata, data) {
+		err = os.WriteFile(repo.attributesPath(), ndata, 0666)
+		if err != nil {
+			return fmt.Errorf("failed to write .gitattributes: %v", err)
+		}
+	}
+
+	for _, p := range patterns {
+		msg := unchangedMsg
+		for _, c := range changed {
+			if c == p {
+				msg = changedMsg
+			}
+		}
+
+		fmt.Fprintf(w, msg, p)
+	}
+
+	return nil
+}
+
+//TrackedPatterns returns the patterns in .gitattributes files of the working
+//tree that use the bits filter
+func (repo *Repository) TrackedPatterns() (patterns []TrackedPattern, err error) {
+	buf := bytes.NewBuffer(nil)
+	err = repo.Git(context.Background(), nil, buf, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %v", err)
+	}
+
+	files := []string{".gitattributes"}
+	for _, p := range strings.Split(buf.String(), "\x00") {
+		if p != ".gitattributes" && path.Base(p) == ".gitattributes" {
+			files = append(files, p)
+		}
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(repo.rootDir, file))
+		if err != nil {
+			continue
+		}
+
+		s := bufio.NewScanner(bytes.NewReader(data))
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			for _, f := range fields[min(len(fields), 1):] {
+				if f == "filter=bits" && !strings.HasPrefix(fields[0], "#") {
+					patterns = append(patterns, TrackedPattern{fields[0], file})
+				}
+			}
+		}
+	}
+
+	return patterns, nil
+}
+
+//Restage stages files again such that their content is stored as the bits
+//filter dictates: tracked files that are stored as is get split and untracked
+//files that are stored as pointers get their content back. The path of each
+//restaged file is written to 'w'
+func (repo *Repository) Restage(w io.Writer) (err error) {
+	ctx := context.Background()
+	entries, err := repo.indexFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{}
+	for _, e := range entries {
+		ptr, err := repo.indexPointer(ctx, e)
+		if err != nil {
+			return fmt.Errorf("failed to read '%s': %v", e.path, err)
+		}
+
+		if e.tracked == (ptr != nil) {
+			continue
+		}
+
+		if !e.tracked {
+			err = repo.materialize(e.path)
+			if err != nil {
+				return fmt.Errorf("failed to materialize '%s': %v", e.path, err)
+			}
+		}
+
+		paths = append(paths, e.path)
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	err = repo.Git(ctx, nil, nil, append([]string{"add", "--renormalize", "--"}, paths...)...)
+	if err != nil {
+		return fmt.Errorf("failed to restage files: %v", err)
+	}
+
+	for _, p := range paths {
+		fmt.Fprintf(w, "%s\n", p)
+	}
+
+	return nil
+}
+
+//materialize replaces the pointer in the working tree at path 'p' with the
+//content it points to, chunks are fetched as needed. The content is combined
+//into a temporary file that only replaces the pointer once it is verified
+func (repo *Repository) materialize(p string) (err error) {
+	fpath := filepath.Join(repo.rootDir, p)
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	bufr := bufio.NewReader(f)
+	if hdr, _ := bufr.Peek(len(pointer.Header)); !pointer.IsPointer(hdr) {
+		return nil
+	}
+
+	pol, err := repo.Policy(p)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmpf, err := os.CreateTemp(filepath.Dir(fpath), ".bits_tmp_")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmpf.Name())
+	defer tmpf.Close()
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(repo.fetchFrom(pol.Remote, pol.Path, bufr, pw))
+	}()
+
+	err = repo.Combine(pr, tmpf)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+
+	err = tmpf.Chmod(fi.Mode())
+	if err != nil {
+		return err
+	}
+
+	err = tmpf.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpf.Name(), fpath)
+}
+
+//bitsAttributes are the attributes that track a pattern: the bits filter and
+//the diff and merge drivers for pointers
+var bitsAttributes = []string{"filter=bits", "diff=bits", "merge=bits"}
+
+//trackAttributes adds the bits filter and drivers for each pattern to the
+//.gitattributes content 'data', patterns that are on a line already get them
+//on that line. It returns the new content and the patterns that were added
+func trackAttributes(data []byte, patterns []string) ([]byte, []string) {
+	text := string(data)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	lines = lines[:len(lines)-1]
+
+	added := []string{}
+	for _, p := range patterns {
+		found := false
+		for i, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) < 1 || fields[0] != p {
+				continue
+			}
+
+			//any other filter, diff or merge setting on the line is replaced
+			found = true
+			has, nfields := map[string]bool{}, []string{p}
+			for _, f := range fields[1:] {
+				name, _, _ := strings.Cut(strings.TrimLeft(f, "-!"), "=")
+				switch {
+				case contains(bitsAttributes, f):
+					has[f] = true
+					nfields = append(nfields, f)
+				case name == "filter" || name == "diff" || name == "merge":
+				default:
+					nfields = append(nfields, f)
+				}
+			}
+
+			for _, attr := range bitsAttributes {
+				if !has[attr] {
+					nfields = append(nfields, attr)
+				}
+			}
+
+			if nline := strings.Join(nfields, " "); nline != strings.Join(fields, " ") {
+				lines[i] = nline + "\n"
+				added = append(added, p)
+			}
+
+			break
+		}
+
+		if !found {
+			lines = append(lines, p+" "+strings.Join(bitsAttributes, " ")+"\n")
+			added = append(added, p)
+		}
+	}
+
+	if len(added) == 0 {
+		return data, nil
+	}
+
+	return []byte(strings.Join(lines, "")), added
+}
+
+//excludeAttributes appends a '-filter' line for each of 'patterns' that
+//doesn't have one yet, such that paths that are excluded are not split by
+//the bits filter of other patterns
+func excludeAttributes(data []byte, patterns []string) []byte {
+	text := string(data)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	for _, p := range patterns {
+		if !strings.Contains("\n"+text, "\n"+p+" -filter\n") {
+			text += p + " -filter\n"
+		}
+	}
+
+	return []byte(text)
+}
+
+//untrackAttributes removes the bits filter, drivers and attributes from the
+//lines of .gitattributes content 'data' that have one of 'patterns', or from
+//all lines if there are no patterns. Lines without any other attributes are
+//removed. It returns the new content and the patterns that were changed
+func untrackAttributes(data []byte, patterns []string) ([]byte, []string) {
+	selected := map[string]bool{}
+	for _, p := range patterns {
+		selected[p] = true
+	}
+
+	buf := bytes.NewBuffer(nil)
+	changed := []string{}
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || (len(patterns) > 0 && !selected[fields[0]]) {
+			buf.WriteString(line)
+			continue
+		}
+
+		nfields := []string{}
+		for _, f := range fields[1:] {
+			name := strings.TrimLeft(f, "-!")
+			if f == "filter=bits" || f == "diff=bits" || f == "merge=bits" || strings.HasPrefix(name, "bits-") {
+				continue
+			}
+
+			nfields = append(nfields, f)
+		}
+
+		if len(nfields) == len(fields)-1 {
+			buf.WriteString(line)
+			continue
+		}
+
+		changed = append(changed, fields[0])
+		if len(nfields) > 0 {
+			fmt.Fprintf(buf, "%s %s\n", fields[0], strings.Join(nfields, " "))
+		}
+	}
+
+	return buf.Bytes(), changed
+}
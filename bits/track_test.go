@@ -0,0 +1,116 @@
+package bits
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nerdalize/git-bits/pointer"
+)
+
+func TestTrackAttributes(t *testing.T) {
+	for _, c := range []struct {
+		attrs    string
+		patterns []string
+		exp      string
+		added    int
+	}{
+		{"*.txt text\n*.bin filter=bits diff=bits merge=bits", []string{"*.bin", "*.psd"}, "*.txt text\n*.bin filter=bits diff=bits merge=bits\n*.psd filter=bits diff=bits merge=bits\n", 1},
+		{"*.psd -text filter=lfs -diff\n", []string{"*.psd"}, "*.psd -text filter=bits diff=bits merge=bits\n", 1},
+		{"*.bin filter=bits -text diff=bits merge=bits\n", []string{"*.bin"}, "*.bin filter=bits -text diff=bits merge=bits\n", 0},
+		{"*.bin filter=bits\n", []string{"*.bin"}, "*.bin filter=bits diff=bits merge=bits\n", 1},
+		{"", []string{"*.bin", "*.bin"}, "*.bin filter=bits diff=bits merge=bits\n", 1},
+	} {
+		act, added := trackAttributes([]byte(c.attrs), c.patterns)
+		if string(act) != c.exp || len(added) != c.added {
+			t.Errorf("expected attributes '%s' with %d added, got '%s' with %v", c.exp, c.added, act, added)
+		}
+	}
+}
+
+func TestUntrackAttributes(t *testing.T) {
+	attrs := "# tracked\n*.bin filter=bits\n*.psd filter=bits bits-compress -text\n*.txt text\n"
+	act, changed := untrackAttributes([]byte(attrs), nil)
+	if exp := "# tracked\n*.psd -text\n*.txt text\n"; string(act) != exp || len(changed) != 2 {
+		t.Errorf("expected attributes '%s', got '%s'", exp, act)
+	}
+
+	act, changed = untrackAttributes([]byte(attrs), []string{"*.psd", "*.mp4"})
+	if exp := "# tracked\n*.bin filter=bits\n*.psd -text\n*.txt text\n"; string(act) != exp || len(changed) != 1 {
+		t.Errorf("expected attributes '%s', got '%s'", exp, act)
+	}
+}
+
+func TestTrackUntrackRestage(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	data := make([]byte, 16*1024)
+	rand.Read(data)
+	err := os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-m", "c0")
+
+	out := bytes.NewBuffer(nil)
+	err = repo.Track([]string{"*.bin"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Restage(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "tracking '*.bin'\na.bin\n" {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+
+	if staged := testGit(t, repo, "show", ":a.bin"); !strings.HasPrefix(staged, string(pointer.Header)) {
+		t.Error("expected the restaged file to be a pointer")
+	}
+
+	patterns, err := repo.TrackedPatterns()
+	if err != nil || len(patterns) != 1 || patterns[0] != (TrackedPattern{"*.bin", ".gitattributes"}) {
+		t.Errorf("unexpected tracked patterns: %v, %v", patterns, err)
+	}
+
+	err = repo.Track([]string{"has space"}, out)
+	if err == nil {
+		t.Error("expected a pattern with whitespace to be rejected")
+	}
+
+	//the working tree holds the pointer, untracking restores the content
+	testGit(t, repo, "commit", "-m", "c1")
+	ptr := testGit(t, repo, "show", ":a.bin")
+	os.WriteFile(filepath.Join(repo.rootDir, "a.bin"), []byte(ptr), 0666)
+
+	out.Reset()
+	err = repo.Untrack([]string{"*.bin"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Restage(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "untracking '*.bin'\na.bin\n" {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+
+	if staged := testGit(t, repo, "show", ":a.bin"); staged != string(data) {
+		t.Error("expected the untracked file to be staged with its content")
+	}
+
+	if attrs, _ := os.ReadFile(filepath.Join(repo.rootDir, ".gitattributes")); len(attrs) != 0 {
+		t.Errorf("expected empty attributes, got: %s", attrs)
+	}
+}
@@ -0,0 +1,110 @@
+package bits
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Uninstall removes the filter and bits configuration from the local git
+//configuration and removes the hooks that git-bits wrote, a hook that does
+//other things keeps those. With 'materialize' the pointers in the
+//working tree are replaced by their content first so the files remain usable
+func (repo *Repository) Uninstall(w io.Writer, materialize bool) (err error) {
+	ctx := context.Background()
+	if materialize {
+		err = repo.PullWith("HEAD", nil, nil, w)
+		if err != nil {
+			return fmt.Errorf("failed to materialize files: %v", err)
+		}
+	}
+
+	//remove every section that holds bits configuration
+	for _, section := range repo.bitsSections(ctx) {
+		err = repo.Git(ctx, nil, nil, "config", "--local", "--remove-section", section)
+		if err != nil {
+			return fmt.Errorf("failed to remove configuration: %v", err)
+		}
+
+		fmt.Fprintf(w, "removed '%s' configuration\n", section)
+	}
+
+	for _, name := range Hooks {
+		err = repo.uninstallHook(name, w)
+		if err != nil {
+			return err
+		}
+	}
+
+	repo.confFileHint(w)
+	return nil
+}
+
+//UninstallPlan writes what Uninstall would do to 'w' without changing
+//anything: with 'materialize' the chunks it would fetch and files it would
+//write, and the configuration and hooks it would remove
+func (repo *Repository) UninstallPlan(w io.Writer, materialize bool) (err error) {
+	if materialize {
+		plan, err := repo.PullPlan("HEAD", nil, nil)
+		if err != nil {
+			return fmt.Errorf("failed to plan materializing files: %v", err)
+		}
+
+		err = plan.Write(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, section := range repo.bitsSections(context.Background()) {
+		fmt.Fprintf(w, "would remove '%s' configuration\n", section)
+	}
+
+	for _, name := range Hooks {
+		data, err := os.ReadFile(repo.hookPath(name))
+		if err == nil && (bytes.Contains(data, []byte(hookBegin)) || string(data) == PrePushHook) {
+			fmt.Fprintf(w, "would remove git-bits from %s hook\n", name)
+		}
+	}
+
+	repo.confFileHint(w)
+	return nil
+}
+
+//confFileHint tells that the tracked .bitsconfig is kept, if there is one,
+//since removing it is a change to commit
+func (repo *Repository) confFileHint(w io.Writer) {
+	if _, err := os.Stat(filepath.Join(repo.rootDir, ConfFile)); err == nil {
+		fmt.Fprintf(w, "the tracked '%s' is kept, run 'git rm %s' to remove it\n", ConfFile, ConfFile)
+	}
+}
+
+//bitsSections returns the sections of the local git configuration that
+//hold bits configuration
+func (repo *Repository) bitsSections(ctx context.Context) (sections []string) {
+	buf := bytes.NewBuffer(nil)
+	repo.Git(ctx, nil, buf, "config", "--local", "--name-only", "--get-regexp", `^(bits|(filter|diff|merge)\.bits)\.`)
+	for _, key := range strings.Fields(buf.String()) {
+		section := key[:strings.LastIndex(key, ".")]
+		if !contains(sections, section) {
+			sections = append(sections, section)
+		}
+	}
+
+	return sections
+}
+
+//contains returns whether 'vals' holds 'val'
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package bits
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUninstall(t *testing.T) {
+	repo := newTestRepository(t, newMemRemote())
+	installCleanFilter(t, repo)
+	testGit(t, repo, "config", "filter.bits.smudge", "git bits fetch | git bits combine")
+	testGit(t, repo, "config", "bits.deduplication-scope", "1")
+	testGit(t, repo, "config", "user.name", "keep")
+
+	err := os.WriteFile(repo.hookPath("pre-push"), []byte(PrePushHook), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(repo.rootDir, ConfFile), []byte("[bits]\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.NewBuffer(nil)
+	err = repo.UninstallPlan(out, false)
+	if exp := "would remove 'filter.bits' configuration\nwould remove 'bits' configuration\nwould remove git-bits from pre-push hook\nthe tracked '.bitsconfig' is kept, run 'git rm .bitsconfig' to remove it\n"; err != nil || out.String() != exp {
+		t.Errorf("unexpected plan: %s, %v", out.String(), err)
+	}
+
+	if _, err = os.Stat(repo.hookPath("pre-push")); err != nil {
+		t.Error("expected the plan to keep the pre-push hook")
+	}
+
+	out.Reset()
+	err = repo.Uninstall(out, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "removed 'filter.bits' configuration\nremoved 'bits' configuration\nremoved pre-push hook\nthe tracked '.bitsconfig' is kept, run 'git rm .bitsconfig' to remove it\n"; out.String() != exp {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+
+	if _, err = os.Stat(repo.hookPath("pre-push")); !os.IsNotExist(err) {
+		t.Error("expected the pre-push hook to be removed")
+	}
+
+	if conf := testGit(t, repo, "config", "--local", "--list"); strings.Contains(conf, "bits") || !strings.Contains(conf, "user.name=keep") {
+		t.Errorf("unexpected configuration after uninstall: %s", conf)
+	}
+
+	//a hook that does other things only loses the marked section, lines of
+	//the user that mention git-bits are kept
+	user := "#!/bin/sh\n./lint.sh || exit 1\ngit-bits scan | ./audit.sh\n"
+	hook := "#!/bin/sh\n" + hookSection("pre-push", false) + "./lint.sh || exit 1\ngit-bits scan | ./audit.sh\n"
+	os.WriteFile(repo.hookPath("pre-push"), []byte(hook), 0777)
+	err = repo.Uninstall(out, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(repo.hookPath("pre-push"))
+	if string(data) != user {
+		t.Errorf("unexpected hook after uninstall: %s", data)
+	}
+
+	//without a marked section the hook is not ours
+	err = repo.Uninstall(out, false)
+	if data, _ = os.ReadFile(repo.hookPath("pre-push")); err != nil || string(data) != user {
+		t.Errorf("expected a hook without git-bits section to be kept, got '%s': %v", data, err)
+	}
+}
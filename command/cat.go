@@ -0,0 +1,31 @@
+package command
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewCatCmd() *cobra.Command {
+	var raw bool
+	cmd := &cobra.Command{
+		Use:   "cat <rev>:<path>",
+		Short: "writes the content of a file at any revision to stdout",
+		Long: "Writes the content of a file at any revision to stdout, chunks that are not stored locally are " +
+			"fetched. The working tree and index are left untouched. With --pointer the pointer is written instead.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			return repo.Cat(args[0], raw, os.Stdout)
+		},
+	}
+
+	cmd.Flags().BoolVar(&raw, "pointer", false, "write the pointer instead of the content")
+	return cmd
+}
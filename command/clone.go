@@ -0,0 +1,43 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewCloneCmd() *cobra.Command {
+	var jobs int
+	cmd := &cobra.Command{
+		Use:   "clone <url> [dir] [-- git clone options]",
+		Short: "clones a repository and then fetches the chunks of all files in parallel",
+		Long: "Clones the repository with the smudge filter skipped such that files are checked out as pointers, then " +
+			"fetches the chunks of all files concurrently and combines them. The pre-push hook is installed such " +
+			"that new commits push their chunks. Chunks that are stored already are " +
+			"skipped, if anything fails 'git bits pull' resumes. Options after '--' are passed to git clone, e.g. " +
+			"'-- -c bits.aws-s3-bucket-name=my-bucket' configures the chunk remote of the clone.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cargs := []string{}
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				args, cargs = args[:dash], args[dash:]
+			}
+
+			if len(args) < 1 || len(args) > 2 {
+				return fmt.Errorf("expected a url and an optional directory, got: %v", args)
+			}
+
+			repo, err := bits.Clone(args[0], pathArg(args[1:]), cargs, jobs, os.Stderr, os.Stdout)
+			if repo != nil {
+				repo.Close()
+			}
+
+			return err
+		},
+	}
+
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", 8, "number of chunks that are fetched concurrently")
+	return cmd
+}
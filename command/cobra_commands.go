@@ -1,7 +1,10 @@
 package command
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/nerdalize/git-bits/bits"
@@ -23,52 +26,150 @@ func NewScanCmd() *cobra.Command {
 }
 
 func NewSplitCmd() *cobra.Command {
-	return &cobra.Command{
+	var of *outputFlags
+	cmd := &cobra.Command{
 		Use:   "split",
 		Short: "splits a file into chunks and store them locally",
+		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			wd, _ := os.Getwd()
 			repo, err := bits.NewRepository(wd, os.Stderr)
 			if err != nil {
 				return err
 			}
-			return repo.Split(os.Stdin, os.Stdout)
+			pol, err := repo.Policy(pathArg(args))
+			if err != nil {
+				return err
+			}
+			return of.run(repo, func() error { return repo.SplitWith(pol, os.Stdin, os.Stdout) })
 		},
 	}
+
+	of = addOutputFlags(cmd)
+	return cmd
 }
 
 func NewFetchCmd() *cobra.Command {
-	return &cobra.Command{
+	var dryRun bool
+	var of *outputFlags
+	cmd := &cobra.Command{
 		Use:   "fetch",
 		Short: "fetch chunks from the remote store and save each locally",
+		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			wd, _ := os.Getwd()
 			repo, err := bits.NewRepository(wd, os.Stderr)
 			if err != nil {
 				return err
 			}
-			return repo.Fetch(os.Stdin, os.Stdout)
+			pol, err := repo.Policy(pathArg(args))
+			if err != nil {
+				return err
+			}
+			if dryRun {
+				plan, err := repo.FetchPlan(pol.Remote, os.Stdin)
+				if err != nil {
+					return err
+				}
+				return plan.Write(os.Stdout)
+			}
+			return of.run(repo, func() error { return repo.FetchFrom(pol.Remote, os.Stdin, os.Stdout) })
 		},
 	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "list the chunks that would be fetched instead of fetching them")
+	of = addOutputFlags(cmd)
+	return cmd
 }
 
 func NewPullCmd() *cobra.Command {
-	return &cobra.Command{
+	var ref string
+	var jobs int
+	var include, exclude []string
+	var dryRun bool
+	var of *outputFlags
+	cmd := &cobra.Command{
 		Use:   "pull",
 		Short: "fetch chunks for split files in the working tree and combine",
+		Long: "Fetches the chunks of the files in the working tree that hold a pointer and combines them. Files are " +
+			"selected with pathspecs and the --include and --exclude patterns, which replace the 'bits.fetch-include' " +
+			"and 'bits.fetch-exclude' configuration. Files that are not selected keep their pointer. Pathspecs are " +
+			"files or directories, use --include for patterns such as '*.bin'. With --ref the chunks of another " +
+			"commit are fetched, files are only written where the working tree holds the same pointer.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			wd, _ := os.Getwd()
 			repo, err := bits.NewRepository(wd, os.Stderr)
 			if err != nil {
 				return err
 			}
-			return repo.Pull("HEAD", os.Stdout)
+
+			filter, err := repo.FetchFilter()
+			if err != nil {
+				return err
+			}
+
+			if len(include) > 0 || len(exclude) > 0 {
+				filter, err = bits.NewPathFilter(include, exclude)
+				if err != nil {
+					return err
+				}
+			}
+
+			//pathspecs are relative to the working directory, git runs
+			//from the root of the working tree
+			pathspecs := []string{}
+			for _, arg := range args {
+				if !strings.HasPrefix(arg, ":") && strings.ContainsAny(arg, "*?[") {
+					return fmt.Errorf("pathspec '%s' is a pattern, which ls-tree doesn't match, use --include '%s' instead", arg, arg)
+				}
+
+				if !strings.HasPrefix(arg, ":") && !filepath.IsAbs(arg) {
+					arg = filepath.Join(wd, arg)
+				}
+
+				pathspecs = append(pathspecs, arg)
+			}
+
+			if dryRun {
+				plan, err := repo.PullPlan(ref, pathspecs, filter)
+				if err != nil {
+					return err
+				}
+
+				return plan.Write(os.Stdout)
+			}
+
+			//chunks are fetched concurrently up front, otherwise per file.
+			//Files whose chunks were fetched are combined either way
+			return of.run(repo, func() error {
+				var ferr error
+				if jobs > 1 {
+					ferr = repo.FetchTree(ref, pathspecs, filter, jobs)
+				}
+
+				err := repo.PullWith(ref, pathspecs, filter, os.Stdout)
+				if ferr != nil {
+					return ferr
+				}
+
+				return err
+			})
 		},
 	}
+
+	cmd.Flags().StringVar(&ref, "ref", "HEAD", "the commit or tree whose chunks are fetched, files are only written where the working tree holds the same pointer")
+	cmd.Flags().StringSliceVarP(&include, "include", "I", nil, "only combine paths that match these patterns")
+	cmd.Flags().StringSliceVarP(&exclude, "exclude", "X", nil, "don't combine paths that match these patterns")
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "number of chunks that are fetched concurrently")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "list the chunks that would be fetched and the files that would be written instead")
+	of = addOutputFlags(cmd)
+	return cmd
 }
 
 func NewPushCmd() *cobra.Command {
-	return &cobra.Command{
+	var dryRun bool
+	var of *outputFlags
+	cmd := &cobra.Command{
 		Use:   "push",
 		Short: "push locally stored chunks to the remote store",
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -82,13 +183,44 @@ func NewPushCmd() *cobra.Command {
 				return err
 			}
 			defer store.Close()
-			return repo.Push(store, os.Stdin, "origin")
+			if dryRun {
+				return of.run(repo, func() error {
+					plan, err := repo.PushPlan(store, os.Stdin)
+					if err != nil {
+						return err
+					}
+					return plan.Write(os.Stdout)
+				})
+			}
+			return of.run(repo, func() error { return repo.Push(store, os.Stdin, "origin") })
 		},
 	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "list the chunks that would be uploaded instead of uploading them, the local index is left as is")
+	of = addOutputFlags(cmd)
+	return cmd
 }
 
-func NewCombineCmd() *cobra.Command {
+func NewSmudgeCmd() *cobra.Command {
 	return &cobra.Command{
+		Use:   "smudge",
+		Short: "fetch chunks and combine them into the file, as the smudge filter",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			return repo.Smudge(pathArg(args), os.Stdin, os.Stdout)
+		},
+	}
+}
+
+func NewCombineCmd() *cobra.Command {
+	var of *outputFlags
+	cmd := &cobra.Command{
 		Use:   "combine",
 		Short: "combine chunks back into the original file",
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -97,7 +229,19 @@ func NewCombineCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			return repo.Combine(os.Stdin, os.Stdout)
+			return of.run(repo, func() error { return repo.Combine(os.Stdin, os.Stdout) })
 		},
 	}
-}
\ No newline at end of file
+
+	of = addOutputFlags(cmd)
+	return cmd
+}
+
+//pathArg returns the optional path that filters pass to a command, its
+//attributes determine how content is chunked and stored
+func pathArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return ""
+}
@@ -1,8 +1,11 @@
 package command
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
+	"github.com/nerdalize/git-bits/bits"
 	"github.com/spf13/cobra"
 )
 
@@ -69,4 +72,151 @@ func TestAllCommandsHaveHelp(t *testing.T) {
 			t.Errorf("Command %s missing RunE function", cmd.Use)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestCommandFlags(t *testing.T) {
+	migrate := NewMigrateCmd()
+	imp, _, _ := migrate.Find([]string{"import"})
+	exp, _, _ := migrate.Find([]string{"export"})
+	lfs, _, _ := migrate.Find([]string{"import-lfs"})
+	config := NewConfigCmd()
+	set, _, _ := config.Find([]string{"set"})
+	for cmd, flags := range map[*cobra.Command][]string{
+		NewInstallCmd():   {"pull-hooks", "remote-url", "no-pull", "yes"},
+		NewSplitCmd():     {"json", "output"},
+		NewCombineCmd():   {"json", "output"},
+		NewFetchCmd():     {"json", "output", "dry-run"},
+		NewPushCmd():      {"json", "output", "dry-run"},
+		NewPullCmd():      {"json", "output", "dry-run", "ref", "include", "exclude"},
+		NewTrackCmd():     {"restage"},
+		NewUntrackCmd():   {"restage"},
+		NewUninstallCmd(): {"materialize", "dry-run"},
+		NewCloneCmd():     {"jobs"},
+		NewCatCmd():       {"pointer"},
+		NewStatsCmd():     {"offline"},
+		NewEstimateCmd():  {"include", "exclude"},
+		imp:               {"include", "exclude", "dry-run"},
+		exp:               {"dry-run"},
+		lfs:               {"history"},
+		set:               {"shared"},
+	} {
+		if cmd.RunE == nil {
+			t.Errorf("Expected %s to have a RunE function", cmd.Use)
+		}
+
+		for _, name := range flags {
+			if cmd.Flags().Lookup(name) == nil {
+				t.Errorf("Expected %s to have a --%s flag", cmd.Use, name)
+			}
+		}
+	}
+}
+
+func TestNewMigrateCmd(t *testing.T) {
+	cmd := NewMigrateCmd()
+	if cmd.Use != "migrate" {
+		t.Errorf("Expected Use to be 'migrate', got %s", cmd.Use)
+	}
+
+	for _, name := range []string{"import", "import-lfs", "export"} {
+		sub, _, err := cmd.Find([]string{name})
+		if err != nil || sub.Name() != name || sub.RunE == nil {
+			t.Errorf("Expected a %s subcommand, got %v", name, err)
+		}
+	}
+}
+
+func TestNewStatusCmd(t *testing.T) {
+	cmd := NewStatusCmd()
+	if cmd.Use != "status" {
+		t.Errorf("Expected Use to be 'status', got %s", cmd.Use)
+	}
+}
+
+func TestNewHookCmd(t *testing.T) {
+	cmd := NewHookCmd()
+	if cmd.Args(cmd, nil) == nil {
+		t.Error("Expected the hook command to require a hook name")
+	}
+}
+
+func TestNewCloneCmd(t *testing.T) {
+	cmd := NewCloneCmd()
+	if cmd.Args(cmd, nil) == nil {
+		t.Error("Expected clone to require a url")
+	}
+}
+
+func TestNewCatCmd(t *testing.T) {
+	cmd := NewCatCmd()
+	if cmd.Args(cmd, nil) == nil {
+		t.Error("Expected cat to require a file")
+	}
+}
+
+func TestNewDiffCmds(t *testing.T) {
+	cmd := NewDiffCmd()
+	if cmd.Args(cmd, []string{"HEAD~1", "HEAD", "a.bin"}) == nil {
+		t.Error("Expected diff to require '--' before the paths")
+	}
+
+	if cmd := NewTextconvCmd(); cmd.Args(cmd, nil) == nil {
+		t.Error("Expected textconv to require a file")
+	}
+}
+
+func TestNewMergeDriverCmd(t *testing.T) {
+	cmd := NewMergeDriverCmd()
+	if cmd.Args(cmd, []string{"base", "ours", "theirs"}) == nil {
+		t.Error("Expected the merge driver to require the base, ours, theirs and the path")
+	}
+}
+
+func TestOutputFlags(t *testing.T) {
+	of := &outputFlags{output: "xml"}
+	if err := of.run(nil, func() error { return nil }); err == nil {
+		t.Error("Expected an unsupported output format to fail")
+	}
+}
+
+func TestNewEstimateCmd(t *testing.T) {
+	cmd := NewEstimateCmd()
+	if cmd.Args(cmd, nil) == nil {
+		t.Error("Expected estimate to require paths or revisions")
+	}
+}
+
+func TestPrintChecks(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	err := printChecks([]bits.Check{
+		{Name: "filter", Status: bits.CheckOK, Message: "configured"},
+		{Name: "hook", Status: bits.CheckFailed, Message: "missing", Fix: "run 'git bits install'"},
+	}, out)
+
+	if err == nil || err.Error() != "1 of 2 checks failed" {
+		t.Errorf("Expected a failed check to fail, got: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "error    hook: missing\n         fix: run 'git bits install'\n") {
+		t.Errorf("Unexpected output: %s", out.String())
+	}
+}
+
+func TestNewConfigCmd(t *testing.T) {
+	cmd := NewConfigCmd()
+	if cmd.Use != "config" {
+		t.Errorf("Expected Use to be 'config', got %s", cmd.Use)
+	}
+
+	for _, name := range []string{"get", "set", "list"} {
+		sub, _, err := cmd.Find([]string{name})
+		if err != nil || sub.Name() != name {
+			t.Errorf("Expected a %s subcommand, got: %v", name, err)
+		}
+	}
+
+	set, _, _ := cmd.Find([]string{"set"})
+	if err := set.Args(set, []string{"bits.chunker"}); err == nil {
+		t.Error("Expected set to require a key and a value")
+	}
+}
@@ -0,0 +1,96 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "reads and writes the bits configuration",
+	}
+
+	cmd.AddCommand(
+		NewConfigGetCmd(),
+		NewConfigSetCmd(),
+		NewConfigListCmd(),
+	)
+	return cmd
+}
+
+func NewConfigGetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <key>",
+		Short: "prints the value of a bits configuration key",
+		Long: "Prints the value of a bits configuration key as git-bits reads it: the git configuration takes " +
+			"precedence over .bitsconfig, which takes precedence over the default chunking parameters. The 'bits.' " +
+			"prefix of the key may be omitted.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			vals, err := repo.GetConf(args[0])
+			if err != nil {
+				return err
+			}
+
+			for _, val := range vals {
+				fmt.Println(val)
+			}
+
+			return nil
+		},
+	}
+}
+
+func NewConfigSetCmd() *cobra.Command {
+	var shared bool
+	cmd := &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "validates and writes a bits configuration value",
+		Long: "Validates the value for a bits configuration key and writes it to the local git configuration, or " +
+			"to .bitsconfig with --shared such that every clone uses it once committed, only the chunking parameters and " +
+			"buckets are read from .bitsconfig. The chunking parameters can only be set with --shared, git " +
+			"configuration doesn't override the ones that .bitsconfig records. Values that git-bits can't " +
+			"read, such as a deduplication scope that is not a base10 number or inconsistent chunk sizes, are " +
+			"rejected. The 'bits.' prefix of the key may be omitted.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			return repo.SetConf(args[0], args[1], shared)
+		},
+	}
+
+	cmd.Flags().BoolVar(&shared, "shared", false, "write the value to .bitsconfig instead of the local git configuration")
+	return cmd
+}
+
+func NewConfigListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "lists the bits configuration and where each value is read from",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			return repo.ListConf(os.Stdout)
+		},
+	}
+}
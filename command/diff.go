@@ -0,0 +1,90 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewTextconvCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "textconv <file>",
+		Short: "summarizes a pointer for the diff driver",
+		Long: "Writes the size, hash and number of chunks of the pointer in the given file, install configures it as " +
+			"the textconv of the 'bits' diff driver that paths select with the 'diff=bits' attribute.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return bits.Textconv(f, os.Stdout)
+		},
+	}
+}
+
+func NewDiffCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <rev1> <rev2> -- <path>...",
+		Short: "shows which parts of files changed between two revisions",
+		Long: "Compares the pointers of each path between two revisions and shows the byte ranges of the content " +
+			"that changed, how many chunks are reused or new and how many bytes need to be transferred.",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if dash := cmd.ArgsLenAtDash(); dash != 2 || len(args) < 3 {
+				return fmt.Errorf("expected two revisions followed by '--' and one or more paths")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			return repo.Diff(args[0], args[1], args[2:], func(d *bits.PointerDiff) error {
+				return printDiff(d, os.Stdout)
+			})
+		},
+	}
+}
+
+//printDiff writes a summary of how the content of a file changed
+func printDiff(d *bits.PointerDiff, w io.Writer) error {
+	size := func(n int64) string {
+		if n < 0 {
+			return "?"
+		}
+		return humanize.IBytes(uint64(n))
+	}
+
+	switch {
+	case d.Deleted:
+		_, err := fmt.Fprintf(w, "%s: deleted\n", d.Path)
+		return err
+	case d.Added:
+		fmt.Fprintf(w, "%s: added, %s in %d chunks\n", d.Path, size(d.NewSize), d.Chunks)
+	case d.Unchanged:
+		_, err := fmt.Fprintf(w, "%s: unchanged, %d chunks\n", d.Path, d.Chunks)
+		return err
+	default:
+		fmt.Fprintf(w, "%s: %s -> %s, %d of %d chunks reused, %d new\n", d.Path, size(d.OldSize), size(d.NewSize), d.ReusedChunks, d.Chunks, d.NewChunks)
+	}
+
+	if len(d.Changed) > 0 {
+		ranges := []string{}
+		for _, r := range d.Changed {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", r.Start, r.End))
+		}
+
+		fmt.Fprintf(w, "  changed bytes: %s\n", strings.Join(ranges, ", "))
+	}
+
+	_, err := fmt.Fprintf(w, "  transfer: %s\n", size(d.TransferBytes))
+	return err
+}
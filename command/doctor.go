@@ -0,0 +1,55 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewDoctorCmd() *cobra.Command {
+	var noProbe bool
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "checks the installation and configuration of git-bits and suggests fixes",
+		Long: "Checks the filter configuration, the pre-push hook (honoring core.hooksPath), whether git-bits can be " +
+			"found by git and GUI clients, the chunking parameters, the bucket and the AWS credentials. Unless " +
+			"--no-probe is given an object is written to, read from, listed on and deleted from each remote.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+
+			return printChecks(repo.Doctor(!noProbe), os.Stdout)
+		},
+	}
+
+	cmd.Flags().BoolVar(&noProbe, "no-probe", false, "don't write to, read from, list and delete from the remotes")
+	return cmd
+}
+
+//printChecks writes each check with its fix, it fails if any check failed
+func printChecks(checks []bits.Check, w io.Writer) error {
+	failed := 0
+	for _, c := range checks {
+		fmt.Fprintf(w, "%-8s %s: %s\n", c.Status, c.Name, c.Message)
+		if c.Status != bits.CheckOK && c.Fix != "" {
+			fmt.Fprintf(w, "%-8s fix: %s\n", "", c.Fix)
+		}
+
+		if c.Status == bits.CheckFailed {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package command
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewEstimateCmd() *cobra.Command {
+	var include, exclude []string
+	cmd := &cobra.Command{
+		Use:   "estimate <paths or revisions>",
+		Short: "estimates what git-bits would save for files or history, without storing anything",
+		Long: "Splits the given files and directories, or the blobs in the given revisions or revision ranges such as " +
+			"'v1.0..main', in memory with the chunking configuration of the repository. It reports the unique " +
+			"storage, the deduplication ratio and the savings compared to storing each distinct file as a whole.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+
+			filter, err := bits.NewPathFilter(include, exclude)
+			if err != nil {
+				return err
+			}
+
+			est, err := repo.Estimate(args, filter)
+			if err != nil {
+				return err
+			}
+
+			return est.Write(os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&include, "include", "I", nil, "only split paths that match these patterns")
+	cmd.Flags().StringSliceVarP(&exclude, "exclude", "X", nil, "don't split paths that match these patterns")
+	return cmd
+}
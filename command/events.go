@@ -0,0 +1,48 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nerdalize/git-bits/bits"
+	"github.com/spf13/cobra"
+)
+
+//outputFlags select how the progress of chunk operations is written
+type outputFlags struct {
+	json   bool
+	output string
+}
+
+//addOutputFlags adds the --json and --output flags to 'cmd'
+func addOutputFlags(cmd *cobra.Command) *outputFlags {
+	of := &outputFlags{}
+	cmd.Flags().BoolVar(&of.json, "json", false, "write a JSON event per chunk and a summary to stderr, same as --output=json")
+	cmd.Flags().StringVar(&of.output, "output", "text", "format of the progress on stderr: text or json")
+	return of
+}
+
+//run runs 'fn' with the progress of 'repo' written in the selected format
+//and closes 'repo' after. JSON events are followed by a summary that
+//includes the error of 'fn'
+func (of *outputFlags) run(repo *bits.Repository, fn func() error) error {
+	switch {
+	case of.json || of.output == "json":
+	case of.output == "text":
+		err := fn()
+		repo.Close()
+		return err
+	default:
+		return fmt.Errorf("unsupported output format '%s', expected 'text' or 'json'", of.output)
+	}
+
+	events := bits.NewEventStream(os.Stderr)
+	repo.KeyProgressFn = events.KeyProgress
+	err := fn()
+	repo.Close()
+	if serr := events.Close(err); serr != nil && err == nil {
+		err = serr
+	}
+
+	return err
+}
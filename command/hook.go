@@ -0,0 +1,34 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewHookCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hook <name> [args...]",
+		Short: "runs the git-bits part of a git hook",
+		Long: fmt.Sprintf("Runs what git-bits does in the git hook with the given name, the arguments and input that git "+
+			"passed to the hook are handed over as is. Hook managers such as husky or pre-commit can call it with a "+
+			"single line, e.g: git bits hook pre-push \"$@\". Supported hooks: %s", strings.Join(bits.Hooks, ", ")),
+		Args:               cobra.MinimumNArgs(1),
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+
+			return repo.RunHook(args[0], args[1:], os.Stdin, os.Stdout)
+		},
+	}
+
+	return cmd
+}
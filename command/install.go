@@ -3,9 +3,9 @@ package command
 import (
 	"bufio"
 	"fmt"
+	"net/url"
 	"os"
 	"strings"
-	"syscall"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
@@ -13,11 +13,17 @@ import (
 )
 
 func NewInstallCmd() *cobra.Command {
-	var bucket, remote string
+	var bucket, remote, remoteURL string
+	var pullHooks, noPull, yes bool
 	
 	cmd := &cobra.Command{
 		Use:   "install",
 		Short: "configures filters, create pre-push hook and pull chunks",
+		Long: "Configures the filters, adds git-bits to the pre-push hook and pulls chunks. The bucket and remote url " +
+			"fall back to the GIT_BITS_BUCKET and GIT_BITS_S3_ENDPOINT environment variables, a missing bucket is only " +
+			"asked for in a terminal without --yes, an already configured bucket is kept. With --remote the bucket of " +
+			"a named remote is configured, paths select it with the 'bits-remote' attribute. Credentials are never asked for, they are read from the environment, " +
+			"the AWS configuration files or the bits configuration, see 'git bits config'.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			wd, err := os.Getwd()
 			if err != nil {
@@ -29,42 +35,81 @@ func NewInstallCmd() *cobra.Command {
 				return fmt.Errorf("failed to setup repository: %v", err)
 			}
 
+			defer repo.Close()
+			//the default remote has no name, named remotes keep the default bucket
+			name := remote
+			if name == "origin" {
+				name = ""
+			}
+
 			conf := bits.DefaultConf()
-			
-			if bucket == "" {
-				conf.AWSS3BucketName, err = askInput("In which AWS S3 bucket would you like to store chunks? ")
+			conf.AWSS3BucketName = repo.Bucket("")
+			remoteBucket := flagOrEnv(bucket, "GIT_BITS_BUCKET")
+			if remoteBucket == "" {
+				remoteBucket = repo.Bucket(name)
+			}
+
+			conf.S3Endpoint = flagOrEnv(remoteURL, "GIT_BITS_S3_ENDPOINT")
+			if conf.S3Endpoint != "" {
+				u, err := url.Parse(conf.S3Endpoint)
+				if err != nil || u.Scheme == "" || u.Host == "" {
+					return fmt.Errorf("invalid remote url '%s', expected a url such as 'https://s3.example.com'", conf.S3Endpoint)
+				}
+			}
+
+			if remoteBucket == "" {
+				if yes || !term.IsTerminal(int(os.Stdin.Fd())) {
+					return fmt.Errorf("no bucket given, use --bucket or set GIT_BITS_BUCKET")
+				}
+
+				remoteBucket, err = askInput("In which AWS S3 bucket would you like to store chunks? ")
 				if err != nil {
 					return fmt.Errorf("failed to get bucket input: %v", err)
 				}
-			} else {
-				conf.AWSS3BucketName = bucket
 			}
 
-			conf.AWSAccessKeyID, err = askInput("What is your AWS Access Key ID? ")
-			if err != nil {
-				return fmt.Errorf("failed to get access key input: %v", err)
+			if name == "" {
+				conf.AWSS3BucketName = remoteBucket
+			} else {
+				conf.Remotes = map[string]string{name: remoteBucket}
 			}
 
-			conf.AWSSecretAccessKey, err = askSecret("What is your AWS Secret Key? (input will be hidden) ")
+			err = repo.InstallWith(os.Stdout, conf, !noPull)
 			if err != nil {
-				return fmt.Errorf("failed to get secret key input: %v", err)
+				return fmt.Errorf("failed to install: %v", err)
 			}
 
-			err = repo.Install(os.Stdout, conf)
-			if err != nil {
-				return fmt.Errorf("failed to install: %v", err)
+			if pullHooks {
+				err = repo.InstallHooks(os.Stdout, bits.PullHooks...)
+				if err != nil {
+					return fmt.Errorf("failed to install hooks: %v", err)
+				}
 			}
 
 			return nil
 		},
 	}
 
-	cmd.Flags().StringVarP(&bucket, "bucket", "b", "", "name of the s3 bucket used as a chunk remote")
-	cmd.Flags().StringVarP(&remote, "remote", "r", "origin", "git remote that will be configured for chunk storage")
+	cmd.Flags().StringVarP(&bucket, "bucket", "b", "", "name of the s3 bucket used as a chunk remote, defaults to $GIT_BITS_BUCKET")
+	cmd.Flags().StringVarP(&remote, "remote", "r", "origin", "named remote whose bucket is configured as 'bits.<remote>.aws-s3-bucket-name', 'origin' is the default bucket")
+	cmd.Flags().StringVar(&remoteURL, "remote-url", "", "url of an s3 compatible endpoint to use instead of aws, defaults to $GIT_BITS_S3_ENDPOINT")
+	cmd.Flags().BoolVar(&noPull, "no-pull", false, "don't pull chunks to write the files in the working tree")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "never prompt, fail if a required value is missing")
+	cmd.Flags().BoolVar(&pullHooks, "pull-hooks", false, "add post-checkout, post-merge and post-rewrite hooks that pull the files they changed")
 
 	return cmd
 }
 
+//flagOrEnv returns the value of a flag or, if it wasn't given, the value of
+//environment variable 'env'
+func flagOrEnv(val, env string) string {
+	if val != "" {
+		return val
+	}
+
+	return os.Getenv(env)
+}
+
 func askInput(prompt string) (string, error) {
 	fmt.Print(prompt)
 	reader := bufio.NewReader(os.Stdin)
@@ -74,13 +119,3 @@ func askInput(prompt string) (string, error) {
 	}
 	return strings.TrimSpace(input), nil
 }
-
-func askSecret(prompt string) (string, error) {
-	fmt.Print(prompt)
-	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
-	if err != nil {
-		return "", err
-	}
-	fmt.Println()
-	return string(bytePassword), nil
-}
@@ -32,6 +32,17 @@ func TestNewInstallCmd(t *testing.T) {
 	}
 }
 
+func TestFlagOrEnv(t *testing.T) {
+	t.Setenv("GIT_BITS_BUCKET", "env-bucket")
+	if val := flagOrEnv("flag-bucket", "GIT_BITS_BUCKET"); val != "flag-bucket" {
+		t.Errorf("Expected the flag to take precedence, got %s", val)
+	}
+
+	if val := flagOrEnv("", "GIT_BITS_BUCKET"); val != "env-bucket" {
+		t.Errorf("Expected the environment variable, got %s", val)
+	}
+}
+
 func TestAskInputValidation(t *testing.T) {
 	// Test with mock input - this is a simple validation test
 	// Real input testing would require more complex mocking
@@ -0,0 +1,40 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewMergeDriverCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "merge-driver <base> <ours> <theirs> <path>",
+		Short: "merges pointers as the git merge driver",
+		Long: "Merges the pointers of a file as the 'bits' merge driver that install configures for paths with the " +
+			"'merge=bits' attribute. If only one side changed the file that side is taken, otherwise the file keeps " +
+			"our pointer and both versions are written next to it for manual resolution.",
+		Args:         cobra.ExactArgs(4),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+
+			clean, err := repo.Merge(args[0], args[1], args[2], args[3], os.Stderr)
+			if err != nil {
+				return err
+			}
+
+			if !clean {
+				return fmt.Errorf("merge conflict in '%s'", args[3])
+			}
+
+			return nil
+		},
+	}
+}
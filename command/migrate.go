@@ -0,0 +1,114 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "moves content between git-bits and other storage",
+	}
+
+	cmd.AddCommand(
+		NewMigrateImportCmd(),
+		NewMigrateImportLFSCmd(),
+		NewMigrateExportCmd(),
+	)
+	return cmd
+}
+
+func NewMigrateImportCmd() *cobra.Command {
+	var include, exclude []string
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "import [refs...]",
+		Short: "rewrites history to move matching blobs into git-bits",
+		Long: "Rewrites the commits of the given refs (the current branch by default) such that blobs whose path " +
+			"matches an --include pattern, and no --exclude pattern, are replaced by git-bits pointers. A 'filter=bits " +
+			"diff=bits merge=bits' entry is added to .gitattributes for each include pattern and a '-filter' entry for each exclude pattern, " +
+			"blobs are split with the attributes of the commit they are in. The old and new commit of each rewritten commit are printed, " +
+			"original refs are kept under refs/original/. Annotated tags are copied to point to the rewritten commit, " +
+			"the signatures of rewritten commits and tags are dropped.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			if dryRun {
+				return repo.ImportHistoryPlan(include, exclude, args, os.Stdout)
+			}
+			return repo.ImportHistory(include, exclude, args, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&include, "include", "I", nil, "patterns of paths to import, e.g. '*.bin'")
+	cmd.Flags().StringSliceVarP(&exclude, "exclude", "X", nil, "patterns of paths not to import")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "list the blobs that would be imported instead of rewriting history")
+	return cmd
+}
+
+func NewMigrateImportLFSCmd() *cobra.Command {
+	var history bool
+	cmd := &cobra.Command{
+		Use:   "import-lfs [refs...]",
+		Short: "converts Git LFS pointers to git-bits pointers",
+		Long: "Converts Git LFS pointers in the index to git-bits pointers using the objects in the local LFS store " +
+			"and changes 'filter=lfs' to 'filter=bits' in .gitattributes. With --history the commits of the " +
+			"given refs (the current branch by default) are rewritten instead and the old and new commit of each " +
+			"rewritten commit are printed. Annotated tags are copied to point to the rewritten commit, the " +
+			"signatures of rewritten commits and tags are dropped.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !history && len(args) > 0 {
+				return fmt.Errorf("refs can only be given with --history")
+			}
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			if history {
+				return repo.ImportLFSHistory(args, os.Stdout)
+			}
+			return repo.ImportLFS(os.Stdout)
+		},
+	}
+
+	cmd.Flags().BoolVar(&history, "history", false, "rewrite the commits of the given refs instead of the index")
+	return cmd
+}
+
+func NewMigrateExportCmd() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "export [refs...]",
+		Short: "rewrites history to replace git-bits pointers with their content",
+		Long: "Rewrites the commits of the given refs (the current branch by default) such that every git-bits " +
+			"pointer is replaced by the content it points to, fetching chunks as needed, removes the bits " +
+			"filter from .gitattributes and removes .bitsconfig. The result works without git-bits installed. The old and new commit " +
+			"of each rewritten commit are printed, original refs are kept under refs/original/. Annotated tags are copied " +
+			"to point to the rewritten commit, the signatures of rewritten commits and tags are dropped.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			if dryRun {
+				return repo.ExportHistoryPlan(args, os.Stdout)
+			}
+			return repo.ExportHistory(args, os.Stdout)
+		},
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "list the pointers that would be replaced by their content instead of rewriting history")
+	return cmd
+}
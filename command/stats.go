@@ -0,0 +1,41 @@
+package command
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewStatsCmd() *cobra.Command {
+	var offline bool
+	cmd := &cobra.Command{
+		Use:   "stats [refs]",
+		Short: "shows how well the content of tracked files is deduplicated",
+		Long: "Reports the size of all versions of tracked files in the given refs (all refs by default), the size of " +
+			"their unique chunks and the deduplication ratio, a histogram of chunk sizes, a breakdown per tracked " +
+			"pattern, the size of the local store and the number of objects on the default and named remotes. Sizes " +
+			"that old pointers don't record and that are taken from a pack on the remote are labeled as estimates.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 0 {
+				args = []string{"--all"}
+			}
+
+			stats, err := repo.Stats(args, !offline)
+			if err != nil {
+				return err
+			}
+
+			return stats.Write(os.Stdout)
+		},
+	}
+
+	cmd.Flags().BoolVar(&offline, "offline", false, "don't count the objects on the remote")
+	return cmd
+}
@@ -0,0 +1,74 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+	bolt "go.etcd.io/bbolt"
+)
+
+func NewStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "shows the state of each file that is tracked with the bits filter",
+		Long: "Lists each file that is tracked with the bits filter with its state: 'pointer' when the working tree " +
+			"holds the pointer, 'materialized' when it holds the content, 'modified' when it holds unstaged changes, " +
+			"'missing' or 'unsplit' when the index holds content instead of a pointer. For each file the number of " +
+			"chunks stored locally and known to be stored remotely, as of the last push, are shown.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			store, err := repo.LocalStore()
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			return printStatus(repo, store, os.Stdout)
+		},
+	}
+}
+
+//printStatus writes a table of tracked files followed by a summary
+func printStatus(repo *bits.Repository, store *bolt.DB, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	sum, err := repo.Status(store, func(st bits.FileStatus) error {
+		size := "?"
+		if st.Size >= 0 {
+			size = humanize.Bytes(uint64(st.Size))
+		}
+
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%d chunks\t%d local\t%d remote\t%s\n", st.State, size, st.Chunks, st.LocalChunks, st.RemoteChunks, st.Path)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	tw.Flush()
+	total, states := 0, []string{}
+	for _, state := range []bits.FileState{bits.MaterializedState, bits.PointerState, bits.ModifiedState, bits.MissingState, bits.UnsplitState} {
+		if n := sum.Files[state]; n > 0 {
+			total += n
+			states = append(states, fmt.Sprintf("%d %s", n, state))
+		}
+	}
+
+	if total == 0 {
+		fmt.Fprintf(w, "no files are tracked with the bits filter\n")
+		return nil
+	}
+
+	fmt.Fprintf(w, "\n%d files: %s\n", total, strings.Join(states, ", "))
+	fmt.Fprintf(w, "%d chunks (%s) waiting to be pushed\n", sum.UnpushedChunks, humanize.Bytes(uint64(sum.UnpushedBytes)))
+	return nil
+}
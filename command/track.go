@@ -0,0 +1,85 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewTrackCmd() *cobra.Command {
+	var restage bool
+	cmd := &cobra.Command{
+		Use:   "track [patterns...]",
+		Short: "tracks files matching the patterns with the bits filter",
+		Long: "Adds 'filter=bits diff=bits merge=bits' for each pattern to the .gitattributes file in the root of the " +
+			"repository, other attributes of a pattern are kept. Without patterns the tracked patterns are listed. With --restage files " +
+			"that are already committed as is are staged again so they get split. The 'bits-chunk-size' attribute of a " +
+			"pattern sets its average chunk size, it must be a power of two such as '64KiB'.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			if len(args) == 0 {
+				return listTracked(repo)
+			}
+			err = repo.Track(args, os.Stdout)
+			if err != nil || !restage {
+				return err
+			}
+			return repo.Restage(os.Stdout)
+		},
+	}
+
+	cmd.Flags().BoolVar(&restage, "restage", false, "stage matching files again so their content gets split")
+	return cmd
+}
+
+func NewUntrackCmd() *cobra.Command {
+	var restage bool
+	cmd := &cobra.Command{
+		Use:   "untrack <patterns...>",
+		Short: "stops tracking files matching the patterns with the bits filter",
+		Long: "Removes 'filter=bits' and any bits attributes for each pattern from the .gitattributes file in the " +
+			"root of the repository. With --restage files that are committed as pointers are staged again with " +
+			"their content.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			err = repo.Untrack(args, os.Stdout)
+			if err != nil || !restage {
+				return err
+			}
+			return repo.Restage(os.Stdout)
+		},
+	}
+
+	cmd.Flags().BoolVar(&restage, "restage", false, "stage files that are no longer tracked again with their content")
+	return cmd
+}
+
+//listTracked prints the patterns that use the bits filter
+func listTracked(repo *bits.Repository) error {
+	patterns, err := repo.TrackedPatterns()
+	if err != nil {
+		return err
+	}
+	if len(patterns) == 0 {
+		fmt.Println("no patterns are tracked with the bits filter")
+		return nil
+	}
+	fmt.Println("tracked patterns:")
+	for _, p := range patterns {
+		fmt.Printf("    %s (%s)\n", p.Pattern, p.File)
+	}
+	return nil
+}
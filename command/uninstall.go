@@ -0,0 +1,36 @@
+package command
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/nerdalize/git-bits/bits"
+)
+
+func NewUninstallCmd() *cobra.Command {
+	var materialize, dryRun bool
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "removes filters, bits configuration and the pre-push hook",
+		Long: "Removes the bits filter and all bits configuration from the local git configuration and removes the " +
+			"pre-push hook if git-bits wrote it, other commands in the hook are kept. With --materialize the " +
+			"pointers in the working tree are replaced by their content first. The tracked .bitsconfig and " +
+			".gitattributes files are left untouched, uninstall says how to remove .bitsconfig.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, _ := os.Getwd()
+			repo, err := bits.NewRepository(wd, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer repo.Close()
+			if dryRun {
+				return repo.UninstallPlan(os.Stdout, materialize)
+			}
+			return repo.Uninstall(os.Stdout, materialize)
+		},
+	}
+
+	cmd.Flags().BoolVar(&materialize, "materialize", false, "replace pointers in the working tree with their content first")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "list what would be removed, and fetched and written with --materialize, instead of doing it")
+	return cmd
+}
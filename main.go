@@ -22,10 +22,26 @@ func main() {
 		command.NewScanCmd(),
 		command.NewSplitCmd(),
 		command.NewInstallCmd(),
+		command.NewUninstallCmd(),
+		command.NewHookCmd(),
 		command.NewFetchCmd(),
 		command.NewPullCmd(),
+		command.NewCloneCmd(),
 		command.NewPushCmd(),
 		command.NewCombineCmd(),
+		command.NewSmudgeCmd(),
+		command.NewMigrateCmd(),
+		command.NewStatusCmd(),
+		command.NewStatsCmd(),
+		command.NewEstimateCmd(),
+		command.NewDoctorCmd(),
+		command.NewConfigCmd(),
+		command.NewCatCmd(),
+		command.NewDiffCmd(),
+		command.NewTextconvCmd(),
+		command.NewMergeDriverCmd(),
+		command.NewTrackCmd(),
+		command.NewUntrackCmd(),
 	)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,306 @@
+//Package pointer reads and writes the pointer files that git stores in place
+//of the content of files that are split into chunks
+package pointer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//KeySize is the size of each chunk key and of the whole-file hash
+const KeySize = 32
+
+//Version is the pointer format that is written by Encode
+const Version = 2
+
+var (
+	//Header starts every pointer file, it is exactly as long as a hex
+	//encoded key line in the first version of the format
+	Header = []byte("--- to use this file decode it with the 'git-bits' extension ---\n")
+
+	//Footer ends every pointer file
+	Footer = []byte("----------------------- end of chunks --------------------------\n")
+
+	//MinSize is the size of the smallest possible pointer file, blobs that
+	//are smaller can be skipped without reading their content
+	MinSize = int64(len(Header) + len(Footer))
+)
+
+//Key identifies a chunk, it is the hash of its plain content
+type Key [KeySize]byte
+
+//Chunk describes a part of the original file
+type Chunk struct {
+	Key Key
+
+	//the plain size of the chunk, unknown (-1) in version 1 pointers
+	Size int64
+}
+
+//Chunking records the parameters that were used to split the file
+type Chunking struct {
+	Algorithm string
+	Scope     uint64
+	MinSize   uint64
+	AvgSize   uint64
+	MaxSize   uint64
+}
+
+//Pointer is the decoded content of a pointer file
+type Pointer struct {
+
+	//format version, 1 for pointers that only list keys
+	Version int
+
+	//size of the original file, unknown (-1) in version 1 pointers
+	Size int64
+
+	//sha256 of the original file content
+	Hash Key
+
+	//how the file was split into chunks
+	Chunking Chunking
+
+	//the chunks of the file in order
+	Chunks []Chunk
+}
+
+//IsPointer returns whether 'data' starts with the pointer header
+func IsPointer(data []byte) bool {
+	return bytes.HasPrefix(data, Header)
+}
+
+//Decode reads a pointer of any known version from reader 'r'
+func Decode(r io.Reader) (p *Pointer, err error) {
+	s := bufio.NewScanner(r)
+	if !s.Scan() || s.Text() != string(Header[:len(Header)-1]) {
+		if err = s.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read pointer header: %v", err)
+		}
+
+		return nil, fmt.Errorf("content doesn't start with the pointer header")
+	}
+
+	p = &Pointer{Version: 1, Size: -1}
+	seen := map[string]bool{}
+	footer := string(Footer[:len(Footer)-1])
+	line := 1
+	for s.Scan() {
+		line++
+		text := s.Text()
+		if text == footer {
+			if p.Version > 1 {
+				err = p.validate(seen)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return p, nil
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("unexpected empty line %d", line)
+		}
+
+		//the version line directly follows the header in all but the first
+		//version of the format
+		if line == 2 && fields[0] == "version" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("unexpected version line '%s'", text)
+			}
+
+			p.Version, err = strconv.Atoi(fields[1])
+			if err != nil || p.Version < 2 {
+				return nil, fmt.Errorf("unexpected pointer version '%s'", fields[1])
+			}
+
+			if p.Version > Version {
+				return nil, fmt.Errorf("pointer version %d is not supported, the newest known version is %d: please upgrade git-bits", p.Version, Version)
+			}
+
+			continue
+		}
+
+		if p.Version == 1 {
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("unexpected content on line %d, expected a single chunk key", line)
+			}
+
+			c := Chunk{Size: -1}
+			c.Key, err = decodeKey(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid chunk key on line %d: %v", line, err)
+			}
+
+			p.Chunks = append(p.Chunks, c)
+			continue
+		}
+
+		err = p.decodeLine(fields, seen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d '%s': %v", line, text, err)
+		}
+	}
+
+	if err = s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pointer: %v", err)
+	}
+
+	return nil, fmt.Errorf("pointer ended without a footer")
+}
+
+//Parse decodes the pointer in 'data' like Decode but also rejects content
+//that follows the footer, such as a second pointer or conflict markers
+func Parse(data []byte) (p *Pointer, err error) {
+	p, err = Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if i := bytes.Index(data, Footer); i+len(Footer) != len(data) {
+		return nil, fmt.Errorf("unexpected content after the pointer footer")
+	}
+
+	return p, nil
+}
+
+//Equal returns whether pointer 'o' describes the same content as 'p'
+func (p *Pointer) Equal(o *Pointer) bool {
+	if p.Size != o.Size || p.Hash != o.Hash || len(p.Chunks) != len(o.Chunks) {
+		return false
+	}
+
+	for i, c := range p.Chunks {
+		if c.Key != o.Chunks[i].Key {
+			return false
+		}
+	}
+
+	return true
+}
+
+//decodeLine interprets a line of a version 2 pointer, 'seen' records the
+//fields that were decoded such that each appears only once
+func (p *Pointer) decodeLine(fields []string, seen map[string]bool) (err error) {
+	switch fields[0] {
+	case "size", "sha256", "chunker":
+		if seen[fields[0]] {
+			return fmt.Errorf("duplicate '%s' line", fields[0])
+		}
+
+		seen[fields[0]] = true
+	}
+
+	switch fields[0] {
+	case "size":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected a single size")
+		}
+
+		p.Size, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || p.Size < 0 {
+			return fmt.Errorf("unexpected size '%s'", fields[1])
+		}
+	case "sha256":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected a single hash")
+		}
+
+		p.Hash, err = decodeKey(fields[1])
+	case "chunker":
+		if len(fields) != 6 {
+			return fmt.Errorf("expected an algorithm, scope and min, avg and max size")
+		}
+
+		p.Chunking.Algorithm = fields[1]
+		vals := []*uint64{&p.Chunking.Scope, &p.Chunking.MinSize, &p.Chunking.AvgSize, &p.Chunking.MaxSize}
+		for i, v := range vals {
+			*v, err = strconv.ParseUint(fields[i+2], 10, 64)
+			if err != nil {
+				return fmt.Errorf("unexpected chunking parameter '%s'", fields[i+2])
+			}
+		}
+	default:
+		if len(fields) != 2 {
+			return fmt.Errorf("expected a chunk key followed by its size")
+		}
+
+		c := Chunk{}
+		c.Key, err = decodeKey(fields[0])
+		if err != nil {
+			return err
+		}
+
+		c.Size, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || c.Size < 0 {
+			return fmt.Errorf("unexpected chunk size '%s'", fields[1])
+		}
+
+		p.Chunks = append(p.Chunks, c)
+	}
+
+	return err
+}
+
+//validate checks whether the pointer recorded the size, hash and chunker
+//lines in 'seen' and the chunk sizes add up to the file size
+func (p *Pointer) validate(seen map[string]bool) error {
+	if p.Size < 0 {
+		return fmt.Errorf("pointer doesn't record the file size")
+	}
+
+	for _, name := range []string{"sha256", "chunker"} {
+		if !seen[name] {
+			return fmt.Errorf("pointer doesn't record the '%s' line", name)
+		}
+	}
+
+	total := int64(0)
+	for _, c := range p.Chunks {
+		total += c.Size
+	}
+
+	if total != p.Size {
+		return fmt.Errorf("chunk sizes add up to %d bytes while the file size is %d", total, p.Size)
+	}
+
+	return nil
+}
+
+//Encode writes the pointer in the newest format to writer 'w'
+func (p *Pointer) Encode(w io.Writer) (err error) {
+	bufw := bufio.NewWriter(w)
+	bufw.Write(Header)
+	fmt.Fprintf(bufw, "version %d\n", Version)
+	fmt.Fprintf(bufw, "size %d\n", p.Size)
+	fmt.Fprintf(bufw, "sha256 %x\n", p.Hash)
+	fmt.Fprintf(bufw, "chunker %s %d %d %d %d\n", p.Chunking.Algorithm, p.Chunking.Scope, p.Chunking.MinSize, p.Chunking.AvgSize, p.Chunking.MaxSize)
+	for _, c := range p.Chunks {
+		fmt.Fprintf(bufw, "%x %d\n", c.Key, c.Size)
+	}
+
+	bufw.Write(Footer)
+	return bufw.Flush()
+}
+
+//decodeKey decodes a hex encoded key
+func decodeKey(s string) (k Key, err error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return k, fmt.Errorf("failed to decode '%s' as hex: %v", s, err)
+	}
+
+	if len(data) != KeySize {
+		return k, fmt.Errorf("decoded key '%s' has an invalid length %d, expected %d", s, len(data), KeySize)
+	}
+
+	copy(k[:], data)
+	return k, nil
+}
@@ -0,0 +1,101 @@
+package pointer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	p := &Pointer{
+		Version:  Version,
+		Size:     12,
+		Hash:     Key{0x01, 0x02},
+		Chunking: Chunking{Algorithm: "rabin", Scope: 17, MinSize: 64, AvgSize: 128, MaxSize: 256},
+		Chunks:   []Chunk{{Key{0xaa}, 5}, {Key{0xbb}, 7}},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := p.Encode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsPointer(buf.Bytes()) {
+		t.Error("encoded pointer should start with the header")
+	}
+
+	dp, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dp.Version != p.Version || dp.Size != p.Size || dp.Hash != p.Hash || dp.Chunking != p.Chunking || len(dp.Chunks) != 2 || dp.Chunks[1] != p.Chunks[1] {
+		t.Errorf("decoded pointer differs, got %+v expected %+v", dp, p)
+	}
+}
+
+func TestDecodeV1(t *testing.T) {
+	v1 := string(Header) +
+		"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef\n" +
+		"fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210\n" +
+		string(Footer)
+
+	p, err := Decode(strings.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Version != 1 || p.Size != -1 || len(p.Chunks) != 2 || p.Chunks[0].Size != -1 || p.Chunks[1].Key[0] != 0xfe {
+		t.Errorf("unexpected version 1 pointer: %+v", p)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	for name, content := range map[string]string{
+		"no header":     "version 2\n" + string(Footer),
+		"no footer":     string(Header) + key + "\n",
+		"short key":     string(Header) + "0123456789abcdef\n" + string(Footer),
+		"newer version": string(Header) + "version 3\n" + string(Footer),
+		"size mismatch": string(Header) + "version 2\nsize 10\n" + key + " 9\n" + string(Footer),
+		"missing size":  string(Header) + "version 2\n" + key + " 9\n" + string(Footer),
+		"mixed keys":    string(Header) + "version 2\nsize 9\n" + key + "\n" + string(Footer),
+		"missing hash":  string(Header) + "version 2\nsize 9\nchunker rabin 1 64 128 256\n" + key + " 9\n" + string(Footer),
+		"no chunker":    string(Header) + "version 2\nsize 9\nsha256 " + key + "\n" + key + " 9\n" + string(Footer),
+		"two sizes":     string(Header) + "version 2\nsize 9\nsize 9\nsha256 " + key + "\nchunker rabin 1 64 128 256\n" + key + " 9\n" + string(Footer),
+		"two hashes":    string(Header) + "version 2\nsize 9\nsha256 " + key + "\nsha256 " + key + "\nchunker rabin 1 64 128 256\n" + key + " 9\n" + string(Footer),
+	} {
+		_, err := Decode(strings.NewReader(content))
+		if err == nil {
+			t.Errorf("expected pointer with %s to be rejected", name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	p := &Pointer{Version: Version, Size: 9, Chunking: Chunking{"rabin", 1, 64, 128, 256}, Chunks: []Chunk{{Key: Key{1}, Size: 9}}}
+	buf := bytes.NewBuffer(nil)
+	err := p.Encode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	act, err := Parse(buf.Bytes())
+	if err != nil || !act.Equal(p) {
+		t.Errorf("expected the pointer to parse, got %+v: %v", act, err)
+	}
+
+	for name, content := range map[string]string{
+		"two pointers":     buf.String() + buf.String(),
+		"conflict markers": buf.String() + ">>>>>>> theirs\n",
+	} {
+		if _, err = Parse([]byte(content)); err == nil {
+			t.Errorf("expected pointer with %s to be rejected", name)
+		}
+	}
+
+	if o := (&Pointer{Version: Version, Size: 9, Chunks: []Chunk{{Key: Key{2}, Size: 9}}}); p.Equal(o) {
+		t.Error("expected pointers with other chunks to differ")
+	}
+}